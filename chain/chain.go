@@ -0,0 +1,61 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+var ErrUnsupportedChain = errors.New("unsupported chain")
+
+// Transaction 鏈上交易的查詢結果，Transfers 為交易內所有代幣轉帳。
+type Transaction struct {
+	Hash          string
+	BlockNumber   uint64
+	Confirmations uint64
+	Success       bool
+	Transfers     []Transfer
+}
+
+// Transfer 一筆代幣轉帳，Token 為代幣合約地址，Amount 已依代幣精度換算。
+type Transfer struct {
+	Token  string
+	From   string
+	To     string
+	Amount decimal.Decimal
+}
+
+type ChainClient interface {
+	GetTransaction(ctx context.Context, txID string) (Transaction, error)
+}
+
+var clients = make(map[string]ChainClient)
+var clientsMu sync.RWMutex
+
+// Register 以鏈名稱註冊客戶端，名稱需與 Product.ContractChain 相同(不分大小寫)。
+func Register(name string, client ChainClient) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients[strings.ToUpper(name)] = client
+}
+
+func GetClient(name string) (ChainClient, error) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	client, ok := clients[strings.ToUpper(name)]
+	if !ok {
+		return nil, ErrUnsupportedChain
+	}
+
+	return client, nil
+}
+
+// SameAddress 比對兩個地址，EVM 地址不分大小寫。
+func SameAddress(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
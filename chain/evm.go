@@ -0,0 +1,243 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ERC-20 Transfer(address,address,uint256) 事件的 topic
+const transferEventTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// ERC-20 decimals() 的函式選擇器
+const decimalsSelector = "0x313ce567"
+
+// EVMClient 透過 JSON-RPC 查詢 EVM 相容鏈的交易收據並解析 ERC-20 轉帳事件。
+type EVMClient struct {
+	url        string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	decimals map[string]int32
+}
+
+func NewEVMClient(url string) *EVMClient {
+	return &EVMClient{
+		url:        url,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+		decimals:   make(map[string]int32),
+	}
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type evmReceipt struct {
+	TransactionHash string   `json:"transactionHash"`
+	BlockNumber     string   `json:"blockNumber"`
+	Status          string   `json:"status"`
+	Logs            []evmLog `json:"logs"`
+}
+
+type evmLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+func (client *EVMClient) GetTransaction(ctx context.Context, txID string) (Transaction, error) {
+	var tx Transaction
+	var receipt *evmReceipt
+
+	err := client.call(ctx, "eth_getTransactionReceipt", []interface{}{txID}, &receipt)
+	if err != nil {
+		return tx, err
+	}
+
+	// 尚未打包或不存在的交易沒有收據
+	if receipt == nil || receipt.BlockNumber == "" {
+		return tx, ErrTransactionNotFound
+	}
+
+	blockNumber, err := parseHexUint(receipt.BlockNumber)
+	if err != nil {
+		return tx, err
+	}
+
+	var latestHex string
+	err = client.call(ctx, "eth_blockNumber", []interface{}{}, &latestHex)
+	if err != nil {
+		return tx, err
+	}
+
+	latest, err := parseHexUint(latestHex)
+	if err != nil {
+		return tx, err
+	}
+
+	tx = Transaction{
+		Hash:        receipt.TransactionHash,
+		BlockNumber: blockNumber,
+		Success:     receipt.Status == "0x1",
+	}
+	if latest >= blockNumber {
+		tx.Confirmations = latest - blockNumber + 1
+	}
+
+	for _, log := range receipt.Logs {
+		transfer, ok, err := client.parseTransferLog(ctx, log)
+		if err != nil {
+			return tx, err
+		}
+		if ok {
+			tx.Transfers = append(tx.Transfers, transfer)
+		}
+	}
+
+	return tx, nil
+}
+
+func (client *EVMClient) parseTransferLog(ctx context.Context, log evmLog) (Transfer, bool, error) {
+	var transfer Transfer
+
+	// ERC-721 的 Transfer 事件有 4 個 topic，只處理 ERC-20
+	if len(log.Topics) != 3 || !strings.EqualFold(log.Topics[0], transferEventTopic) {
+		return transfer, false, nil
+	}
+
+	raw, ok := new(big.Int).SetString(strings.TrimPrefix(log.Data, "0x"), 16)
+	if !ok {
+		return transfer, false, fmt.Errorf("invalid transfer amount %q", log.Data)
+	}
+
+	decimals, err := client.tokenDecimals(ctx, log.Address)
+	if err != nil {
+		return transfer, false, err
+	}
+
+	transfer = Transfer{
+		Token:  log.Address,
+		From:   topicToAddress(log.Topics[1]),
+		To:     topicToAddress(log.Topics[2]),
+		Amount: decimal.NewFromBigInt(raw, -decimals),
+	}
+
+	return transfer, true, nil
+}
+
+func (client *EVMClient) tokenDecimals(ctx context.Context, token string) (int32, error) {
+	key := strings.ToLower(token)
+
+	client.mu.Lock()
+	decimals, ok := client.decimals[key]
+	client.mu.Unlock()
+	if ok {
+		return decimals, nil
+	}
+
+	var result string
+	call := map[string]string{"to": token, "data": decimalsSelector}
+	err := client.call(ctx, "eth_call", []interface{}{call, "latest"}, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := parseHexUint(result)
+	if err != nil {
+		return 0, err
+	}
+
+	decimals = int32(value)
+	client.mu.Lock()
+	client.decimals[key] = decimals
+	client.mu.Unlock()
+
+	return decimals, nil
+}
+
+func (client *EVMClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected http status %d", method, resp.StatusCode)
+	}
+
+	var rpcResp rpcResponse
+	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if err != nil {
+		return err
+	}
+
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s: rpc error %d %s", method, rpcResp.Error.Code, rpcResp.Error.Message)
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+func parseHexUint(value string) (uint64, error) {
+	trimmed := strings.TrimPrefix(value, "0x")
+	if trimmed == "" {
+		return 0, errors.New("empty hex value")
+	}
+
+	number, ok := new(big.Int).SetString(trimmed, 16)
+	if !ok || !number.IsUint64() {
+		return 0, fmt.Errorf("invalid hex value %q", value)
+	}
+
+	return number.Uint64(), nil
+}
+
+// topicToAddress 取 32 bytes topic 的後 20 bytes 作為地址
+func topicToAddress(topic string) string {
+	trimmed := strings.TrimPrefix(topic, "0x")
+	if len(trimmed) > 40 {
+		trimmed = trimmed[len(trimmed)-40:]
+	}
+
+	return "0x" + strings.ToLower(trimmed)
+}
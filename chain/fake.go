@@ -0,0 +1,37 @@
+package chain
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// FakeClient 記憶體內的鏈客戶端，供測試與本機開發使用。
+type FakeClient struct {
+	mu           sync.RWMutex
+	transactions map[string]Transaction
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		transactions: make(map[string]Transaction),
+	}
+}
+
+func (client *FakeClient) AddTransaction(tx Transaction) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.transactions[strings.ToLower(tx.Hash)] = tx
+}
+
+func (client *FakeClient) GetTransaction(ctx context.Context, txID string) (Transaction, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	tx, ok := client.transactions[strings.ToLower(txID)]
+	if !ok {
+		return tx, ErrTransactionNotFound
+	}
+
+	return tx, nil
+}
@@ -1,12 +1,17 @@
 package controllers
 
 import (
+	"errors"
 	"invar/middlewares"
+	"invar/models"
 	"invar/services"
 	"invar/status"
+	"invar/utils"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // AuthenticateAdmin godoc
@@ -18,6 +23,7 @@ import (
 // @Param        account   body      string  true   "帳號"
 // @Param        password  body      string  true   "密碼"
 // @Param        tfa       body      string  false  "二階段驗證碼"
+// @Param        recovery_code  body  string  false  "救援碼，無法使用二階段驗證碼時使用"
 // @Success      200    {object}  status.ResponseWtihData{data=string}
 // @Failure      400             {object}  status.Response
 // @Failure      500             {object}  status.Response
@@ -34,15 +40,12 @@ func AuthenticateAdmin(c *gin.Context) {
 		return
 	}
 
-	roleID, errCode := checkLoginInfo(roleType, data.Account, data.Password, data.TFA)
-	if errCode != status.Success {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(errCode),
-		})
+	roleID, ok := authenticateRole(c, roleType, data, false)
+	if !ok {
 		return
 	}
 
-	refreshToken, err := services.GenerateRefreshToken(roleType, roleID, c.ClientIP())
+	refreshToken, err := services.GenerateRefreshToken(roleType, roleID, c.ClientIP(), c.Request.UserAgent())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -51,7 +54,13 @@ func AuthenticateAdmin(c *gin.Context) {
 		return
 	}
 
-	accessToken := services.GenerateAccessToken(roleType, roleID)
+	accessToken, err := services.GenerateAccessToken(roleType, roleID, refreshToken.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
 
 	c.SetCookie(middlewares.REFRESH_TOKEN, refreshToken.Token, 3600, "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{
@@ -92,8 +101,8 @@ func ChangeAdminPassword(c *gin.Context) {
 		return
 	}
 
-	adminID := c.GetUint(middlewares.ROLE_ID)
-	if adminID != 0 {
+	adminID := uint(c.GetInt(middlewares.ROLE_ID))
+	if adminID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.NotExistUser),
 		})
@@ -116,39 +125,45 @@ func ChangeAdminPassword(c *gin.Context) {
 		return
 	}
 
-	admin.SetPassword(data.Password)
-	result := services.UpdateAdmin(&admin)
-	if result != nil {
+	err = services.ChangeAdminPassword(&admin, data.Password)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{})
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
 }
 
+// GetAdminTFA godoc
+// @Summary      獲得管理者二階段驗證QRCode
+// @Description  獲得綁定二階段驗證的QRCode，已啟用時無法取得
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]byte}
+// @Failure      400  {object}  status.Response
+// @Failure      403  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/tfa [get]
+// @Security     BearerAuth
 func GetAdminTFA(c *gin.Context) {
-	roleID := c.GetInt(middlewares.ROLE_ID)
-
-	admin, err := services.GetAdminById(uint(roleID))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.ExistUser),
-		})
+	admin, ok := getOperatorAdmin(c)
+	if !ok {
 		return
 	}
 
 	qrCode, err := services.GetAdminTFA(&admin)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondTFA(c, err)
 		return
 	}
 
-	c.JSON(http.StatusBadRequest, gin.H{
-		status.RespStatus: status.NewResponse(status.ExistUser),
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   qrCode,
 	})
 }
@@ -157,11 +172,22 @@ type TFAReq struct {
 	TFA string `json:"tfa"`
 }
 
+// EnableAdminTFA godoc
+// @Summary      管理者啟用二階段驗證
+// @Description  驗證成功後啟用二階段驗證，並回傳只會顯示一次的救援碼
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.ResponseWtihData{data=[]string}
+// @Failure      400  {object}  status.Response
+// @Failure      403  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/enable_tfa [post]
+// @Security     BearerAuth
 func EnableAdminTFA(c *gin.Context) {
 	var data TFAReq
 
-	roleID := c.GetInt(middlewares.ROLE_ID)
-
 	bindErr := c.BindJSON(&data)
 	if bindErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -170,31 +196,128 @@ func EnableAdminTFA(c *gin.Context) {
 		return
 	}
 
-	user, err := services.GetUserById(uint(roleID))
+	admin, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	codes, err := services.EnableAdminTFA(&admin, data.TFA)
 	if err != nil {
+		respondTFA(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   codes,
+	})
+}
+
+// RegenerateAdminRecoveryCodes godoc
+// @Summary      管理者重新產生救援碼
+// @Description  重新產生二階段驗證的救援碼，舊的救援碼全部失效
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.ResponseWtihData{data=[]string}
+// @Failure      400  {object}  status.Response
+// @Failure      403  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/recovery_code [post]
+// @Security     BearerAuth
+func RegenerateAdminRecoveryCodes(c *gin.Context) {
+	var data TFAReq
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
+			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
 		return
 	}
 
-	err = services.EnableUserTFA(&user, data.TFA)
+	admin, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	codes, err := services.RegenerateAdminRecoveryCodes(&admin, data.TFA)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondTFA(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   codes,
 	})
 }
 
+// DisableAdminTFA godoc
+// @Summary      管理者停用二階段驗證
+// @Description  管理者停用二階段驗證
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      403  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/disable_tfa [post]
+// @Security     BearerAuth
 func DisableAdminTFA(c *gin.Context) {
 	var data TFAReq
 
-	roleID := c.GetInt(middlewares.ROLE_ID)
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	admin, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	err := services.DisableAdminTFA(&admin, data.TFA)
+	if err != nil {
+		respondTFA(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+type ResetRequiredPasswordReq struct {
+	AuthenticateReq
+	NewPassword   string `json:"new_password"`
+	CheckPassword string `json:"check_password"`
+}
+
+// ResetRequiredAdminPassword godoc
+// @Summary      管理者修改臨時密碼
+// @Description  密碼被重設的管理者以臨時密碼登入並設定新密碼，成功後直接登入
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        account         body      string  true   "帳號"
+// @Param        password        body      string  true   "臨時密碼"
+// @Param        tfa             body      string  false  "二階段驗證碼"
+// @Param        new_password    body      string  true   "新密碼"
+// @Param        check_password  body      string  true   "確認新密碼"
+// @Success      200             {object}  status.ResponseWtihData{data=string}
+// @Failure      400             {object}  status.Response
+// @Failure      500             {object}  status.Response
+// @Router       /admin_reset_password [post]
+func ResetRequiredAdminPassword(c *gin.Context) {
+	var data ResetRequiredPasswordReq
+	roleType := uint(middlewares.Admin)
 
 	bindErr := c.BindJSON(&data)
 	if bindErr != nil {
@@ -204,15 +327,84 @@ func DisableAdminTFA(c *gin.Context) {
 		return
 	}
 
-	admin, err := services.GetAdminById(uint(roleID))
+	if !utils.CheckPasswordValid(data.NewPassword) || data.NewPassword == data.Password {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.PasswordInvalid),
+		})
+		return
+	}
+
+	if data.NewPassword != data.CheckPassword {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.PasswordNotEqual),
+		})
+		return
+	}
+
+	roleID, ok := authenticateRole(c, roleType, data.AuthenticateReq, true)
+	if !ok {
+		return
+	}
+
+	admin, err := services.GetAdminById(roleID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	if !admin.MustResetPassword {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	err = services.ChangeAdminPassword(&admin, data.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	refreshToken, err := services.GenerateRefreshToken(roleType, roleID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
 		return
 	}
 
-	err = services.DisableAdminTFA(&admin, data.TFA)
+	accessToken, err := services.GenerateAccessToken(roleType, roleID, refreshToken.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.SetCookie(middlewares.REFRESH_TOKEN, refreshToken.Token, 3600, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   accessToken,
+	})
+}
+
+// GetAdmins godoc
+// @Summary      獲得管理者列表
+// @Description  獲得管理者列表
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.Admin}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/admin_account [get]
+// @Security     BearerAuth
+func GetAdmins(c *gin.Context) {
+	admins, err := services.GetAdmins()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
@@ -222,5 +414,333 @@ func DisableAdminTFA(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admins,
+	})
+}
+
+// GetAdmin godoc
+// @Summary      獲得管理者
+// @Description  獲得管理者
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "管理者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/admin_account/{id} [get]
+// @Security     BearerAuth
+func GetAdmin(c *gin.Context) {
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	admin, err := services.GetAdminDetail(adminID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistAdmin),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admin,
+	})
+}
+
+// AddAdmin godoc
+// @Summary      新增管理者
+// @Description  新增管理者，只有超級管理者可以新增超級管理者
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        account      body      string   true   "帳號"
+// @Param        password     body      string   true   "密碼"
+// @Param        permissions          body  []int  false  "個別授予的權限"
+// @Param        revoked_permissions  body  []int  false  "個別撤銷的權限"
+// @Param        role_ids             body  []int  false  "角色ID"
+// @Param        super_admin          body  bool   false  "是否為超級管理者"
+// @Success      200          {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/admin_account [post]
+// @Security     BearerAuth
+func AddAdmin(c *gin.Context) {
+	var data services.AdminReq
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil || data.Account == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	if !utils.CheckPasswordValid(data.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.PasswordInvalid),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	admin, err := services.CreateAdmin(operator, data)
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admin,
+	})
+}
+
+// UpdateAdminPermission godoc
+// @Summary      修改管理者權限
+// @Description  修改管理者權限與超級管理者身分，管理者已簽發的令牌立即失效
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id           path      int      true   "管理者ID"
+// @Param        permissions          body  []int  true   "個別授予的權限"
+// @Param        revoked_permissions  body  []int  false  "個別撤銷的權限"
+// @Param        super_admin          body  bool   false  "是否為超級管理者"
+// @Success      200          {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/admin_permission/{id} [patch]
+// @Security     BearerAuth
+func UpdateAdminPermission(c *gin.Context) {
+	var data services.AdminReq
+
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	admin, err := services.UpdateAdminPermissions(operator, adminID, data)
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admin,
+	})
+}
+
+// DisableAdmin godoc
+// @Summary      停用管理者
+// @Description  停用管理者並登出所有工作階段
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "管理者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/disable_admin/{id} [patch]
+// @Security     BearerAuth
+func DisableAdmin(c *gin.Context) {
+	setAdminStatus(c, models.AdminDisabled)
+}
+
+// EnableAdmin godoc
+// @Summary      啟用管理者
+// @Description  啟用被停用的管理者
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "管理者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/enable_admin/{id} [patch]
+// @Security     BearerAuth
+func EnableAdmin(c *gin.Context) {
+	setAdminStatus(c, models.AdminActive)
+}
+
+// ResetAdminPassword godoc
+// @Summary      重設管理者密碼
+// @Description  產生臨時密碼並登出管理者所有工作階段，管理者下次登入時必須修改密碼
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "管理者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=string}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/reset_admin_password/{id} [patch]
+// @Security     BearerAuth
+func ResetAdminPassword(c *gin.Context) {
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	password, err := services.ResetAdminPassword(operator, adminID, c.ClientIP())
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   password,
+	})
+}
+
+// DeleteAdmin godoc
+// @Summary      刪除管理者
+// @Description  刪除管理者並登出所有工作階段，無法刪除自己與最後一位超級管理者
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "管理者ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/admin_account/{id} [delete]
+// @Security     BearerAuth
+func DeleteAdmin(c *gin.Context) {
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	err := services.DeleteAdmin(operator, adminID, c.ClientIP())
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+func setAdminStatus(c *gin.Context, adminStatus byte) {
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	admin, err := services.SetAdminStatus(operator, adminID, adminStatus, c.ClientIP())
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admin,
+	})
+}
+
+func getAdminIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+// getOperatorAdmin 取得目前登入的管理者，使用者的令牌即使 ROLE_ID 相同也不能操作管理者
+func getOperatorAdmin(c *gin.Context) (models.Admin, bool) {
+	if c.GetInt(middlewares.ROLE_TYPE) != middlewares.Admin {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+		return models.Admin{}, false
+	}
+
+	admin, err := services.GetAdminById(uint(c.GetInt(middlewares.ROLE_ID)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistAdmin),
+		})
+		return admin, false
+	}
+
+	return admin, true
+}
+
+func respondAdmin(c *gin.Context, err error) {
+	errCode := status.Unkonwn
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		errCode = status.NotExistAdmin
+	case err == services.ErrAdminAccountUsed:
+		errCode = status.ExistAdmin
+	case err == services.ErrInvalidPermission:
+		errCode = status.InvalidPermission
+	case err == services.ErrRemoveOwnModifyAdmin:
+		errCode = status.CannotRemoveOwnPermission
+	case err == services.ErrLastSuperAdmin:
+		errCode = status.LastSuperAdmin
+	case err == services.ErrModifySelf:
+		errCode = status.CannotModifySelf
+	case err == services.ErrRequireSuperAdmin:
+		errCode = status.RequireSuperAdmin
+	case err == services.ErrAdminStatusNotChanged:
+		errCode = status.AdminStatusNotChanged
+	case err == services.ErrPermissionNotGrantable:
+		errCode = status.PermissionNotGrantable
+	case err == services.ErrAdminRoleNameUsed:
+		errCode = status.ExistAdminRole
+	case err == services.ErrBuiltInRole:
+		errCode = status.BuiltInAdminRole
+	}
+
+	if errCode == status.Unkonwn {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		status.RespStatus: status.NewResponse(errCode),
 	})
 }
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"errors"
+	"invar/permission"
+	"invar/services"
+	"invar/status"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetPermissions godoc
+// @Summary      獲得權限列表
+// @Description  獲得所有權限與說明
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]permission.Permission}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/permission [get]
+// @Security     BearerAuth
+func GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   permission.GetPermissions(),
+	})
+}
+
+// GetAdminRoles godoc
+// @Summary      獲得角色列表
+// @Description  獲得角色列表
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.AdminRole}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/admin_role [get]
+// @Security     BearerAuth
+func GetAdminRoles(c *gin.Context) {
+	roles, err := services.GetAdminRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   roles,
+	})
+}
+
+// AddAdminRole godoc
+// @Summary      新增角色
+// @Description  新增角色，只能授予自己擁有的權限
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        name         body      string  true   "名稱"
+// @Param        description  body      string  false  "說明"
+// @Param        permissions  body      []int   true   "權限"
+// @Success      200          {object}  status.ResponseWtihData{data=models.AdminRole}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/admin_role [post]
+// @Security     BearerAuth
+func AddAdminRole(c *gin.Context) {
+	var data services.AdminRoleReq
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil || data.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	role, err := services.CreateAdminRole(operator, data)
+	if err != nil {
+		respondAdminRole(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   role,
+	})
+}
+
+// UpdateAdminRole godoc
+// @Summary      修改角色
+// @Description  修改角色，所屬管理者已簽發的令牌立即失效
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id           path      int     true   "角色ID"
+// @Param        name         body      string  true   "名稱"
+// @Param        description  body      string  false  "說明"
+// @Param        permissions  body      []int   true   "權限"
+// @Success      200          {object}  status.ResponseWtihData{data=models.AdminRole}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/admin_role/{id} [patch]
+// @Security     BearerAuth
+func UpdateAdminRole(c *gin.Context) {
+	var data services.AdminRoleReq
+
+	roleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil || data.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	role, err := services.UpdateAdminRole(operator, uint(roleID), data)
+	if err != nil {
+		respondAdminRole(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   role,
+	})
+}
+
+// DeleteAdminRole godoc
+// @Summary      刪除角色
+// @Description  刪除角色並解除所有管理者的綁定，預設角色無法刪除
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "角色ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/admin_role/{id} [delete]
+// @Security     BearerAuth
+func DeleteAdminRole(c *gin.Context) {
+	roleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || roleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	err = services.DeleteAdminRole(operator, uint(roleID))
+	if err != nil {
+		respondAdminRole(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+type SetAdminRolesReq struct {
+	RoleIDs []uint `json:"role_ids"`
+}
+
+// SetAdminRoles godoc
+// @Summary      設定管理者角色
+// @Description  設定管理者所屬的角色，管理者已簽發的令牌立即失效
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id        path      int    true  "管理者ID"
+// @Param        role_ids  body      []int  true  "角色ID"
+// @Success      200       {object}  status.ResponseWtihData{data=models.Admin}
+// @Failure      400       {object}  status.Response
+// @Failure      500       {object}  status.Response
+// @Router       /admin/admin_role_binding/{id} [patch]
+// @Security     BearerAuth
+func SetAdminRoles(c *gin.Context) {
+	var data SetAdminRolesReq
+
+	adminID, ok := getAdminIDParam(c)
+	if !ok {
+		return
+	}
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	operator, ok := getOperatorAdmin(c)
+	if !ok {
+		return
+	}
+
+	admin, err := services.SetAdminRoles(operator, adminID, data.RoleIDs)
+	if err != nil {
+		respondAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   admin,
+	})
+}
+
+func respondAdminRole(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistAdminRole),
+		})
+		return
+	}
+
+	respondAdmin(c, err)
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"invar/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 使用者的 ROLE_ID 可能與管理者相同，管理者的二階段驗證不能只依 ROLE_ID 取得
+func TestAdminTFARejectsUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.GET("/admin/tfa", func(c *gin.Context) {
+		c.Set(middlewares.ROLE_TYPE, middlewares.User)
+		c.Set(middlewares.ROLE_ID, 1)
+	}, GetAdminTFA)
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/tfa", nil))
+
+	if recorder.Code != http.StatusForbidden {
+		t.Fatal("user token should be rejected by admin tfa, code=", recorder.Code)
+	}
+}
@@ -2,17 +2,21 @@ package controllers
 
 import (
 	"invar/middlewares"
+	"invar/models"
 	"invar/services"
 	"invar/status"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthenticateReq struct {
-	Account  string `json:"account"`
-	Password string `json:"password"`
-	TFA      string `json:"tfa"`
+	Account      string `json:"account"`
+	Password     string `json:"password"`
+	TFA          string `json:"tfa"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 // RefreshToken godoc
@@ -26,16 +30,24 @@ type AuthenticateReq struct {
 // @Failure      500  {object}  status.Response
 // @Router       /refresh_token [post]
 func RefreshToken(c *gin.Context) {
-	refreshToken, err := c.Cookie(middlewares.REFRESH_TOKEN)
+	refreshToken := getRefreshToken(c)
 
-	if refreshToken == "" || err != nil {
+	if refreshToken == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			status.RespStatus: status.NewResponse(status.NoToken),
 		})
 		return
 	}
 
-	newRefreshToken, err := services.RevokeRefreshToken(refreshToken, c.ClientIP())
+	newRefreshToken, err := services.RotateRefreshToken(refreshToken, c.ClientIP(), c.Request.UserAgent())
+	if err == services.ErrRefreshTokenReused {
+		c.SetCookie(middlewares.REFRESH_TOKEN, "", -1, "/", "", false, true)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			status.RespStatus: status.NewResponse(status.RefreshTokenReused),
+		})
+		return
+	}
+
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			status.RespStatus: status.NewResponse(status.TokenIsInvalid),
@@ -43,7 +55,13 @@ func RefreshToken(c *gin.Context) {
 		return
 	}
 
-	newAccessToken := services.GenerateAccessToken(newRefreshToken.RoleType, newRefreshToken.RoleID)
+	newAccessToken, err := services.GenerateAccessToken(newRefreshToken.RoleType, newRefreshToken.RoleID, newRefreshToken.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			status.RespStatus: status.NewResponse(status.TokenIsInvalid),
+		})
+		return
+	}
 	c.SetCookie(middlewares.REFRESH_TOKEN, newRefreshToken.Token, 3600, "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
@@ -53,7 +71,7 @@ func RefreshToken(c *gin.Context) {
 
 // RevokeToken godoc
 // @Summary      撤銷令牌
-// @Description  撤銷令牌
+// @Description  撤銷令牌並登出目前的工作階段
 // @Tags         Auth
 // @Accept       json
 // @Produce      json
@@ -62,7 +80,7 @@ func RefreshToken(c *gin.Context) {
 // @Failure      500  {object}  status.Response
 // @Router       /revoke_token [post]
 func RevokeToken(c *gin.Context) {
-	refreshToken := c.Request.Header.Get(middlewares.REFRESH_TOKEN)
+	refreshToken := getRefreshToken(c)
 
 	if refreshToken == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -79,25 +97,432 @@ func RevokeToken(c *gin.Context) {
 		return
 	}
 
+	c.SetCookie(middlewares.REFRESH_TOKEN, "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// GetSessions godoc
+// @Summary      獲得登入中的工作階段
+// @Description  獲得目前角色所有登入中的工作階段，current 為目前使用中的工作階段
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.Session}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /session [get]
+// @Router       /admin/session [get]
+// @Security     BearerAuth
+func GetSessions(c *gin.Context) {
+	roleType := uint(c.GetInt(middlewares.ROLE_TYPE))
+	roleID := uint(c.GetInt(middlewares.ROLE_ID))
+	sessionID := uint(c.GetInt(middlewares.SESSION_ID))
+
+	sessions, err := services.GetSessions(roleType, roleID, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   sessions,
+	})
+}
+
+// RevokeSession godoc
+// @Summary      登出工作階段
+// @Description  登出目前角色指定的工作階段
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "工作階段ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /session/{id} [delete]
+// @Router       /admin/session/{id} [delete]
+// @Security     BearerAuth
+func RevokeSession(c *gin.Context) {
+	roleType := uint(c.GetInt(middlewares.ROLE_TYPE))
+	roleID := uint(c.GetInt(middlewares.ROLE_ID))
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || sessionID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	err = services.RevokeSession(roleType, roleID, uint(sessionID), c.ClientIP())
+	if err == services.ErrSessionNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistSession),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 	})
 }
 
-func checkLoginInfo(roleType uint, account, password, tfa string) (roleID uint, errCode int) {
+// RevokeAllSessions godoc
+// @Summary      登出所有裝置
+// @Description  登出目前角色所有的工作階段，已簽發的令牌立即失效
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /logout_all [post]
+// @Router       /admin/logout_all [post]
+// @Security     BearerAuth
+func RevokeAllSessions(c *gin.Context) {
+	roleType := uint(c.GetInt(middlewares.ROLE_TYPE))
+	roleID := uint(c.GetInt(middlewares.ROLE_ID))
+
+	err := services.RevokeAllSessions(roleType, roleID, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.SetCookie(middlewares.REFRESH_TOKEN, "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// GetUserSessions godoc
+// @Summary      獲得使用者登入中的工作階段
+// @Description  獲得使用者登入中的工作階段
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "使用者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.Session}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/user_session/{id} [get]
+// @Security     BearerAuth
+func GetUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	sessions, err := services.GetSessions(middlewares.User, uint(userID), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   sessions,
+	})
+}
+
+// RevokeUserSessions godoc
+// @Summary      登出使用者所有裝置
+// @Description  登出使用者所有的工作階段，已簽發的令牌立即失效
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "使用者ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/logout_user/{id} [post]
+// @Security     BearerAuth
+func RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || userID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.GetUserById(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
+	err = services.RevokeAllSessions(middlewares.User, user.ID, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// GetTokenKeys godoc
+// @Summary      獲得令牌金鑰資訊
+// @Description  獲得目前簽發令牌的金鑰ID與輪替中的金鑰，不包含金鑰內容
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=services.KeyRingInfo}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/token_key [get]
+// @Security     BearerAuth
+func GetTokenKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   services.GetKeyRingInfo(),
+	})
+}
+
+// RotateTokenKey godoc
+// @Summary      輪替令牌金鑰
+// @Description  產生新的金鑰簽發令牌，舊金鑰簽發的令牌在輪替期間內仍然有效
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=services.KeyRingInfo}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/rotate_token_key [post]
+// @Security     BearerAuth
+func RotateTokenKey(c *gin.Context) {
+	_, err := services.RotateSymmetricKey()
+	if err == services.ErrKeyRingReadOnly {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.UpdateFail),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   services.GetKeyRingInfo(),
+	})
+}
+
+// UnlockAccount godoc
+// @Summary      解除帳號鎖定
+// @Description  以帳號鎖定通知信中的連結解除鎖定
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        token  query     string  true  "解鎖令牌"
+// @Success      200    {object}  status.Response
+// @Failure      400    {object}  status.Response
+// @Failure      500    {object}  status.Response
+// @Router       /unlock_account [get]
+func UnlockAccount(c *gin.Context) {
+	err := services.UnlockLoginAccount(c.Query("token"))
+	if err == services.ErrUnlockInvalid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.InvalidUnlockToken),
+		})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// GetLoginLockouts godoc
+// @Summary      獲得被鎖定的帳號
+// @Description  獲得因連續登入失敗而暫時鎖定的帳號
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.LoginLockout}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/login_lockout [get]
+// @Security     BearerAuth
+func GetLoginLockouts(c *gin.Context) {
+	lockouts, err := services.GetLoginLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   lockouts,
+	})
+}
+
+// ClearLoginLockout godoc
+// @Summary      解除帳號鎖定
+// @Description  解除帳號鎖定並清除登入失敗紀錄，帶入 ip 時改為清除該IP的失敗紀錄
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        role_type  query     int     false  "角色類型 1:管理者 2:使用者"
+// @Param        account    query     string  false  "帳號"
+// @Param        ip         query     string  false  "IP"
+// @Success      200        {object}  status.Response
+// @Failure      400        {object}  status.Response
+// @Failure      500        {object}  status.Response
+// @Router       /admin/login_lockout [delete]
+// @Security     BearerAuth
+func ClearLoginLockout(c *gin.Context) {
+	var err error
+
+	if ip := c.Query("ip"); ip != "" {
+		err = services.ClearIPLoginFailures(ip)
+	} else {
+		roleType, convErr := strconv.Atoi(c.Query("role_type"))
+		account := c.Query("account")
+		if convErr != nil || (roleType != middlewares.Admin && roleType != middlewares.User) || account == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				status.RespStatus: status.NewResponse(status.BadRequest),
+			})
+			return
+		}
+
+		err = services.ClearLoginLockout(uint(roleType), account)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// getRefreshToken 優先從 cookie 取得 refresh token，其次為 header。
+func getRefreshToken(c *gin.Context) string {
+	refreshToken, err := c.Cookie(middlewares.REFRESH_TOKEN)
+	if err == nil && refreshToken != "" {
+		return refreshToken
+	}
+
+	return c.Request.Header.Get(middlewares.REFRESH_TOKEN)
+}
+
+// authenticateRole 檢查登入頻率與帳號鎖定後驗證登入資訊，失敗時直接回應。
+// 帳號不存在與密碼錯誤回應相同的錯誤，避免被用來探測帳號。
+// allowPasswordReset 為 true 時，被要求重設密碼的管理者也視為驗證成功。
+func authenticateRole(c *gin.Context, roleType uint, data AuthenticateReq, allowPasswordReset bool) (uint, bool) {
+	retryAfter, err := services.CheckLoginAttempt(roleType, data.Account, c.ClientIP())
+	if err == services.ErrLoginLocked || err == services.ErrLoginDelayed {
+		errCode := status.AccountLocked
+		if err == services.ErrLoginDelayed {
+			errCode = status.RequestTooFrequently
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return 0, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return 0, false
+	}
+
+	roleID, errCode := checkLoginInfo(roleType, data)
+	if errCode == status.PasswordResetRequired && allowPasswordReset {
+		errCode = status.Success
+	}
+
+	if errCode != status.Success {
+		switch errCode {
+		case status.IncorrectLoginInfo, status.IncorrectTFA, status.IncorrectRecoveryCode:
+			services.RecordLoginFailure(roleType, data.Account, c.ClientIP())
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return 0, false
+	}
+
+	services.ResetLoginFailures(roleType, data.Account)
+	return roleID, true
+}
+
+func checkLoginInfo(roleType uint, data AuthenticateReq) (roleID uint, errCode int) {
 	switch roleType {
 	case middlewares.User:
-		role, err := services.GetUserByEmail(account)
+		role, err := services.GetUserByEmail(data.Account)
 		if err != nil {
-			return roleID, status.NotExistUser
+			services.CompareDummyPassword(data.Password)
+			return roleID, status.IncorrectLoginInfo
 		}
 
-		err = role.ComparePassword(password)
+		err = role.ComparePassword(data.Password)
 		if err != nil {
 			return roleID, status.IncorrectLoginInfo
 		}
 
-		err = services.CheckUserTFA(&role, tfa)
+		if role.Status == models.Disabled {
+			return roleID, status.UserDisabled
+		}
+
+		if role.TFAEnable && data.TFA == "" && data.RecoveryCode != "" {
+			err = services.CheckUserRecoveryCode(&role, data.RecoveryCode)
+			if err != nil {
+				return roleID, status.IncorrectRecoveryCode
+			}
+
+			return role.ID, errCode
+		}
+
+		err = services.CheckUserTFA(&role, data.TFA)
 		if err != nil {
 			return roleID, status.IncorrectTFA
 		}
@@ -105,18 +530,60 @@ func checkLoginInfo(roleType uint, account, password, tfa string) (roleID uint,
 		return role.ID, errCode
 
 	case middlewares.Admin:
-		role, err := services.GetAdminByAccount(account)
+		role, err := services.GetAdminByAccount(data.Account)
 		if err != nil {
-			return roleID, status.NotExistUser
+			services.CompareDummyPassword(data.Password)
+			return roleID, status.IncorrectLoginInfo
 		}
 
-		err = role.ComparePassword(password)
+		err = role.ComparePassword(data.Password)
 		if err != nil {
 			return roleID, status.IncorrectLoginInfo
 		}
 
+		if role.Status == models.AdminDisabled {
+			return roleID, status.UserDisabled
+		}
+
+		if role.TFAEnable && data.TFA == "" && data.RecoveryCode != "" {
+			err = services.CheckAdminRecoveryCode(&role, data.RecoveryCode)
+			if err != nil {
+				return roleID, status.IncorrectRecoveryCode
+			}
+		} else {
+			err = services.CheckAdminTFA(&role, data.TFA)
+			if err != nil {
+				return roleID, status.IncorrectTFA
+			}
+		}
+
+		if role.MustResetPassword {
+			return role.ID, status.PasswordResetRequired
+		}
+
 		return role.ID, errCode
 	}
 
 	return roleID, status.Unkonwn
 }
+
+func respondTFA(c *gin.Context, err error) {
+	switch err {
+	case services.ErrTFAEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.TFAEnabled),
+		})
+	case services.ErrTFANotEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.TFANotEnabled),
+		})
+	case services.ErrIncorrectTFA, services.ErrTFACodeUsed:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.IncorrectTFA),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+	}
+}
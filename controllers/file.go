@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"invar/services"
+	"invar/status"
+	"invar/storage"
+	"invar/utils"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// GetFile godoc
+// @Summary      下載檔案
+// @Description  以本機儲存產生的限時網址下載檔案，網址由商品等 API 的回應提供
+// @Tags         File
+// @Produce      octet-stream
+// @Param        key        path      string  true  "檔案 key"
+// @Param        expires    query     int     true  "網址到期時間"
+// @Param        signature  query     string  true  "網址簽章"
+// @Success      200
+// @Failure      403  {object}  status.Response
+// @Failure      404  {object}  status.Response
+// @Router       /file/{key} [get]
+func GetFile(c *gin.Context) {
+	key := c.Param("key")
+
+	err := services.VerifyStoredFileURL(key, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.InvalidFileSignature),
+		})
+		return
+	}
+
+	file, info, err := services.OpenStoredFile(key)
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistFile),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	_, err = io.Copy(c.Writer, file)
+	if err != nil {
+		logrus.Error("Send file fail, key=", key, ", err=", err)
+		c.Abort()
+	}
+}
+
+// respondUploadError 回應上傳圖片驗證或保存失敗
+func respondUploadError(c *gin.Context, err error) {
+	switch err {
+	case utils.ErrUnsupportedImage:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
+		})
+	case utils.ErrImageTooLarge:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			status.RespStatus: status.NewResponse(status.ImageTooLarge),
+		})
+	default:
+		logrus.Error("Save uploaded image fail=", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+	}
+}
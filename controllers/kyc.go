@@ -1,18 +1,25 @@
 package controllers
 
 import (
+	"bytes"
+	"errors"
 	"invar/middlewares"
 	"invar/models"
 	"invar/services"
 	"invar/status"
+	"invar/storage"
 	"invar/utils"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
+var kycFileFields = []string{"photo_file", "id_photo_front_file", "id_photo_back_file", "photo_with_id_file"}
+
 // GetKYC godoc
 // @Summary      獲得實名資料
 // @Description  獲得實名資料
@@ -41,6 +48,33 @@ func GetKYC(c *gin.Context) {
 	})
 }
 
+// GetKYCSubmissions godoc
+// @Summary      獲得實名送審紀錄
+// @Description  獲得所有版本的實名送審紀錄與審核結果，新版本在前
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.KYCSubmission}
+// @Failure      500  {object}  status.Response
+// @Router       /kyc_submission [get]
+// @Security     BearerAuth
+func GetKYCSubmissions(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+
+	submissions, err := services.GetKYCSubmissions(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submissions,
+	})
+}
+
 // GetKYCByAdmin godoc
 // @Summary      管理者獲得使用者實名資料
 // @Description  管理者獲得使用者實名資料
@@ -51,7 +85,7 @@ func GetKYC(c *gin.Context) {
 // @Success      200                  {object}  status.ResponseWtihData{data=models.UserKYC}
 // @Failure      400                  {object}  status.Response
 // @Failure      500                  {object}  status.Response
-// @Router       /admin/kyc/:id [post]
+// @Router       /admin/kyc/{id} [get]
 // @Security     BearerAuth
 func GetKYCByAdmin(c *gin.Context) {
 	userID, _ := strconv.Atoi(c.Param("id"))
@@ -70,6 +104,38 @@ func GetKYCByAdmin(c *gin.Context) {
 	})
 }
 
+// GetKYCSubmissionsByAdmin godoc
+// @Summary      管理者獲得使用者實名送審紀錄
+// @Description  管理者獲得使用者所有版本的實名送審紀錄，新版本在前
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.KYCSubmission}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/kyc_submission/{id} [get]
+// @Security     BearerAuth
+func GetKYCSubmissionsByAdmin(c *gin.Context) {
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	submissions, err := services.GetKYCSubmissions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submissions,
+	})
+}
+
 type UpsertKYCReq struct {
 	Name         string `json:"name"`
 	IdentityCard string `json:"identity_card"`
@@ -77,7 +143,7 @@ type UpsertKYCReq struct {
 
 // AddKYC godoc
 // @Summary      新增實名資料
-// @Description  新增實名資料
+// @Description  新增實名資料並送審
 // @Tags         User
 // @Accept       mpfd
 // @Produce      json
@@ -87,8 +153,9 @@ type UpsertKYCReq struct {
 // @Param        id_photo_front_file  formData  file    true  "身分證正面"
 // @Param        id_photo_back_file   formData  file    true  "身分證背面"
 // @Param        photo_with_id_file   formData  file    true  "與身分證合照"
-// @Success      200                  {object}  status.Response
+// @Success      200                  {object}  status.ResponseWtihData{data=models.KYCSubmission}
 // @Failure      400                  {object}  status.Response
+// @Failure      415                  {object}  status.Response
 // @Failure      500                  {object}  status.Response
 // @Router       /kyc [post]
 // @Security     BearerAuth
@@ -96,459 +163,618 @@ func AddKYC(c *gin.Context) {
 	roleID := c.GetInt(middlewares.ROLE_ID)
 	var data UpsertKYCReq
 
-	user, err := services.GetUserById(uint(roleID))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
-		})
-		return
-	}
-
-	if user.Status == models.AuditSuccess {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.UserHasKYC),
-		})
-		return
-	}
-
 	bindErr := c.BindWith(&data, binding.Form)
-	if bindErr != nil {
+	if bindErr != nil || data.Name == "" || data.IdentityCard == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
 		return
 	}
 
-	photoFile, photoFileErr := c.FormFile("photo_file")
-	idPhotoFrontFile, idPhotoFrontFileErr := c.FormFile("id_photo_front_file")
-	idPhotoBackFile, idPhotoBackFileErr := c.FormFile("id_photo_back_file")
-	photoWithIDFile, photoWithIDFileErr := c.FormFile("photo_with_id_file")
-	if photoFileErr != nil || idPhotoFrontFileErr != nil || idPhotoBackFileErr != nil || photoWithIDFileErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.BadRequest),
-		})
-		return
+	for _, field := range kycFileFields {
+		_, err := c.FormFile(field)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				status.RespStatus: status.NewResponse(status.BadRequest),
+			})
+			return
+		}
 	}
 
-	fileSupportTypes := []int{utils.PNG, utils.JPEG, utils.JPG}
-	filesName := []string{photoFile.Filename, idPhotoFrontFile.Filename, idPhotoBackFile.Filename, photoWithIDFile.Filename}
-	if utils.CheckMultiFileMediaType(filesName, fileSupportTypes) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
-		})
-		return
+	var kyc = models.UserKYC{
+		UserID:       uint(roleID),
+		Name:         data.Name,
+		IdentityCard: data.IdentityCard,
 	}
 
-	savePath := status.SaveFileFolderPath + status.SaveFilePublicFolderPath + "/"
-	photoFileSavePath := utils.FilePathGenerator(photoFile.Filename, savePath)
-	err = c.SaveUploadedFile(photoFile, photoFileSavePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+	files, ok := stageKYCFiles(c, kyc.UserID)
+	if !ok {
 		return
 	}
 
-	idPhotoFrontFileSavePath := utils.FilePathGenerator(idPhotoFrontFile.Filename, savePath)
-	err = c.SaveUploadedFile(idPhotoFrontFile, idPhotoFrontFileSavePath)
+	submission, err := services.SubmitKYC(uint(roleID), kyc, files)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondKYC(c, err)
 		return
 	}
 
-	idPhotoBackFileSavePath := utils.FilePathGenerator(idPhotoBackFile.Filename, savePath)
-	err = c.SaveUploadedFile(idPhotoBackFile, idPhotoBackFileSavePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
+	})
+}
+
+// UpdateKYC godoc
+// @Summary      更新實名資料
+// @Description  更新實名資料並重新送審，未上傳的照片沿用上一版本
+// @Tags         User
+// @Accept       mpfd
+// @Produce      json
+// @Param        name                 formData  string  false  "姓名"
+// @Param        identity_card        formData  string  false  "身分證號碼"
+// @Param        photo_file           formData  file    false  "大頭貼"
+// @Param        id_photo_front_file  formData  file    false  "身分證正面"
+// @Param        id_photo_back_file   formData  file    false  "身分證背面"
+// @Param        photo_with_id_file   formData  file    false  "與身分證合照"
+// @Success      200  {object}  status.ResponseWtihData{data=models.KYCSubmission}
+// @Failure      400  {object}  status.Response
+// @Failure      415  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /kyc [patch]
+// @Security     BearerAuth
+func UpdateKYC(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	var data UpsertKYCReq
+
+	kyc, err := services.GetKYCByUserID(uint(roleID))
+	if err != nil || kyc.ID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.UserNoKYC),
 		})
 		return
 	}
 
-	photoWithIDFileSavePath := utils.FilePathGenerator(photoWithIDFile.Filename, savePath)
-	err = c.SaveUploadedFile(photoWithIDFile, photoWithIDFileSavePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
+	bindErr := c.BindWith(&data, binding.Form)
+	if bindErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
 		return
 	}
 
-	var kyc = models.UserKYC{
-		UserID:       uint(roleID),
-		Name:         data.Name,
-		IdentityCard: data.IdentityCard,
-		Photo:        photoFileSavePath[1:],
-		IDPhotoFront: idPhotoFrontFileSavePath,
-		IDPhotoBack:  idPhotoBackFileSavePath,
-		PhotoWithID:  photoWithIDFileSavePath,
+	if data.Name != "" {
+		kyc.Name = data.Name
 	}
 
-	err = services.AddKYC(&kyc)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+	if data.IdentityCard != "" {
+		kyc.IdentityCard = data.IdentityCard
+	}
+
+	files, ok := stageKYCFiles(c, kyc.UserID)
+	if !ok {
 		return
 	}
 
-	user.Status = models.Auditing
+	submission, err := services.SubmitKYC(uint(roleID), kyc, files)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondKYC(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
 	})
 }
 
-// UpdateKYC godoc
-// @Summary      更新實名資料
-// @Description  更新實名資料
+// UpdateKYCByAdmin godoc
+// @Summary      管理者更新實名資料
+// @Description  管理者修正實名資料，審核結果需透過審核流程處理
 // @Tags         User
 // @Accept       mpfd
 // @Produce      json
+// @Param        id   path      int  true  "KYC ID"
 // @Param        name                 formData  string  false  "姓名"
 // @Param        identity_card        formData  string  false  "身分證號碼"
 // @Param        photo_file           formData  file    false  "大頭貼"
 // @Param        id_photo_front_file  formData  file    false  "身分證正面"
 // @Param        id_photo_back_file   formData  file    false  "身分證背面"
 // @Param        photo_with_id_file   formData  file    false  "與身分證合照"
-// @Success      200  {object}  status.Response
+// @Success      200  {object}  status.ResponseWtihData{data=models.UserKYC}
 // @Failure      400  {object}  status.Response
+// @Failure      415  {object}  status.Response
 // @Failure      500  {object}  status.Response
-// @Router       /kyc [patch]
+// @Router       /admin/kyc/{id} [patch]
 // @Security     BearerAuth
-func UpdateKYC(c *gin.Context) {
-	roleID := c.GetInt(middlewares.ROLE_ID)
+func UpdateKYCByAdmin(c *gin.Context) {
+	kycID, _ := strconv.Atoi(c.Param("id"))
 	var data UpsertKYCReq
 
-	user, err := services.GetUserById(uint(roleID))
+	kyc, err := services.GetKYC(uint(kycID))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
+			status.RespStatus: status.NewResponse(status.UserNoKYC),
 		})
 		return
 	}
 
-	kyc, err := services.GetKYCByUserID(uint(roleID))
-	if err != nil {
+	bindErr := c.BindWith(&data, binding.Form)
+	if bindErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.UserNoKYC),
+			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
+		return
 	}
 
-	if kyc.UserID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{
-			status.RespStatus: status.NewResponse(status.NotPermission),
-		})
+	if data.Name != "" {
+		kyc.Name = data.Name
+	}
+
+	if data.IdentityCard != "" {
+		kyc.IdentityCard = data.IdentityCard
+	}
+
+	files, ok := stageKYCFiles(c, kyc.UserID)
+	if !ok {
 		return
 	}
 
-	if user.Status == models.AuditSuccess {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.UserHasKYC),
+	err = services.UpdateKYC(&kyc, files)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
 		return
 	}
 
-	bindErr := c.BindWith(&data, binding.Form)
-	if bindErr != nil {
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   kyc,
+	})
+}
+
+// GetKYCQueue godoc
+// @Summary      獲得實名審核佇列
+// @Description  獲得審核中使用者的待審核送審紀錄，先送審的在前
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        reviewer_id  query     int   false  "審核者ID"
+// @Param        unassigned   query     bool  false  "只列出尚未認領"
+// @Success      200          {object}  status.ResponseWtihData{data=[]models.KYCSubmission}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/kyc_queue [get]
+// @Security     BearerAuth
+func GetKYCQueue(c *gin.Context) {
+	var search services.KYCQueueSearch
+
+	err := c.ShouldBindQuery(&search)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
 		return
 	}
 
-	savePath := status.SaveFileFolderPath + status.SaveFilePublicFolderPath + "/"
-	fileSupportTypes := []int{utils.PNG, utils.JPEG, utils.JPG}
-	photoFile, err := c.FormFile("photo_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(photoFile.Filename, savePath, kyc.Photo, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
-
-		err = c.SaveUploadedFile(photoFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
-
-		kyc.Photo = fileSavePath[1:]
+	submissions, err := services.GetKYCQueue(search)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
 	}
 
-	idPhotoFrontFile, err := c.FormFile("id_photo_front_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(idPhotoFrontFile.Filename, savePath, kyc.IDPhotoFront, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submissions,
+	})
+}
 
-		err = c.SaveUploadedFile(idPhotoFrontFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
+// GetKYCRejectReasons godoc
+// @Summary      獲得審核失敗原因
+// @Description  獲得審核失敗時可選擇的原因
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.KYCRejectReason}
+// @Router       /admin/kyc_reject_reason [get]
+// @Security     BearerAuth
+func GetKYCRejectReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   services.GetKYCRejectReasons(),
+	})
+}
 
-		kyc.IDPhotoFront = fileSavePath[1:]
+// GetKYCDocument godoc
+// @Summary      獲得實名照片
+// @Description  解密並回傳自己的實名照片，每次讀取都會記錄
+// @Tags         User
+// @Produce      image/png,image/jpeg
+// @Param        id   path      int  true  "照片ID"
+// @Success      200  {file}    binary
+// @Failure      400  {object}  status.Response
+// @Failure      403  {object}  status.Response
+// @Failure      404  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /kyc_document/{id} [get]
+// @Security     BearerAuth
+func GetKYCDocument(c *gin.Context) {
+	doc, ok := getKYCDocumentParam(c)
+	if !ok {
+		return
 	}
 
-	idPhotoBackFile, err := c.FormFile("id_photo_back_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(idPhotoBackFile.Filename, savePath, kyc.IDPhotoBack, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
-
-		err = c.SaveUploadedFile(idPhotoBackFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
-
-		kyc.IDPhotoBack = fileSavePath[1:]
+	if doc.UserID != uint(c.GetInt(middlewares.ROLE_ID)) {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+		return
 	}
 
-	photoWithIDFile, err := c.FormFile("photo_with_id_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(photoWithIDFile.Filename, savePath, kyc.PhotoWithID, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
-
-		err = c.SaveUploadedFile(photoWithIDFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
+	streamKYCDocument(c, doc)
+}
 
-		kyc.PhotoWithID = fileSavePath[1:]
+// GetKYCDocumentByAdmin godoc
+// @Summary      管理者獲得實名照片
+// @Description  解密並回傳使用者的實名照片，每次讀取都會記錄
+// @Tags         KYC
+// @Produce      image/png,image/jpeg
+// @Param        id   path      int  true  "照片ID"
+// @Success      200  {file}    binary
+// @Failure      400  {object}  status.Response
+// @Failure      404  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/kyc_document/{id} [get]
+// @Security     BearerAuth
+func GetKYCDocumentByAdmin(c *gin.Context) {
+	doc, ok := getKYCDocumentParam(c)
+	if !ok {
+		return
 	}
 
-	if data.Name != "" {
-		kyc.Name = data.Name
-	}
+	streamKYCDocument(c, doc)
+}
 
-	if data.IdentityCard != "" {
-		kyc.Name = data.IdentityCard
+// GetKYCAccessLogs godoc
+// @Summary      獲得實名照片讀取紀錄
+// @Description  獲得使用者實名照片的讀取紀錄，新的在前
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.KYCAccessLog}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/kyc_access_log/{id} [get]
+// @Security     BearerAuth
+func GetKYCAccessLogs(c *gin.Context) {
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
 	}
 
-	user.Status = models.Auditing
-	err = services.UpdateUser(&user)
+	logs, err := services.GetKYCAccessLogs(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
 		return
 	}
-	//如果KYC審核失敗則寄信給使用者
 
-	err = services.UpdateKYC(&kyc)
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   logs,
+	})
+}
+
+// ClaimKYC godoc
+// @Summary      認領實名送審
+// @Description  認領尚未被其他審核者認領的送審紀錄
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "送審紀錄ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.KYCSubmission}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/claim_kyc/{id} [patch]
+// @Security     BearerAuth
+func ClaimKYC(c *gin.Context) {
+	submissionID, ok := getKYCSubmissionIDParam(c)
+	if !ok {
+		return
+	}
+
+	reviewerID := uint(c.GetInt(middlewares.ROLE_ID))
+	submission, err := services.ClaimKYCSubmission(reviewerID, submissionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondKYC(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
 	})
 }
 
-type UpdateKYCByAdminReq struct {
-	Name         string `json:"name"`
-	IdentityCard string `json:"identity_card"`
-	Status       byte   `json:"status"`
+type AssignKYCReq struct {
+	ReviewerID uint `json:"reviewer_id"`
 }
 
-// UpdateKYCByAdmin godoc
-// @Summary      管理者更新實名資料
-// @Description  管理者更新實名資料
-// @Tags         User
-// @Accept       mpfd
+// AssignKYC godoc
+// @Summary      指派實名送審
+// @Description  指派送審紀錄給擁有審核權限的管理者，可改派已認領的送審紀錄
+// @Tags         KYC
+// @Accept       json
 // @Produce      json
-// @Param        id   path      int  true  "User ID"
-// @Param        name                 formData  string  false  "姓名"
-// @Param        identity_card        formData  string  false  "身分證號碼"
-// @Param        status               formData  string  false  "審核結果"
-// @Param        photo_file           formData  file    false  "大頭貼"
-// @Param        id_photo_front_file  formData  file    false  "身分證正面"
-// @Param        id_photo_back_file   formData  file    false  "身分證背面"
-// @Param        photo_with_id_file   formData  file    false  "與身分證合照"
-// @Success      200  {object}  status.Response
-// @Failure      400  {object}  status.Response
-// @Failure      500  {object}  status.Response
-// @Router       /admin/kyc/:id [patch]
+// @Param        id           path      int  true  "送審紀錄ID"
+// @Param        reviewer_id  body      int  true  "審核者ID"
+// @Success      200          {object}  status.ResponseWtihData{data=models.KYCSubmission}
+// @Failure      400          {object}  status.Response
+// @Failure      500          {object}  status.Response
+// @Router       /admin/assign_kyc/{id} [patch]
 // @Security     BearerAuth
-func UpdateKYCByAdmin(c *gin.Context) {
-	kycID, _ := strconv.Atoi(c.Param("id"))
-	var data UpdateKYCByAdminReq
+func AssignKYC(c *gin.Context) {
+	var data AssignKYCReq
 
-	kyc, err := services.GetKYC(uint(kycID))
-	if err != nil {
+	submissionID, ok := getKYCSubmissionIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := c.BindJSON(&data)
+	if err != nil || data.ReviewerID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.UserNoKYC),
+			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
+		return
 	}
 
-	user, err := services.GetUserById(kyc.UserID)
+	submission, err := services.AssignKYCSubmission(submissionID, data.ReviewerID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
-		})
+		respondKYC(c, err)
 		return
 	}
 
-	bindErr := c.BindWith(&data, binding.Form)
-	if bindErr != nil {
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
+	})
+}
+
+// ApproveKYC godoc
+// @Summary      實名審核通過
+// @Description  審核通過已認領的送審紀錄並寄信通知使用者
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "送審紀錄ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.KYCSubmission}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/approve_kyc/{id} [patch]
+// @Security     BearerAuth
+func ApproveKYC(c *gin.Context) {
+	submissionID, ok := getKYCSubmissionIDParam(c)
+	if !ok {
+		return
+	}
+
+	reviewerID := uint(c.GetInt(middlewares.ROLE_ID))
+	submission, err := services.ApproveKYCSubmission(reviewerID, submissionID)
+	if err != nil {
+		respondKYC(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
+	})
+}
+
+type RejectKYCReq struct {
+	Reasons []int32 `json:"reasons"`
+	Comment string  `json:"comment"`
+}
+
+// RejectKYC godoc
+// @Summary      實名審核失敗
+// @Description  以審核失敗原因退回已認領的送審紀錄並寄信通知使用者，選擇其他原因時必須填寫備註
+// @Tags         KYC
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int           true   "送審紀錄ID"
+// @Param        reasons  body      RejectKYCReq  true   "審核失敗原因與備註"
+// @Success      200      {object}  status.ResponseWtihData{data=models.KYCSubmission}
+// @Failure      400      {object}  status.Response
+// @Failure      500      {object}  status.Response
+// @Router       /admin/reject_kyc/{id} [patch]
+// @Security     BearerAuth
+func RejectKYC(c *gin.Context) {
+	var data RejectKYCReq
+
+	submissionID, ok := getKYCSubmissionIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := c.BindJSON(&data)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
 		return
 	}
 
-	savePath := status.SaveFileFolderPath + status.SaveFilePublicFolderPath + "/"
-	fileSupportTypes := []int{utils.PNG, utils.JPEG, utils.JPG}
-	photoFile, err := c.FormFile("photo_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(photoFile.Filename, savePath, kyc.Photo, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
+	reviewerID := uint(c.GetInt(middlewares.ROLE_ID))
+	submission, err := services.RejectKYCSubmission(reviewerID, submissionID, data.Reasons, data.Comment)
+	if err != nil {
+		respondKYC(c, err)
+		return
+	}
 
-		err = c.SaveUploadedFile(photoFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   submission,
+	})
+}
 
-		kyc.Photo = fileSavePath[1:]
-	}
+// stageKYCFiles 驗證所有上傳的實名照片後才加密寫入，舊照片保留給先前版本的送審紀錄。
+// 失敗時已刪除寫入的照片並回應請求，回傳 false
+func stageKYCFiles(c *gin.Context, userID uint) (*services.KYCFiles, bool) {
+	kinds := []string{models.KYCPhoto, models.KYCIDPhotoFront, models.KYCIDPhotoBack, models.KYCPhotoWithID}
+	images := make([]utils.ProcessedImage, len(kycFileFields))
+	uploaded := make([]bool, len(kycFileFields))
 
-	idPhotoFrontFile, err := c.FormFile("id_photo_front_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(idPhotoFrontFile.Filename, savePath, kyc.IDPhotoFront, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
+	for i, field := range kycFileFields {
+		fileHeader, err := c.FormFile(field)
+		if err != nil {
+			continue
 		}
 
-		err = c.SaveUploadedFile(idPhotoFrontFile, fileSavePath)
+		// 重新編碼時會移除照片中的 GPS 等中繼資料
+		images[i], err = services.ReadUploadedImage(fileHeader)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
+			respondUploadError(c, err)
+			return nil, false
 		}
-
-		kyc.IDPhotoFront = fileSavePath[1:]
+		uploaded[i] = true
 	}
 
-	idPhotoBackFile, err := c.FormFile("id_photo_back_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(idPhotoBackFile.Filename, savePath, kyc.IDPhotoBack, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
+	files := services.NewKYCFiles()
+	for i := range kycFileFields {
+		if !uploaded[i] {
+			continue
 		}
 
-		err = c.SaveUploadedFile(idPhotoBackFile, fileSavePath)
+		err := files.Stage(userID, kinds[i], images[i].ContentType, bytes.NewReader(images[i].Data))
 		if err != nil {
+			files.Rollback()
+			logrus.Error("Store kyc document fail=", err)
 			c.JSON(http.StatusInternalServerError, gin.H{
 				status.RespStatus: status.NewResponse(status.Unkonwn),
 			})
-			return
+			return nil, false
 		}
-
-		kyc.IDPhotoBack = fileSavePath[1:]
 	}
 
-	photoWithIDFile, err := c.FormFile("photo_with_id_file")
-	if err == nil {
-		fileSavePath, errCode := utils.GenerateNewFilePathAndRemoveOld(photoWithIDFile.Filename, savePath, kyc.PhotoWithID, fileSupportTypes)
-		if errCode != status.Success {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(errCode),
-			})
-			return
-		}
+	return files, true
+}
 
-		err = c.SaveUploadedFile(photoWithIDFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
+// streamKYCDocument 開啟檔案並記錄讀取後解密輸出，回應不允許快取
+func streamKYCDocument(c *gin.Context, doc models.KYCDocument) {
+	roleType := uint(c.GetInt(middlewares.ROLE_TYPE))
+	roleID := uint(c.GetInt(middlewares.ROLE_ID))
 
-		kyc.PhotoWithID = fileSavePath[1:]
+	reader, err := services.OpenKYCDocument(doc)
+	if err == storage.ErrNotFound {
+		logrus.Error("Kyc document file not found, id=", doc.ID)
+		c.JSON(http.StatusNotFound, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistKYCDocument),
+		})
+		return
 	}
+	if err != nil {
+		logrus.Error("Open kyc document fail, id=", doc.ID, ", err=", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+	defer reader.Close()
 
-	if data.Name != "" {
-		kyc.Name = data.Name
+	err = services.LogKYCAccess(doc, roleType, roleID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
 	}
 
-	if data.IdentityCard != "" {
-		kyc.Name = data.IdentityCard
+	c.Header("Content-Type", doc.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(doc.Size, 10))
+	c.Header("Content-Disposition", "inline")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	// 標頭已送出，解密失敗只能中斷連線，Content-Length 不符讓用戶端得知內容不完整
+	err = reader.Decrypt(c.Writer)
+	if err != nil {
+		logrus.Error("Stream kyc document fail, id=", doc.ID, ", err=", err)
+		c.Abort()
 	}
+}
 
-	if user.Status != data.Status {
-		user.Status = data.Status
-		err = services.UpdateUser(&user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
-		//如果KYC審核失敗則寄信給使用者
+func getKYCDocumentParam(c *gin.Context) (models.KYCDocument, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return models.KYCDocument{}, false
 	}
 
-	err = services.UpdateKYC(&kyc)
+	doc, err := services.GetKYCDocument(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistKYCDocument),
+		})
+		return doc, false
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
+		return doc, false
+	}
+
+	return doc, true
+}
+
+func getKYCSubmissionIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+func respondKYC(c *gin.Context, err error) {
+	errCode := status.Unkonwn
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		errCode = status.NotExistKYCSubmission
+	case err == services.ErrKYCApproved:
+		errCode = status.UserHasKYC
+	case err == services.ErrKYCNotPending:
+		errCode = status.KYCNotPending
+	case err == services.ErrKYCClaimed:
+		errCode = status.KYCClaimed
+	case err == services.ErrKYCNotClaimed:
+		errCode = status.KYCNotClaimed
+	case err == services.ErrInvalidReviewer:
+		errCode = status.InvalidKYCReviewer
+	case err == services.ErrInvalidRejectReason:
+		errCode = status.InvalidRejectReason
+	}
+
+	if errCode == status.Unkonwn {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		status.RespStatus: status.NewResponse(status.Success),
+	c.JSON(http.StatusBadRequest, gin.H{
+		status.RespStatus: status.NewResponse(errCode),
 	})
 }
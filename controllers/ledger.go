@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"invar/models"
+	"invar/services"
+	"invar/status"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LedgerResp struct {
+	Accounts []models.LedgerAccount `json:"accounts"`
+	Entries  []models.JournalEntry  `json:"entries"`
+}
+
+// GetLedger godoc
+// @Summary      獲得使用者的帳本分錄
+// @Description  獲得使用者的帳本帳戶與所有分錄
+// @Tags         Bank
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  status.ResponseWtihData{data=LedgerResp}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/ledger/{id} [get]
+// @Security     BearerAuth
+func GetLedger(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	accounts, err := services.GetLedgerAccounts(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	entries, err := services.GetLedgerEntries(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData: LedgerResp{
+			Accounts: accounts,
+			Entries:  entries,
+		},
+	})
+}
+
+// ReconcileLedger godoc
+// @Summary      帳本對帳
+// @Description  由分錄重新計算使用者餘額，並與 Bank 及 Token 的餘額比對
+// @Tags         Bank
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.LedgerReconciliation}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/ledger/{id}/reconcile [get]
+// @Security     BearerAuth
+func ReconcileLedger(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	results, err := services.ReconcileUserLedger(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   results,
+	})
+}
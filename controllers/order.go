@@ -39,6 +39,45 @@ func GetOrders(c *gin.Context) {
 	})
 }
 
+// GetOrder godoc
+// @Summary      獲得單筆訂單
+// @Description  獲得單筆訂單與狀態變更歷程
+// @Tags         Order
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "訂單ID"
+// @Success      200  {object}  status.ResponseWtihData{data=models.Order}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /order/{id} [get]
+// @Router       /admin/order/{id} [get]
+// @Security     BearerAuth
+func GetOrder(c *gin.Context) {
+	roleType := c.GetInt(middlewares.ROLE_TYPE)
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	orderID, _ := strconv.Atoi(c.Param("id"))
+
+	order, err := services.GetOrder(uint(orderID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistOrder),
+		})
+		return
+	}
+
+	if roleType == middlewares.User && roleID != int(order.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   order,
+	})
+}
+
 type GetOrdersByAdminReq struct {
 	Serial     string    `json:"serial"`
 	UserName   string    `json:"user_name"`
@@ -105,8 +144,9 @@ type OrderDetail struct {
 // @Accept       json
 // @Produce      json
 // @Param        order_details  body      AddOrderReq  true  "商品ID與數量"
-// @Success      200            {object}  status.Response
+// @Success      200            {object}  status.ResponseWtihData{data=models.Order}
 // @Failure      400            {object}  status.Response
+// @Failure      403            {object}  status.Response
 // @Failure      500            {object}  status.Response
 // @Router       /order [post]
 // @Security     BearerAuth
@@ -122,8 +162,30 @@ func AddOrder(c *gin.Context) {
 		return
 	}
 
+	if len(request.OrderDetails) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.GetUserById(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
 	orderItems := make([]models.OrderItem, 0)
 	for _, v := range request.OrderDetails {
+		if v.Quantity == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				status.RespStatus: status.NewResponse(status.BadRequest),
+			})
+			return
+		}
+
 		product, err := services.GetProduct(v.ProductID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -139,6 +201,13 @@ func AddOrder(c *gin.Context) {
 			return
 		}
 
+		if !services.CanBuyProduct(&user, &product) {
+			c.JSON(http.StatusForbidden, gin.H{
+				status.RespStatus: status.NewResponse(status.NotBuyPermission),
+			})
+			return
+		}
+
 		if product.Stock < v.Quantity {
 			c.JSON(http.StatusBadRequest, gin.H{
 				status.RespStatus: status.NewResponse(status.OutOfStock),
@@ -155,26 +224,39 @@ func AddOrder(c *gin.Context) {
 		orderItems = append(orderItems, orderItem)
 	}
 
-	err = services.AddOrder(uint(roleID), orderItems)
-	if err != nil {
+	order, errCode := services.AddOrder(uint(roleID), orderItems, orderActor(c))
+	if errCode == status.Unkonwn {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
 		return
 	}
 
+	if errCode != status.Success {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   order,
 	})
 }
 
+type ChangeOrderStatusReq struct {
+	Reason string `json:"reason"`
+}
+
 // CancelOrder godoc
 // @Summary      取消訂單
 // @Description  取消訂單
 // @Tags         Order
 // @Accept       json
 // @Produce      json
-// @Param        id                 path      int     true  "訂單ID"
+// @Param        id      path      int     true   "訂單ID"
+// @Param        reason  body      string  false  "原因"
 // @Success      200  {object}  status.Response
 // @Failure      400  {object}  status.Response
 // @Failure      500  {object}  status.Response
@@ -185,6 +267,10 @@ func CancelOrder(c *gin.Context) {
 	roleType := c.GetInt(middlewares.ROLE_TYPE)
 	roleID := c.GetInt(middlewares.ROLE_ID)
 	orderID, _ := strconv.Atoi(c.Param("id"))
+	var request ChangeOrderStatusReq
+
+	// 原因為選填，允許不帶 body
+	_ = c.ShouldBindJSON(&request)
 
 	order, err := services.GetOrder(uint(orderID))
 	if err != nil {
@@ -201,17 +287,8 @@ func CancelOrder(c *gin.Context) {
 		return
 	}
 
-	err = services.CancelOrder(&order)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		status.RespStatus: status.NewResponse(status.Success),
-	})
+	errCode := services.CancelOrder(&order, orderActor(c), request.Reason)
+	respondOrder(c, errCode)
 }
 
 type PaymentOrderReq struct {
@@ -221,7 +298,7 @@ type PaymentOrderReq struct {
 
 // PaymentOrder godoc
 // @Summary      付款通知
-// @Description  付款通知
+// @Description  提交付款的交易序號，系統會於鏈上確認後自動完成訂單，同一筆交易不能重複使用
 // @Tags         Order
 // @Accept       json
 // @Produce      json
@@ -240,7 +317,7 @@ func PaymentOrder(c *gin.Context) {
 	var request PaymentOrderReq
 
 	err := c.BindJSON(&request)
-	if err != nil {
+	if err != nil || request.TransactionChain == "" || request.TransactionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
@@ -262,21 +339,8 @@ func PaymentOrder(c *gin.Context) {
 		return
 	}
 
-	order.TransactionChain = request.TransactionChain
-	order.TransactionID = request.TransactionID
-	order.Status = models.WaitConfirmPayment
-	//寄通知給管理者
-	err = services.UpdateOrder(&order)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		status.RespStatus: status.NewResponse(status.Success),
-	})
+	errCode := services.PaymentOrder(&order, request.TransactionChain, request.TransactionID, orderActor(c))
+	respondOrder(c, errCode)
 }
 
 // CompletedOrder godoc
@@ -285,7 +349,8 @@ func PaymentOrder(c *gin.Context) {
 // @Tags         Order
 // @Accept       json
 // @Produce      json
-// @Param        id   path      int  true  "訂單ID"
+// @Param        id      path      int     true   "訂單ID"
+// @Param        reason  body      string  false  "原因"
 // @Success      200  {object}  status.Response
 // @Failure      400  {object}  status.Response
 // @Failure      500  {object}  status.Response
@@ -293,6 +358,10 @@ func PaymentOrder(c *gin.Context) {
 // @Security     BearerAuth
 func CompletedOrder(c *gin.Context) {
 	orderID, _ := strconv.Atoi(c.Param("id"))
+	var request ChangeOrderStatusReq
+
+	// 原因為選填，允許不帶 body
+	_ = c.ShouldBindJSON(&request)
 
 	order, err := services.GetOrder(uint(orderID))
 	if err != nil {
@@ -302,15 +371,63 @@ func CompletedOrder(c *gin.Context) {
 		return
 	}
 
-	err = services.CompletedOrder(&order)
+	errCode := services.CompletedOrder(&order, orderActor(c), request.Reason)
+	respondOrder(c, errCode)
+}
+
+// RefundOrder godoc
+// @Summary      訂單退款
+// @Description  退款已完成的訂單，收回已發放給使用者的代幣
+// @Tags         Order
+// @Accept       json
+// @Produce      json
+// @Param        id      path      int     true   "訂單ID"
+// @Param        reason  body      string  false  "原因"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/refund_order/{id} [patch]
+// @Security     BearerAuth
+func RefundOrder(c *gin.Context) {
+	orderID, _ := strconv.Atoi(c.Param("id"))
+	var request ChangeOrderStatusReq
+
+	// 原因為選填，允許不帶 body
+	_ = c.ShouldBindJSON(&request)
+
+	order, err := services.GetOrder(uint(orderID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistOrder),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		status.RespStatus: status.NewResponse(status.Success),
-	})
+	errCode := services.RefundOrder(&order, orderActor(c), request.Reason)
+	respondOrder(c, errCode)
+}
+
+func orderActor(c *gin.Context) services.OrderActor {
+	return services.OrderActor{
+		RoleType: uint(c.GetInt(middlewares.ROLE_TYPE)),
+		RoleID:   uint(c.GetInt(middlewares.ROLE_ID)),
+		IP:       c.ClientIP(),
+	}
+}
+
+func respondOrder(c *gin.Context, errCode int) {
+	switch errCode {
+	case status.Success:
+		c.JSON(http.StatusOK, gin.H{
+			status.RespStatus: status.NewResponse(status.Success),
+		})
+	case status.Unkonwn:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+	}
 }
@@ -4,7 +4,6 @@ import (
 	"invar/models"
 	"invar/services"
 	"invar/status"
-	"invar/utils"
 	"net/http"
 	"strconv"
 
@@ -34,6 +33,10 @@ func GetProducts(c *gin.Context) {
 		return
 	}
 
+	for i := range products {
+		services.FillProductURL(&products[i])
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   products,
@@ -60,6 +63,10 @@ func GetProductsByAdmin(c *gin.Context) {
 		return
 	}
 
+	for i := range products {
+		services.FillProductURL(&products[i])
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   products,
@@ -87,6 +94,7 @@ func GetProduct(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			status.RespStatus: status.NewResponse(status.BadRequest),
 		})
+		return
 	}
 
 	product, err := services.GetProduct(uint(id))
@@ -95,8 +103,10 @@ func GetProduct(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			status.RespStatus: status.NewResponse(status.NotExistProduct),
 		})
+		return
 	}
 
+	services.FillProductURL(&product)
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   product,
@@ -130,7 +140,7 @@ type AddProductReq struct {
 // @Param        contract_type     formData  string  true  "合約類型"
 // @Param        contract_address  formData  string  true  "合約地址"
 // @Param        buy_remissions    formData  []int   true  "購買權限"
-// @Param        small_image       formData  file    true  "預覽檔案"
+// @Param        small_image       formData  file    false  "預覽檔案，未上傳時由完整檔案產生縮圖"
 // @Param        large_image       formData  file    true  "完整檔案"
 // @Success      200               {object}  status.ResponseWtihData{data=models.Product}
 // @Failure      400               {object}  status.Response
@@ -149,14 +159,6 @@ func AddProduct(c *gin.Context) {
 		return
 	}
 
-	smallFile, bindErr := c.FormFile("small_image")
-	if bindErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.BadRequest),
-		})
-		return
-	}
-
 	largeFile, bindErr := c.FormFile("large_image")
 	if bindErr != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -165,37 +167,14 @@ func AddProduct(c *gin.Context) {
 		return
 	}
 
-	fileSupportTypes := []int{utils.PNG, utils.JPEG, utils.JPG}
-	if utils.CheckMediaType(smallFile.Filename, fileSupportTypes) {
-		c.JSON(http.StatusUnsupportedMediaType, gin.H{
-			status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
-		})
-		return
-	}
-
-	if utils.CheckMediaType(largeFile.Filename, fileSupportTypes) {
-		c.JSON(http.StatusUnsupportedMediaType, gin.H{
-			status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
-		})
-		return
-	}
-
-	savePath := status.SaveFileFolderPath + status.SaveFilePublicFolderPath + "/"
-	smallFileSavePath := utils.FilePathGenerator(smallFile.Filename, savePath)
-	err := c.SaveUploadedFile(smallFile, smallFileSavePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
-		return
-	}
+	// 預覽圖可省略，由完整圖片產生縮圖
+	smallFile, _ := c.FormFile("small_image")
 
-	largeFileSavePath := utils.FilePathGenerator(largeFile.Filename, savePath)
-	err = c.SaveUploadedFile(largeFile, largeFileSavePath)
+	upload := services.NewUpload()
+	image, previewImage, err := services.SaveProductImages(upload, largeFile, smallFile)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		upload.Rollback()
+		respondUploadError(c, err)
 		return
 	}
 
@@ -205,15 +184,15 @@ func AddProduct(c *gin.Context) {
 		Price:           data.Price,
 		Title:           data.Title,
 		Description:     data.Description,
-		Image:           largeFileSavePath[1:],
-		PreviewImage:    smallFileSavePath[1:],
+		Image:           image,
+		PreviewImage:    previewImage,
 		ContractChain:   data.ContractChain,
 		ContractType:    data.ContractType,
 		ContractAddress: data.ContractAddress,
 		BuyPermissions:  data.BuyPermissions,
 	}
 
-	err = services.AddProduct(&product)
+	err = services.AddProduct(&product, upload)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
@@ -221,6 +200,7 @@ func AddProduct(c *gin.Context) {
 		return
 	}
 
+	services.FillProductURL(&product)
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   product,
@@ -269,65 +249,36 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	savePath := status.SaveFileFolderPath + status.SaveFilePublicFolderPath + "/"
-	fileSupportTypes := []int{utils.PNG, utils.JPEG, utils.JPG}
-	smallFile, bindErr := c.FormFile("small_image")
-	if bindErr == nil {
-		if utils.CheckMediaType(smallFile.Filename, fileSupportTypes) {
-			c.JSON(http.StatusUnsupportedMediaType, gin.H{
-				status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
-			})
-			return
-		}
-
-		fileSavePath := utils.FilePathGenerator(smallFile.Filename, savePath)
-		err := c.SaveUploadedFile(smallFile, fileSavePath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
-
-		err = utils.RemoveFile(product.PreviewImage)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
-			return
-		}
-		product.PreviewImage = fileSavePath[1:]
-	}
+	upload := services.NewUpload()
+	smallFile, _ := c.FormFile("small_image")
 
 	largeFile, bindErr := c.FormFile("large_image")
 	if bindErr == nil {
-		if utils.CheckMediaType(largeFile.Filename, fileSupportTypes) {
-			c.JSON(http.StatusUnsupportedMediaType, gin.H{
-				status.RespStatus: status.NewResponse(status.UnsupportedMediaType),
-			})
-			return
-		}
-
-		fileSavePath := utils.FilePathGenerator(smallFile.Filename, savePath)
-		err := c.SaveUploadedFile(smallFile, fileSavePath)
+		// 只更新完整圖片時預覽圖也一併由新圖片產生
+		image, previewImage, err := services.SaveProductImages(upload, largeFile, smallFile)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
+			upload.Rollback()
+			respondUploadError(c, err)
 			return
 		}
 
-		err = utils.RemoveFile(product.Image)
+		upload.Replace(product.Image, product.PreviewImage)
+		product.Image = image
+		product.PreviewImage = previewImage
+	} else if smallFile != nil {
+		previewImage, err := services.SaveProductPreviewImage(upload, smallFile)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				status.RespStatus: status.NewResponse(status.Unkonwn),
-			})
+			upload.Rollback()
+			respondUploadError(c, err)
 			return
 		}
-		product.Image = fileSavePath[1:]
+
+		upload.Replace(product.PreviewImage)
+		product.PreviewImage = previewImage
 	}
 
-	err = services.UpdateProduct(&product)
+	// 資料更新後才刪除舊圖片，更新失敗時商品仍指向原本的檔案
+	err = services.UpdateProduct(&product, upload)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.UpdateFail),
@@ -335,6 +286,7 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	services.FillProductURL(&product)
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   product,
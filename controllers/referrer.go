@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"invar/middlewares"
+	"invar/models"
+	"invar/services"
+	"invar/status"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// GetReferees godoc
+// @Summary      獲得自己推薦的使用者
+// @Description  獲得自己推薦的使用者
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]services.Referee}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /referee [get]
+// @Security     BearerAuth
+func GetReferees(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+
+	referees, err := services.GetReferees(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   referees,
+	})
+}
+
+// GetReferralCommissions godoc
+// @Summary      獲得自己的推薦獎勵紀錄
+// @Description  獲得自己的推薦獎勵紀錄
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.ReferralCommission}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /referral_commission [get]
+// @Security     BearerAuth
+func GetReferralCommissions(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+
+	commissions, err := services.GetReferralCommissions(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   commissions,
+	})
+}
+
+// GetReferralTree godoc
+// @Summary      獲得使用者的推薦關係樹
+// @Description  獲得使用者往下的推薦關係樹
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  status.ResponseWtihData{data=services.ReferralNode}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/referral_tree/{id} [get]
+// @Security     BearerAuth
+func GetReferralTree(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	tree, err := services.GetReferralTree(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   tree,
+	})
+}
+
+// GetCommissionRules godoc
+// @Summary      獲得推薦獎勵規則
+// @Description  獲得推薦獎勵規則
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.CommissionRule}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/commission_rule [get]
+// @Security     BearerAuth
+func GetCommissionRules(c *gin.Context) {
+	rules, err := services.GetCommissionRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   rules,
+	})
+}
+
+type CommissionRuleReq struct {
+	Level     uint            `json:"level"`
+	ProductID uint            `json:"product_id"`
+	Rate      decimal.Decimal `json:"rate"`
+	Asset     string          `json:"asset"`
+	Status    bool            `json:"status"`
+}
+
+// AddCommissionRule godoc
+// @Summary      新增推薦獎勵規則
+// @Description  新增推薦獎勵規則，level 1 為直接推薦人，product_id 為 0 時套用所有商品
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Param        level       body      int     true   "推薦層級"
+// @Param        product_id  body      int     false  "商品ID"
+// @Param        rate        body      number  true   "獎勵比例"
+// @Param        asset       body      string  true   "發放資產(IVT/USDT)"
+// @Param        status      body      bool    true   "是否啟用"
+// @Success      200         {object}  status.ResponseWtihData{data=models.CommissionRule}
+// @Failure      400         {object}  status.Response
+// @Failure      500         {object}  status.Response
+// @Router       /admin/commission_rule [post]
+// @Security     BearerAuth
+func AddCommissionRule(c *gin.Context) {
+	var request CommissionRuleReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	errCode := checkCommissionRuleReq(&request)
+	if errCode != status.Success {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return
+	}
+
+	rule := models.CommissionRule{
+		Level:     request.Level,
+		ProductID: request.ProductID,
+		Rate:      request.Rate,
+		Asset:     request.Asset,
+		Status:    request.Status,
+	}
+
+	err = services.AddCommissionRule(&rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   rule,
+	})
+}
+
+// UpdateCommissionRule godoc
+// @Summary      更新推薦獎勵規則
+// @Description  更新推薦獎勵規則，只影響之後完成的訂單
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Param        id          path      int     true   "規則ID"
+// @Param        level       body      int     true   "推薦層級"
+// @Param        product_id  body      int     false  "商品ID"
+// @Param        rate        body      number  true   "獎勵比例"
+// @Param        asset       body      string  true   "發放資產(IVT/USDT)"
+// @Param        status      body      bool    true   "是否啟用"
+// @Success      200         {object}  status.Response
+// @Failure      400         {object}  status.Response
+// @Failure      500         {object}  status.Response
+// @Router       /admin/commission_rule/{id} [patch]
+// @Security     BearerAuth
+func UpdateCommissionRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var request CommissionRuleReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	errCode := checkCommissionRuleReq(&request)
+	if errCode != status.Success {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+		return
+	}
+
+	rule, err := services.GetCommissionRule(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistCommissionRule),
+		})
+		return
+	}
+
+	rule.Level = request.Level
+	rule.ProductID = request.ProductID
+	rule.Rate = request.Rate
+	rule.Asset = request.Asset
+	rule.Status = request.Status
+
+	err = services.UpdateCommissionRule(&rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// DeleteCommissionRule godoc
+// @Summary      刪除推薦獎勵規則
+// @Description  刪除推薦獎勵規則
+// @Tags         Referral
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "規則ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/commission_rule/{id} [delete]
+// @Security     BearerAuth
+func DeleteCommissionRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	rule, err := services.GetCommissionRule(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistCommissionRule),
+		})
+		return
+	}
+
+	err = services.DeleteCommissionRule(&rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+func checkCommissionRuleReq(request *CommissionRuleReq) int {
+	if request.Level == 0 || request.Rate.IsNegative() || request.Rate.GreaterThan(decimal.NewFromInt(1)) {
+		return status.BadRequest
+	}
+
+	if request.Asset != models.AssetIVT && request.Asset != models.AssetUSDT {
+		return status.UnsupportedAsset
+	}
+
+	return status.Success
+}
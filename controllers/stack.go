@@ -7,6 +7,7 @@ import (
 	"invar/status"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
@@ -55,7 +56,7 @@ func GetStack(c *gin.Context) {
 	stack, err := services.GetStack(uint(stackID))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
+			status.RespStatus: status.NewResponse(status.NotExistStack),
 		})
 		return
 	}
@@ -238,7 +239,7 @@ func GetStackRecord(c *gin.Context) {
 	stacksrecord, err := services.GetStackRecord(uint(id))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.NotExistUser),
+			status.RespStatus: status.NewResponse(status.NotExistStackRecord),
 		})
 		return
 	}
@@ -256,29 +257,232 @@ func GetStackRecord(c *gin.Context) {
 	})
 }
 
-// GetStackRecord godoc
-// @Summary      獲得單筆質押紀錄
-// @Description  獲得單筆質押紀錄
+type AddStackRecordReq struct {
+	StackID   uint `json:"stack_id"`
+	Quantity  uint `json:"quantity"`
+	AutoRenew bool `json:"auto_renew"`
+}
+
+// AddStackRecord godoc
+// @Summary      質押代幣
+// @Description  將持有的可用代幣質押至指定的質押項目
+// @Tags         Stack
+// @Accept       json
+// @Produce      json
+// @Param        stack_id    body      int   true   "Stack ID"
+// @Param        quantity    body      int   true   "質押數量"
+// @Param        auto_renew  body      bool  false  "期滿自動續約"
+// @Success      200         {object}  status.ResponseWtihData{data=models.StackRecord}
+// @Failure      400         {object}  status.Response
+// @Failure      500         {object}  status.Response
+// @Router       /stack_record [post]
+// @Security     BearerAuth
+func AddStackRecord(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	var request AddStackRecordReq
+
+	err := c.BindJSON(&request)
+	if err != nil || request.Quantity == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.GetUserById(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
+	stack, err := services.GetStack(request.StackID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistStack),
+		})
+		return
+	}
+
+	record, errCode := services.AddStackRecord(&user, &stack, request.Quantity, request.AutoRenew)
+	if errCode != status.Success {
+		respondStackRecord(c, errCode)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   record,
+	})
+}
+
+// UnlockStackRecord godoc
+// @Summary      解除質押
+// @Description  質押到期後解除質押，代幣退回可用數量，未發放的最後一期配息會一併發放；設定自動續約的質押需先取消自動續約
 // @Tags         Stack
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "StackRecord ID"
-// @Success      200  {object}  status.ResponseWtihData{data=models.StackRecord}
+// @Success      200  {object}  status.Response
 // @Failure      400  {object}  status.Response
 // @Failure      500  {object}  status.Response
-// @Router       /stack_record/{id} [get]
-// @Router       /admin/stack_record/{id} [get]
+// @Router       /unlock_stack_record/{id} [patch]
 // @Security     BearerAuth
 func UnlockStackRecord(c *gin.Context) {
-	//解除質押，需要確認是否質押結束
+	record, ok := getOwnStackRecordByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.UnlockStackRecord(&record)
+	respondStackRecord(c, errCode)
+}
+
+type AutoRenewStackRecordReq struct {
+	AutoRenew bool `json:"auto_renew"`
 }
 
+// AutoRenewStackRecord godoc
+// @Summary      設定自動續約
+// @Description  設定質押期滿後是否自動續約(只修改當前質押)
+// @Tags         Stack
+// @Accept       json
+// @Produce      json
+// @Param        id          path      int   true  "StackRecord ID"
+// @Param        auto_renew  body      bool  true  "期滿自動續約"
+// @Success      200         {object}  status.Response
+// @Failure      400         {object}  status.Response
+// @Failure      500         {object}  status.Response
+// @Router       /auto_renew_stack_record/{id} [patch]
+// @Security     BearerAuth
 func AutoRenewStackRecord(c *gin.Context) {
-	//當前質押項目期滿後，可自動續約(只修改當前)
+	var request AutoRenewStackRecordReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	record, ok := getOwnStackRecordByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.SetStackRecordAutoRenew(&record, request.AutoRenew)
+	respondStackRecord(c, errCode)
 }
 
+// ExchangeStack godoc
+// @Summary      兌換質押
+// @Description  將質押結束的代幣依兌換比例兌換回IVT
+// @Tags         Stack
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "StackRecord ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /exchange_stack_record/{id} [patch]
+// @Security     BearerAuth
 func ExchangeStack(c *gin.Context) {
-	//將質押結束項目兌換回IVT
+	record, ok := getOwnStackRecordByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.ExchangeStackRecord(&record)
+	respondStackRecord(c, errCode)
+}
+
+func getOwnStackRecordByParam(c *gin.Context) (models.StackRecord, bool) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	record, err := services.GetStackRecord(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistStackRecord),
+		})
+		return record, false
+	}
+
+	if record.UserID != uint(roleID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+		return record, false
+	}
+
+	return record, true
+}
+
+func respondStackRecord(c *gin.Context, errCode int) {
+	switch errCode {
+	case status.Success:
+		c.JSON(http.StatusOK, gin.H{
+			status.RespStatus: status.NewResponse(status.Success),
+		})
+	case status.Unkonwn:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+	case status.NotPermission:
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+	}
+}
+
+type GetStackProfitPreviewReq struct {
+	Until time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// GetStackProfitPreview godoc
+// @Summary      試算配息
+// @Description  試算到指定時間為止自動配息會發放的項目，不會實際發放
+// @Tags         Stack
+// @Accept       json
+// @Produce      json
+// @Param        until  query     string  false  "試算截止時間(RFC3339)，預設為現在"
+// @Success      200    {object}  status.ResponseWtihData{data=[]services.StackProfitPreview}
+// @Failure      400    {object}  status.Response
+// @Failure      500    {object}  status.Response
+// @Router       /admin/stack_profit_preview [get]
+// @Security     BearerAuth
+func GetStackProfitPreview(c *gin.Context) {
+	var request GetStackProfitPreviewReq
+	err := c.BindQuery(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	if request.Until.IsZero() {
+		request.Until = time.Now()
+	}
+
+	previews, err := services.PreviewStackProfits(request.Until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   previews,
+	})
 }
 
 type AddStackProfitRecordReq struct {
@@ -387,6 +591,13 @@ func DeleteStackProfitRecord(c *gin.Context) {
 	}
 
 	err = services.DeleteStackProfitRecord(&bank, &record)
+	if err == services.ErrInsufficientBalance {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.InsufficientBalance),
+		})
+		return
+	}
+
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"invar/database"
+	"errors"
 	"invar/middlewares"
 	"invar/models"
 	"invar/services"
@@ -11,6 +11,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Authenticate godoc
@@ -22,6 +23,7 @@ import (
 // @Param        account   body      string  true   "帳號"
 // @Param        password  body      string  true   "密碼"
 // @Param        tfa       body      string  false  "二階段驗證碼"
+// @Param        recovery_code  body  string  false  "救援碼，無法使用二階段驗證碼時使用"
 // @Success      200             {object}  status.ResponseWtihData{data=string}
 // @Failure      400             {object}  status.Response
 // @Failure      500             {object}  status.Response
@@ -38,15 +40,12 @@ func Authenticate(c *gin.Context) {
 		return
 	}
 
-	roleID, errCode := checkLoginInfo(roleType, data.Account, data.Password, data.TFA)
-	if errCode != status.Success {
-		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(errCode),
-		})
+	roleID, ok := authenticateRole(c, roleType, data, false)
+	if !ok {
 		return
 	}
 
-	refreshToken, err := services.GenerateRefreshToken(roleType, roleID, c.ClientIP())
+	refreshToken, err := services.GenerateRefreshToken(roleType, roleID, c.ClientIP(), c.Request.UserAgent())
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -55,11 +54,17 @@ func Authenticate(c *gin.Context) {
 		return
 	}
 
-	accessToken := services.GenerateAccessToken(roleType, roleID)
+	accessToken, err := services.GenerateAccessToken(roleType, roleID, refreshToken.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
 
 	c.SetCookie(middlewares.REFRESH_TOKEN, refreshToken.Token, 3600, "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{
-		status.RespStatus: status.NewResponse(status.Unkonwn),
+		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   accessToken,
 	})
 }
@@ -137,6 +142,18 @@ func RegisterUser(c *gin.Context) {
 	}
 	user.SetPassword(data.Password)
 
+	if data.ReferrerCode != "" {
+		referrerID, err := services.GetReferrerIDByCode(data.ReferrerCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				status.RespStatus: status.NewResponse(status.NotExistReferrerCode),
+			})
+			return
+		}
+		user.Referrer = data.ReferrerCode
+		user.ReferrerID = referrerID
+	}
+
 	err = services.RegisterUser(&user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -275,13 +292,29 @@ func ResetPasswordWithToken(c *gin.Context) {
 		return
 	}
 
-	if services.CheckResetPasswordAccessToekn(data.Email, data.AccessToken) {
+	if !services.CheckResetPasswordAccessToekn(data.Email, data.AccessToken) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.TokenIsInvalid),
 		})
 		return
 	}
 
+	user, err := services.GetUserByEmail(data.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
+	err = services.ChangeUserPassword(&user, data.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
 	})
@@ -325,8 +358,8 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetUint(middlewares.ROLE_ID)
-	if userID != 0 {
+	userID := uint(c.GetInt(middlewares.ROLE_ID))
+	if userID == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			status.RespStatus: status.NewResponse(status.NotExistUser),
 		})
@@ -349,9 +382,8 @@ func ChangeUserPassword(c *gin.Context) {
 		return
 	}
 
-	user.SetPassword(data.Password)
-	result := services.UpdateUser(&user)
-	if result != nil {
+	err = services.ChangeUserPassword(&user, data.Password)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
@@ -408,9 +440,8 @@ func ChangeUserPasswordByAdmin(c *gin.Context) {
 		return
 	}
 
-	user.SetPassword(data.Password)
-	result := database.DB.Model(&user).Updates(&user)
-	if result.Error != nil {
+	err = services.ChangeUserPassword(&user, data.Password)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
 		})
@@ -421,31 +452,52 @@ func ChangeUserPasswordByAdmin(c *gin.Context) {
 		status.RespStatus: status.NewResponse(status.Success)})
 }
 
+// GetUserTFA godoc
+// @Summary      獲得二階段驗證QRCode
+// @Description  獲得綁定二階段驗證的QRCode，已啟用時無法取得
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]byte}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /tfa [get]
+// @Security     BearerAuth
 func GetUserTFA(c *gin.Context) {
 	roleID := c.GetInt(middlewares.ROLE_ID)
 
 	user, err := services.GetUserById(uint(roleID))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			status.RespStatus: status.NewResponse(status.ExistUser),
+			status.RespStatus: status.NewResponse(status.NotExistUser),
 		})
 		return
 	}
 
 	qrCode, err := services.GetUserTFA(&user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondTFA(c, err)
 		return
 	}
 
-	c.JSON(http.StatusBadRequest, gin.H{
-		status.RespStatus: status.NewResponse(status.ExistUser),
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
 		status.RespData:   qrCode,
 	})
 }
 
+// EnableUserTFA godoc
+// @Summary      啟用二階段驗證
+// @Description  驗證成功後啟用二階段驗證，並回傳只會顯示一次的救援碼
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.ResponseWtihData{data=[]string}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /enable_tfa [post]
+// @Security     BearerAuth
 func EnableUserTFA(c *gin.Context) {
 	var data TFAReq
 
@@ -467,19 +519,75 @@ func EnableUserTFA(c *gin.Context) {
 		return
 	}
 
-	err = services.EnableUserTFA(&user, data.TFA)
+	codes, err := services.EnableUserTFA(&user, data.TFA)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
+		respondTFA(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   codes,
+	})
+}
+
+// RegenerateUserRecoveryCodes godoc
+// @Summary      重新產生救援碼
+// @Description  重新產生二階段驗證的救援碼，舊的救援碼全部失效
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.ResponseWtihData{data=[]string}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /recovery_code [post]
+// @Security     BearerAuth
+func RegenerateUserRecoveryCodes(c *gin.Context) {
+	var data TFAReq
+
+	roleID := c.GetInt(middlewares.ROLE_ID)
+
+	bindErr := c.BindJSON(&data)
+	if bindErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.GetUserById(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
 		})
 		return
 	}
 
+	codes, err := services.RegenerateUserRecoveryCodes(&user, data.TFA)
+	if err != nil {
+		respondTFA(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   codes,
 	})
 }
 
+// DisableUserTFA godoc
+// @Summary      停用二階段驗證
+// @Description  停用二階段驗證
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        tfa  body      string  true  "二階段驗證碼"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /disable_tfa [post]
+// @Security     BearerAuth
 func DisableUserTFA(c *gin.Context) {
 	var data TFAReq
 
@@ -503,9 +611,7 @@ func DisableUserTFA(c *gin.Context) {
 
 	err = services.DisableUserTFA(&user, data.TFA, false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			status.RespStatus: status.NewResponse(status.Unkonwn),
-		})
+		respondTFA(c, err)
 		return
 	}
 
@@ -514,9 +620,27 @@ func DisableUserTFA(c *gin.Context) {
 	})
 }
 
+// DisableUserTFAByAdmin godoc
+// @Summary      管理者停用使用者二階段驗證
+// @Description  管理者停用使用者二階段驗證，用於使用者遺失驗證裝置與救援碼
+// @Tags         TFA
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "使用者ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/disable_user_tfa/{id} [patch]
+// @Security     BearerAuth
 func DisableUserTFAByAdmin(c *gin.Context) {
 	param := c.Param("id")
 	id, err := strconv.Atoi(param)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
 
 	user, err := services.GetUserById(uint(id))
 	if err != nil {
@@ -526,7 +650,49 @@ func DisableUserTFAByAdmin(c *gin.Context) {
 		return
 	}
 
-	err = services.DisableUserTFA(&user, "", false)
+	err = services.DisableUserTFA(&user, "", true)
+	if err != nil {
+		respondTFA(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+	})
+}
+
+// SearchUsers godoc
+// @Summary      搜尋使用者
+// @Description  依條件分頁搜尋使用者，信箱與名稱為部分比對
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        email         query     string  false  "信箱"
+// @Param        username      query     string  false  "名稱"
+// @Param        status        query     int     false  "狀態"
+// @Param        kyc_state     query     string  false  "實名資料 none:未提交 submitted:已提交"
+// @Param        created_from  query     string  false  "註冊日期起 2006-01-02"
+// @Param        created_to    query     string  false  "註冊日期迄 2006-01-02"
+// @Param        referrer_id   query     int     false  "推薦人ID"
+// @Param        page_number   query     int     false  "分頁號碼"
+// @Param        per_page      query     int     false  "每頁數量"
+// @Success      200           {object}  status.ResponseWtihData{data=services.UserSearchResult}
+// @Failure      400           {object}  status.Response
+// @Failure      500           {object}  status.Response
+// @Router       /admin/user [get]
+// @Security     BearerAuth
+func SearchUsers(c *gin.Context) {
+	var search services.UserSearch
+
+	err := c.ShouldBindQuery(&search)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	result, err := services.SearchUsers(search)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			status.RespStatus: status.NewResponse(status.Unkonwn),
@@ -536,5 +702,198 @@ func DisableUserTFAByAdmin(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   result,
+	})
+}
+
+// GetUserDetail godoc
+// @Summary      獲得使用者詳細資料
+// @Description  獲得使用者與實名、錢包、訂單、質押紀錄與停用紀錄
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "使用者ID"
+// @Success      200  {object}  status.ResponseWtihData{data=services.UserDetail}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/user/{id} [get]
+// @Security     BearerAuth
+func GetUserDetail(c *gin.Context) {
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	detail, err := services.GetUserDetail(userID)
+	if err != nil {
+		respondUserByAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   detail,
 	})
 }
+
+type UserStatusReq struct {
+	Reason string `json:"reason"`
+}
+
+// DisableUser godoc
+// @Summary      停用使用者
+// @Description  停用使用者並登出所有裝置
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id      path      int     true  "使用者ID"
+// @Param        reason  body      string  true  "停用原因"
+// @Success      200     {object}  status.ResponseWtihData{data=models.User}
+// @Failure      400     {object}  status.Response
+// @Failure      500     {object}  status.Response
+// @Router       /admin/disable_user/{id} [patch]
+// @Security     BearerAuth
+func DisableUser(c *gin.Context) {
+	var data UserStatusReq
+
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := c.BindJSON(&data)
+	if err != nil || data.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	adminID := uint(c.GetInt(middlewares.ROLE_ID))
+	user, err := services.DisableUser(adminID, userID, data.Reason, c.ClientIP())
+	if err != nil {
+		respondUserByAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   user,
+	})
+}
+
+// EnableUser godoc
+// @Summary      啟用使用者
+// @Description  啟用被停用的使用者，還原為停用前的狀態
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id      path      int     true   "使用者ID"
+// @Param        reason  body      string  false  "啟用原因"
+// @Success      200     {object}  status.ResponseWtihData{data=models.User}
+// @Failure      400     {object}  status.Response
+// @Failure      500     {object}  status.Response
+// @Router       /admin/enable_user/{id} [patch]
+// @Security     BearerAuth
+func EnableUser(c *gin.Context) {
+	var data UserStatusReq
+
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := c.BindJSON(&data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	adminID := uint(c.GetInt(middlewares.ROLE_ID))
+	user, err := services.EnableUser(adminID, userID, data.Reason)
+	if err != nil {
+		respondUserByAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   user,
+	})
+}
+
+type UserCommentReq struct {
+	Comment string `json:"comment"`
+}
+
+// UpdateUserComment godoc
+// @Summary      修改使用者備註
+// @Description  修改使用者備註
+// @Tags         User
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int     true  "使用者ID"
+// @Param        comment  body      string  true  "備註"
+// @Success      200      {object}  status.ResponseWtihData{data=models.User}
+// @Failure      400      {object}  status.Response
+// @Failure      500      {object}  status.Response
+// @Router       /admin/user_comment/{id} [patch]
+// @Security     BearerAuth
+func UpdateUserComment(c *gin.Context) {
+	var data UserCommentReq
+
+	userID, ok := getUserIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := c.BindJSON(&data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.UpdateUserComment(userID, data.Comment)
+	if err != nil {
+		respondUserByAdmin(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   user,
+	})
+}
+
+func getUserIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+func respondUserByAdmin(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+	case err == services.ErrUserStatusNotChanged:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.UserStatusNotChanged),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+	}
+}
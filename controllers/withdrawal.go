@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"invar/middlewares"
+	"invar/models"
+	"invar/services"
+	"invar/status"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// GetWithdrawals godoc
+// @Summary      使用者獲得自己的提領申請
+// @Description  使用者獲得自己的提領申請
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  status.ResponseWtihData{data=[]models.Withdrawal}
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /withdrawal [get]
+// @Security     BearerAuth
+func GetWithdrawals(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+
+	withdrawals, err := services.GetWithdrawals(uint(roleID), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   withdrawals,
+	})
+}
+
+type GetWithdrawalsByAdminReq struct {
+	UserID uint `form:"user_id"`
+	Status uint `form:"status"`
+}
+
+// GetWithdrawalsByAdmin godoc
+// @Summary      獲得所有使用者的提領申請
+// @Description  獲得所有使用者的提領申請
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        user_id  query     int  false  "User ID"
+// @Param        status   query     int  false  "提領狀態"
+// @Success      200      {object}  status.ResponseWtihData{data=[]models.Withdrawal}
+// @Failure      400      {object}  status.Response
+// @Failure      500      {object}  status.Response
+// @Router       /admin/withdrawal [get]
+// @Security     BearerAuth
+func GetWithdrawalsByAdmin(c *gin.Context) {
+	var request GetWithdrawalsByAdminReq
+	err := c.BindQuery(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	withdrawals, err := services.GetWithdrawals(request.UserID, request.Status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(status.Unkonwn),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   withdrawals,
+	})
+}
+
+type AddWithdrawalReq struct {
+	WhiteListID uint            `json:"whitelist_id"`
+	Asset       string          `json:"asset"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	TFA         string          `json:"tfa"`
+}
+
+// AddWithdrawal godoc
+// @Summary      申請提領
+// @Description  申請提領至白名單地址，需二階段驗證，申請後會寄出只能用於此申請的信箱驗證碼，每分鐘只能申請一次
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        whitelist_id  body      int     true  "白名單ID"
+// @Param        asset         body      string  true  "資產(IVT/USDT)"
+// @Param        quantity      body      number  true  "數量"
+// @Param        tfa           body      string  true  "二階段驗證碼"
+// @Success      200           {object}  status.ResponseWtihData{data=models.Withdrawal}
+// @Failure      400           {object}  status.Response
+// @Failure      429           {object}  status.Response
+// @Failure      500           {object}  status.Response
+// @Router       /withdrawal [post]
+// @Security     BearerAuth
+func AddWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	var request AddWithdrawalReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	user, err := services.GetUserById(uint(roleID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistUser),
+		})
+		return
+	}
+
+	if !user.TFAEnable {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.TFANotEnabled),
+		})
+		return
+	}
+
+	err = services.CheckUserTFA(&user, request.TFA)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.IncorrectTFA),
+		})
+		return
+	}
+
+	whitelist, err := services.GetWhiteList(request.WhiteListID)
+	if err != nil || whitelist.UserID != user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistWhiteList),
+		})
+		return
+	}
+
+	withdrawal, errCode := services.AddWithdrawal(&user, &whitelist, request.Asset, request.Quantity)
+	if errCode != status.Success {
+		respondWithdrawal(c, errCode)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		status.RespStatus: status.NewResponse(status.Success),
+		status.RespData:   withdrawal,
+	})
+}
+
+type ConfirmWithdrawalReq struct {
+	EmailCode string `json:"email_code"`
+}
+
+// ConfirmWithdrawal godoc
+// @Summary      確認提領
+// @Description  輸入申請提領時寄出的信箱驗證碼確認提領，確認後凍結提領金額
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        id          path      int     true  "提領ID"
+// @Param        email_code  body      string  true  "信箱驗證碼"
+// @Success      200         {object}  status.Response
+// @Failure      400         {object}  status.Response
+// @Failure      500         {object}  status.Response
+// @Router       /confirm_withdrawal/{id} [patch]
+// @Security     BearerAuth
+func ConfirmWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var request ConfirmWithdrawalReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	withdrawal, err := services.GetWithdrawal(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistWithdrawal),
+		})
+		return
+	}
+
+	if withdrawal.UserID != uint(roleID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			status.RespStatus: status.NewResponse(status.NotPermission),
+		})
+		return
+	}
+
+	err = services.VerifyWithdrawalCode(&withdrawal, request.EmailCode)
+	switch err {
+	case nil:
+	case services.ErrWithdrawalCodeIncorrect:
+		respondWithdrawal(c, status.ValidCodeIsIncorrect)
+		return
+	case services.ErrWithdrawalCodeExpired:
+		respondWithdrawal(c, status.ValidCodeIsExpired)
+		return
+	default:
+		respondWithdrawal(c, status.Unkonwn)
+		return
+	}
+
+	errCode := services.ConfirmWithdrawal(&withdrawal)
+	respondWithdrawal(c, errCode)
+}
+
+// ApproveWithdrawal godoc
+// @Summary      核准提領
+// @Description  核准提領
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "提領ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/approve_withdrawal/{id} [patch]
+// @Security     BearerAuth
+func ApproveWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	withdrawal, ok := getWithdrawalByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.ApproveWithdrawal(&withdrawal, uint(roleID))
+	respondWithdrawal(c, errCode)
+}
+
+type RejectWithdrawalReq struct {
+	Comment string `json:"comment"`
+}
+
+// RejectWithdrawal godoc
+// @Summary      駁回提領
+// @Description  駁回提領，已凍結的金額將退回使用者
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int     true   "提領ID"
+// @Param        comment  body      string  false  "駁回原因"
+// @Success      200      {object}  status.Response
+// @Failure      400      {object}  status.Response
+// @Failure      500      {object}  status.Response
+// @Router       /admin/reject_withdrawal/{id} [patch]
+// @Security     BearerAuth
+func RejectWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	var request RejectWithdrawalReq
+
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	withdrawal, ok := getWithdrawalByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.RejectWithdrawal(&withdrawal, uint(roleID), request.Comment)
+	respondWithdrawal(c, errCode)
+}
+
+type BroadcastWithdrawalReq struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+// BroadcastWithdrawal godoc
+// @Summary      提領已上鏈
+// @Description  記錄提領的鏈上交易序號
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        id              path      int     true  "提領ID"
+// @Param        transaction_id  body      string  true  "交易序號"
+// @Success      200             {object}  status.Response
+// @Failure      400             {object}  status.Response
+// @Failure      500             {object}  status.Response
+// @Router       /admin/broadcast_withdrawal/{id} [patch]
+// @Security     BearerAuth
+func BroadcastWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	var request BroadcastWithdrawalReq
+
+	err := c.BindJSON(&request)
+	if err != nil || request.TransactionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.BadRequest),
+		})
+		return
+	}
+
+	withdrawal, ok := getWithdrawalByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.BroadcastWithdrawal(&withdrawal, uint(roleID), request.TransactionID)
+	respondWithdrawal(c, errCode)
+}
+
+// CompletedWithdrawal godoc
+// @Summary      完成提領
+// @Description  鏈上交易確認後完成提領並結算手續費
+// @Tags         Withdrawal
+// @Accept       json
+// @Produce      json
+// @Param        id   path      int  true  "提領ID"
+// @Success      200  {object}  status.Response
+// @Failure      400  {object}  status.Response
+// @Failure      500  {object}  status.Response
+// @Router       /admin/completed_withdrawal/{id} [patch]
+// @Security     BearerAuth
+func CompletedWithdrawal(c *gin.Context) {
+	roleID := c.GetInt(middlewares.ROLE_ID)
+	withdrawal, ok := getWithdrawalByParam(c)
+	if !ok {
+		return
+	}
+
+	errCode := services.CompleteWithdrawal(&withdrawal, uint(roleID))
+	respondWithdrawal(c, errCode)
+}
+
+func getWithdrawalByParam(c *gin.Context) (models.Withdrawal, bool) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	withdrawal, err := services.GetWithdrawal(uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(status.NotExistWithdrawal),
+		})
+		return withdrawal, false
+	}
+
+	return withdrawal, true
+}
+
+func respondWithdrawal(c *gin.Context, errCode int) {
+	switch errCode {
+	case status.Success:
+		c.JSON(http.StatusOK, gin.H{
+			status.RespStatus: status.NewResponse(status.Success),
+		})
+	case status.Unkonwn, status.SendEmailFail:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+	case status.RequestTooFrequently:
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			status.RespStatus: status.NewResponse(errCode),
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -43,11 +44,131 @@ func Connect(dsn string) {
 }
 
 func AutoMigrate() {
-	DB.AutoMigrate(models.Admin{}, models.User{}, models.UserKYC{},
+	DB.AutoMigrate(models.Admin{}, models.AdminRole{}, models.User{}, models.UserKYC{},
 		models.Token{}, models.Bank{}, models.Withdrawal{},
-		models.RefreshToken{}, models.WhiteList{},
-		models.Product{}, models.Order{}, models.OrderItem{},
-		models.Stack{}, models.StackRecord{}, models.StackProfitRecord{})
+		models.RefreshToken{}, models.RecoveryCode{}, models.WhiteList{},
+		models.Product{}, models.Order{}, models.OrderItem{}, models.OrderStatusHistory{},
+		models.Stack{}, models.StackRecord{}, models.StackProfitRecord{},
+		models.LedgerAccount{}, models.JournalEntry{}, models.Posting{},
+		models.CommissionRule{}, models.ReferralCommission{},
+		models.UserStatusLog{}, models.KYCSubmission{}, models.KYCDocument{}, models.KYCAccessLog{},
+		dataMigration{})
+
+	backfillUserStatus()
+	backfillProfitStartTime()
+	dropPlaintextRefreshTokens()
+	RunDataMigration("grant_kyc_permissions", grantKYCPermissions)
+}
+
+// dataMigration 記錄已執行過的資料遷移，只需執行一次的遷移才使用
+type dataMigration struct {
+	Name      string `gorm:"primaryKey;size:100"`
+	CreatedAt time.Time
+}
+
+// RunDataMigration 在交易中執行尚未執行過的資料遷移，失敗時下次啟動會重試。
+// 多台同時啟動時，後寫入紀錄的交易會等待先執行的交易完成，不會重複執行。
+func RunDataMigration(name string, migrate func(tx *gorm.DB) error) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&dataMigration{Name: name})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		return migrate(tx)
+	})
+	if err != nil {
+		fmt.Println("Data migration "+name+" fail:", err)
+	}
+}
+
+// grantKYCPermissions 實名審核從使用者權限拆出 QueryKYC 與 ModifyKYC 後，
+// 預設角色補上新的預設權限，自訂角色與個別授權則依原本的 QueryUser、ModifyUser 補上
+func grantKYCPermissions(tx *gorm.DB) error {
+	for _, role := range permission.GetDefaultRoles() {
+		for _, code := range []int32{permission.QueryKYC, permission.ModifyKYC} {
+			if !containsPermission(role.Permissions, code) {
+				continue
+			}
+
+			err := tx.Exec("UPDATE admin_roles SET permissions = array_append(permissions, ?) "+
+				"WHERE built_in AND name = ? AND NOT (? = ANY(COALESCE(permissions, '{}')))",
+				code, role.Name, code).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	grants := map[int32]int32{
+		permission.QueryUser:  permission.QueryKYC,
+		permission.ModifyUser: permission.ModifyKYC,
+	}
+	for from, code := range grants {
+		err := tx.Exec("UPDATE admin_roles SET permissions = array_append(permissions, ?) "+
+			"WHERE NOT built_in AND ? = ANY(permissions) AND NOT (? = ANY(permissions))",
+			code, from, code).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("UPDATE admins SET premissions = array_append(premissions, ?) "+
+			"WHERE ? = ANY(premissions) AND NOT (? = ANY(premissions)) "+
+			"AND NOT (? = ANY(COALESCE(revoked_permissions, '{}')))",
+			code, from, code, from).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsPermission(codes []int32, code int32) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backfillUserStatus 舊版註冊時沒有設定狀態，補為已註冊
+func backfillUserStatus() {
+	err := DB.Model(&models.User{}).Where("status = 0").UpdateColumn("status", models.Registed).Error
+	if err != nil {
+		fmt.Println("Backfill user status fail:", err)
+	}
+}
+
+// backfillProfitStartTime 舊版質押紀錄沒有配息起算時間，以建立時間補上
+func backfillProfitStartTime() {
+	err := DB.Model(&models.StackRecord{}).Where("profit_start_time IS NULL").
+		UpdateColumn("profit_start_time", gorm.Expr("created_at")).Error
+	if err != nil {
+		fmt.Println("Backfill profit start time fail:", err)
+	}
+}
+
+// dropPlaintextRefreshTokens 舊版以明文保存 refresh token，改為只保存雜湊後舊令牌已無法使用，
+// 撤銷舊令牌並刪除明文欄位
+func dropPlaintextRefreshTokens() {
+	if !DB.Migrator().HasColumn(&models.RefreshToken{}, "token") {
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.RefreshToken{}).Where("token_hash IS NULL").
+			UpdateColumn("revoked", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&models.RefreshToken{}, "token")
+	})
+	if err != nil {
+		fmt.Println("Drop plaintext refresh tokens fail:", err)
+	}
 }
 
 func InitDefaultAdmin(account, password string) {
@@ -57,6 +178,7 @@ func InitDefaultAdmin(account, password string) {
 
 	if result.RowsAffected != 0 {
 		fmt.Println("Admin created.")
+		ensureSuperAdmin()
 		return
 	}
 
@@ -64,6 +186,8 @@ func InitDefaultAdmin(account, password string) {
 	admin = models.Admin{
 		Account:     account,
 		Premissions: permissions,
+		Status:      models.AdminActive,
+		SuperAdmin:  true,
 	}
 
 	admin.SetPassword(password)
@@ -71,6 +195,35 @@ func InitDefaultAdmin(account, password string) {
 	DB.Save(&admin)
 }
 
+// ensureSuperAdmin 舊資料沒有超級管理者時，將最早建立的管理者設為超級管理者
+func ensureSuperAdmin() {
+	var count int64
+	DB.Model(&models.Admin{}).Where("super_admin = ?", true).Count(&count)
+	if count != 0 {
+		return
+	}
+
+	var admin models.Admin
+	result := DB.Order("id").Limit(1).Find(&admin)
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	DB.Model(&admin).UpdateColumn("super_admin", true)
+}
+
+// InitDefaultRoles 建立預設的權限群組，已存在的角色不會被覆寫
+func InitDefaultRoles() {
+	for _, role := range permission.GetDefaultRoles() {
+		DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AdminRole{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+			BuiltIn:     true,
+		})
+	}
+}
+
 func SetupRedis(password string) {
 	RDS = redis.NewClient(&redis.Options{
 		Addr:     "redis:6379",
@@ -4,3 +4,14 @@ const ReferrerCache string = "REFERRER_CACHE"
 const EmailCodeCache string = "EMAIL_CODE_CACHE"
 const ResetPasswordCache string = "RESET_PASSWORD_CACHE"
 const ProductCache string = "PRODUCT_CACHE"
+const SchedulerLock string = "SCHEDULER_LOCK"
+const TokenVersionCache string = "TOKEN_VERSION_CACHE"
+const RevokedSessionCache string = "REVOKED_SESSION"
+const TFAUsedCache string = "TFA_USED"
+const LoginFailCache string = "LOGIN_FAIL"
+const LoginDelayCache string = "LOGIN_DELAY"
+const LoginLockCache string = "LOGIN_LOCK"
+const LoginUnlockCache string = "LOGIN_UNLOCK"
+const RateLimitCache string = "RATE_LIMIT"
+const AdminPermissionCache string = "ADMIN_PERMISSION_CACHE"
+const WithdrawalCodeCache string = "WITHDRAWAL_CODE"
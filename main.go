@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"invar/database"
 	"invar/docs"
 	"invar/middlewares"
@@ -24,16 +26,48 @@ import (
 // @in                          header
 // @name                        Authorization
 func main() {
+	rotateKey := flag.Bool("rotate-paseto-key", false, "rotate the paseto signing key and exit")
+	migrateStorage := flag.Bool("migrate-storage", false, "move legacy local files into the configured storage backend and exit")
+	flag.Parse()
+
 	err := godotenv.Load("config.env")
 	if err != nil {
 		panic("Error loading .env file")
 	}
 
+	if *rotateKey {
+		services.InitSymmetricKey()
+		kid, err := services.RotateSymmetricKey()
+		if err != nil {
+			panic("Rotate paseto key fail: " + err.Error())
+		}
+		fmt.Println("Paseto key was rotated, current kid=" + kid)
+		return
+	}
+
 	database.Connect(os.Getenv("DB_CONNECT_DSN"))
 	database.AutoMigrate()
+
+	if *migrateStorage {
+		services.InitStorage()
+		services.InitKYCStorage()
+		err := services.MigrateStorage()
+		if err != nil {
+			panic("Migrate storage fail: " + err.Error())
+		}
+		fmt.Println("Legacy files were moved into the storage backend")
+		return
+	}
+
 	database.SetupRedis(os.Getenv("REDIS_PASSWORD"))
 	database.InitDefaultAdmin(os.Getenv("DEFAULT_ADMIN_ACCOUNT"), os.Getenv("DEFAULT_ADMIN_PASSWORD"))
+	database.InitDefaultRoles()
 	services.InitSymmetricKey()
+	services.InitStorage()
+	services.InitKYCStorage()
+	services.InitLedgerOpeningBalances()
+	services.InitChainClients()
+	services.StartSchedulers()
 
 	//Swagger Setting
 	docs.SwaggerInfo.Title = "InVar API"
@@ -1,7 +1,6 @@
 package middlewares
 
 import (
-	"invar/models"
 	"invar/services"
 	"invar/status"
 	"net/http"
@@ -21,6 +20,9 @@ const (
 const (
 	ROLE_TYPE     = "ROLE_TYPE"
 	ROLE_ID       = "ROLE_ID"
+	SESSION_ID    = "SESSION_ID"
+	TOKEN_ID      = "TOKEN_ID"
+	PERMISSIONS   = "PERMISSIONS"
 	REFRESH_TOKEN = "refresh_toekn"
 )
 
@@ -44,7 +46,7 @@ func Auth() func(c *gin.Context) {
 			return
 		}
 
-		roleType, roleID, err := services.ParseToken(parts[1])
+		claims, err := services.ParseToken(parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				status.RespStatus: status.NewResponse(status.TokenIsInvalid),
@@ -53,53 +55,19 @@ func Auth() func(c *gin.Context) {
 			return
 		}
 
-		c.Set(ROLE_TYPE, roleType)
-		c.Set(ROLE_ID, roleID)
+		c.Set(ROLE_TYPE, int(claims.RoleType))
+		c.Set(ROLE_ID, int(claims.RoleID))
+		c.Set(SESSION_ID, int(claims.SessionID))
+		c.Set(TOKEN_ID, claims.TokenID)
+		c.Set(PERMISSIONS, claims.Permissions)
 
 		c.Next()
 	}
 }
 
+// CheckUserPremission 只允許通過實名認證的使用者
 func CheckUserPremission() func(c *gin.Context) {
-	return func(c *gin.Context) {
-		roleType := c.GetInt(ROLE_TYPE)
-		roleID := c.GetInt(ROLE_ID)
-
-		if roleType != int(User) || roleID == 0 {
-			c.JSON(http.StatusForbidden, gin.H{
-				status.RespStatus: status.NewResponse(status.NotExistUser),
-			})
-			c.Abort()
-			return
-		}
-
-		user, err := services.GetUserById(uint(roleID))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				status.RespStatus: status.NewResponse(status.NotExistUser),
-			})
-			c.Abort()
-			return
-		}
-
-		if user.Status == models.Disabled {
-			c.JSON(http.StatusForbidden, gin.H{
-				status.RespStatus: status.NewResponse(status.UserDisabled),
-			})
-			c.Abort()
-			return
-		}
-
-		if user.Status != models.AuditSuccess {
-			c.JSON(http.StatusForbidden, gin.H{
-				status.RespStatus: status.NewResponse(status.UserNoKYC),
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
+	return CheckPolicy(VerifiedUserPolicy)
 }
 
 func CheckAdminPermission(premissionCode uint) func(c *gin.Context) {
@@ -115,20 +83,14 @@ func CheckAdminPermission(premissionCode uint) func(c *gin.Context) {
 			return
 		}
 
-		admin, err := services.GetAdminById(uint(roleID))
-		if err != nil {
-			c.JSON(http.StatusForbidden, gin.H{
-				status.RespStatus: status.NewResponse(status.NotExistUser),
-			})
-			c.Abort()
-			return
-		}
-
-		if !checkAdminPermission(admin.Premissions, int32(premissionCode)) {
+		// 有效權限由角色與個別設定計算，並快取在 Redis，角色或權限變更時清除
+		permissions, err := services.GetEffectivePermissions(uint(roleID))
+		if err != nil || !checkAdminPermission(permissions, int32(premissionCode)) {
 			c.JSON(http.StatusForbidden, gin.H{
 				status.RespStatus: status.NewResponse(status.NotPermission),
 			})
 			c.Abort()
+			return
 		}
 
 		c.Next()
@@ -156,7 +118,7 @@ func CheckFolderPermission(premissionCode uint) func(c *gin.Context) {
 		}
 
 		if roleType == int(Admin) {
-			admin, err := services.GetAdminById(uint(roleID))
+			permissions, err := services.GetEffectivePermissions(uint(roleID))
 			if err != nil {
 				c.JSON(http.StatusForbidden, gin.H{
 					status.RespStatus: status.NewResponse(status.NotExistUser),
@@ -165,11 +127,12 @@ func CheckFolderPermission(premissionCode uint) func(c *gin.Context) {
 				return
 			}
 
-			if !checkAdminPermission(admin.Premissions, int32(premissionCode)) {
+			if !checkAdminPermission(permissions, int32(premissionCode)) {
 				c.JSON(http.StatusForbidden, gin.H{
 					status.RespStatus: status.NewResponse(status.NotPermission),
 				})
 				c.Abort()
+				return
 			}
 		}
 
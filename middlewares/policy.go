@@ -0,0 +1,99 @@
+package middlewares
+
+import (
+	"invar/models"
+	"invar/services"
+	"invar/status"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy 宣告路由允許的角色類型，以及使用者需要處於哪些狀態。
+// UserStatuses 為空時不檢查使用者狀態。
+type Policy struct {
+	RoleTypes    []int
+	UserStatuses []byte
+}
+
+var (
+	// ActiveUserPolicy 未停用的使用者，用於個人資料、實名認證與查詢類路由
+	ActiveUserPolicy = Policy{
+		RoleTypes:    []int{User},
+		UserStatuses: []byte{models.Registed, models.Auditing, models.AuditFailed, models.AuditSuccess},
+	}
+	// AdminPolicy 只允許管理者，用於所有後台路由
+	AdminPolicy = Policy{
+		RoleTypes: []int{Admin},
+	}
+	// VerifiedUserPolicy 通過實名認證的使用者，用於下單、提領與質押等資金相關路由
+	VerifiedUserPolicy = Policy{
+		RoleTypes:    []int{User},
+		UserStatuses: []byte{models.AuditSuccess},
+	}
+)
+
+// CheckPolicy 依照路由的 Policy 檢查角色類型與使用者狀態，需在 Auth 之後使用。
+func CheckPolicy(policy Policy) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		roleType := c.GetInt(ROLE_TYPE)
+		roleID := c.GetInt(ROLE_ID)
+
+		if roleID == 0 || !containsRoleType(policy.RoleTypes, roleType) {
+			c.JSON(http.StatusForbidden, gin.H{
+				status.RespStatus: status.NewResponse(status.NotPermission),
+			})
+			c.Abort()
+			return
+		}
+
+		if roleType != User || len(policy.UserStatuses) == 0 {
+			c.Next()
+			return
+		}
+
+		user, err := services.GetUserById(uint(roleID))
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				status.RespStatus: status.NewResponse(status.NotExistUser),
+			})
+			c.Abort()
+			return
+		}
+
+		if !containsUserStatus(policy.UserStatuses, user.Status) {
+			errCode := status.UserNoKYC
+			if user.Status == models.Disabled {
+				errCode = status.UserDisabled
+			}
+
+			c.JSON(http.StatusForbidden, gin.H{
+				status.RespStatus: status.NewResponse(errCode),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func containsRoleType(roleTypes []int, roleType int) bool {
+	for _, value := range roleTypes {
+		if value == roleType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsUserStatus(statuses []byte, userStatus byte) bool {
+	for _, value := range statuses {
+		if value == userStatus {
+			return true
+		}
+	}
+
+	return false
+}
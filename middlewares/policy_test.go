@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"invar/database"
+	"invar/models"
+	"invar/services"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func runPolicy(policy Policy, roleType, roleID int) int {
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.GET("/", func(c *gin.Context) {
+		c.Set(ROLE_TYPE, roleType)
+		c.Set(ROLE_ID, roleID)
+	}, CheckPolicy(policy), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	app.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder.Code
+}
+
+func TestCheckPolicyRoleType(t *testing.T) {
+	if code := runPolicy(ActiveUserPolicy, Admin, 1); code != http.StatusForbidden {
+		t.Fatal("admin passed a user policy, code=", code)
+	}
+
+	if code := runPolicy(AdminPolicy, User, 1); code != http.StatusForbidden {
+		t.Fatal("user passed the admin policy, code=", code)
+	}
+
+	if code := runPolicy(AdminPolicy, Admin, 1); code != http.StatusOK {
+		t.Fatal("admin was rejected by the admin policy, code=", code)
+	}
+}
+
+// 需要設定 TEST_DB_DSN 指向測試用的 Postgres，TEST_REDIS_ADDR 未設定時快取寫入會失敗但不影響結果
+func TestCheckPolicyRegisteredUser(t *testing.T) {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("Connect test database fail=", err)
+	}
+	database.DB = db
+	database.AutoMigrate()
+
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:1"
+	}
+	database.RDS = redis.NewClient(&redis.Options{Addr: addr})
+
+	user := models.User{
+		Email:    "policy-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@example.com",
+		UserName: "policy",
+	}
+	user.SetPassword("password")
+	err = services.RegisterUser(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runPolicy(ActiveUserPolicy, User, int(user.ID)); code != http.StatusOK {
+		t.Fatal("registered user was rejected by active policy, code=", code)
+	}
+
+	if code := runPolicy(VerifiedUserPolicy, User, int(user.ID)); code != http.StatusForbidden {
+		t.Fatal("registered user passed verified policy, code=", code)
+	}
+}
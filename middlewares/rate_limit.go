@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"context"
+	"invar/database"
+	"invar/status"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// RateLimitIdentity 決定請求以什麼身分計算次數
+type RateLimitIdentity func(c *gin.Context) string
+
+// gcraScript 以 GCRA 計算是否允許請求，TAT 為理論上下一個請求的到達時間。
+// 回傳 {是否允許, 需等待毫秒, 剩餘次數, 完全恢復毫秒}
+var gcraScript = redis.NewScript(`
+local now_parts = redis.call('TIME')
+local now = tonumber(now_parts[1]) * 1000 + math.floor(tonumber(now_parts[2]) / 1000)
+local emission = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local tolerance = emission * limit
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + emission
+local allow_at = new_tat - tolerance
+if allow_at > now then
+	return {0, allow_at - now, 0, tat - now}
+end
+
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil(new_tat - now))
+local remaining = math.floor((now - allow_at) / emission)
+return {1, 0, remaining, new_tat - now}
+`)
+
+// RateLimitByIP 以來源IP計算，用於尚未登入的路由
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitByRole 以登入的使用者或管理者計算，未登入時以來源IP計算
+func RateLimitByRole(c *gin.Context) string {
+	roleID := c.GetInt(ROLE_ID)
+	if roleID == 0 {
+		return RateLimitByIP(c)
+	}
+
+	return "role:" + strconv.Itoa(c.GetInt(ROLE_TYPE)) + ":" + strconv.Itoa(roleID)
+}
+
+// RateLimit 限制每個身分在 period 內最多 limit 次請求，允許一次用完。
+// 可用環境變數 RATE_LIMIT_<NAME> 覆寫，格式為 "次數/期間"，例如 "5/1h"。
+// Redis 無法使用時不阻擋請求。
+func RateLimit(name string, limit int, period time.Duration, identity RateLimitIdentity) gin.HandlerFunc {
+	limit, period = rateLimitConfig(name, limit, period)
+	emission := int64(math.Ceil(float64(period.Milliseconds()) / float64(limit)))
+
+	return func(c *gin.Context) {
+		var ctx = context.Background()
+		key := database.RateLimitCache + ":" + name + ":" + identity(c)
+
+		result, err := gcraScript.Run(ctx, database.RDS, []string{key}, emission, limit).Int64Slice()
+		if err != nil || len(result) != 4 {
+			logrus.Error("Rate limit fail=", err)
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter, remaining, reset := result[0] == 1, result[1], result[2], result[3]
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(millisecondsToSeconds(reset), 10))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.FormatInt(millisecondsToSeconds(retryAfter), 10))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				status.RespStatus: status.NewResponse(status.RequestTooFrequently),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func rateLimitConfig(name string, limit int, period time.Duration) (int, time.Duration) {
+	value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name))
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return limit, period
+	}
+
+	envLimit, err := strconv.Atoi(parts[0])
+	if err != nil || envLimit <= 0 {
+		return limit, period
+	}
+
+	envPeriod, err := time.ParseDuration(parts[1])
+	if err != nil || envPeriod <= 0 {
+		return limit, period
+	}
+
+	return envLimit, envPeriod
+}
+
+func millisecondsToSeconds(ms int64) int64 {
+	return int64(math.Ceil(float64(ms) / 1000))
+}
@@ -7,13 +7,25 @@ import (
 
 type Admin struct {
 	Model
-	Premissions pq.Int32Array `json:"premissions" gorm:"type:integer[]" swaggertype:"array,number"`
-	Account     string        `json:"account"`
-	Password    []byte        `json:"-"`
-	TFACode     []byte        `json:"-"`
-	TFAEnable   bool          `json:"-"`
+	Premissions          pq.Int32Array `json:"premissions" gorm:"type:integer[]" swaggertype:"array,number"`
+	RevokedPermissions   pq.Int32Array `json:"revoked_permissions" gorm:"type:integer[]" swaggertype:"array,number"`
+	Roles                []AdminRole   `json:"roles" gorm:"many2many:admin_role_bindings"`
+	EffectivePermissions []int32       `json:"effective_permissions,omitempty" gorm:"-"`
+	Account              string        `json:"account" gorm:"size:50;unique"`
+	Password             []byte        `json:"-"`
+	Status               byte          `json:"status" gorm:"default:1"`
+	SuperAdmin           bool          `json:"super_admin"`
+	MustResetPassword    bool          `json:"must_reset_password"`
+	TFACode              []byte        `json:"-"`
+	TFAEnable            bool          `json:"tfa_enable"`
+	TokenVersion         uint          `json:"-"`
 }
 
+const (
+	AdminActive = iota + 1
+	AdminDisabled
+)
+
 func (admin *Admin) SetPassword(password string) {
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), 12)
 	admin.Password = hashedPassword
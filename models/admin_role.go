@@ -0,0 +1,14 @@
+package models
+
+import (
+	pq "github.com/lib/pq"
+)
+
+// AdminRole 權限群組，管理者的有效權限為所屬角色的權限加上個別授予的權限，再扣除個別撤銷的權限
+type AdminRole struct {
+	Model
+	Name        string        `json:"name" gorm:"size:50;unique"`
+	Description string        `json:"description"`
+	Permissions pq.Int32Array `json:"permissions" gorm:"type:integer[]" swaggertype:"array,number"`
+	BuiltIn     bool          `json:"built_in"`
+}
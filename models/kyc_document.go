@@ -0,0 +1,31 @@
+package models
+
+// KYCDocument 加密保存的實名照片，資料金鑰以主金鑰加密後與檔案資訊一起保存
+type KYCDocument struct {
+	Model
+	UserID      uint   `json:"user_id" gorm:"index"`
+	Kind        string `json:"kind" gorm:"size:20"`
+	ContentType string `json:"content_type" gorm:"size:50"`
+	Size        int64  `json:"size"`
+	StorageKey  string `json:"-" gorm:"size:100;unique"`
+	WrappedKey  []byte `json:"-"`
+	MasterKeyID string `json:"-" gorm:"size:16"`
+}
+
+// KYCAccessLog 每次讀取實名照片的紀錄
+type KYCAccessLog struct {
+	Model
+	DocumentID uint   `json:"document_id" gorm:"index"`
+	OwnerID    uint   `json:"owner_id" gorm:"index"`
+	RoleType   uint   `json:"role_type"`
+	RoleID     uint   `json:"role_id"`
+	IP         string `json:"ip" gorm:"size:50"`
+	UserAgent  string `json:"user_agent"`
+}
+
+const (
+	KYCPhoto        = "photo"
+	KYCIDPhotoFront = "id_photo_front"
+	KYCIDPhotoBack  = "id_photo_back"
+	KYCPhotoWithID  = "photo_with_id"
+)
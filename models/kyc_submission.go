@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// KYCSubmission 使用者每次送審的實名資料，重新送審時保留舊版本
+type KYCSubmission struct {
+	Model
+	UserID         uint          `json:"user_id" gorm:"uniqueIndex:idx_kyc_submission_version"`
+	User           *User         `json:"user,omitempty"`
+	Version        uint          `json:"version" gorm:"uniqueIndex:idx_kyc_submission_version"`
+	Status         byte          `json:"status" gorm:"index"`
+	Name           string        `json:"name"`
+	IdentityCard   string        `json:"identity_card"`
+	PhotoID        uint          `json:"photo_id"`
+	IDPhotoFrontID uint          `json:"id_photo_front_id"`
+	IDPhotoBackID  uint          `json:"id_photo_back_id"`
+	PhotoWithIDID  uint          `json:"photo_with_id_id"`
+	ReviewerID     uint          `json:"reviewer_id" gorm:"index"`
+	ClaimedAt      *time.Time    `json:"claimed_at"`
+	ReviewedAt     *time.Time    `json:"reviewed_at"`
+	RejectReasons  pq.Int32Array `json:"reject_reasons" gorm:"type:integer[]" swaggertype:"array,number"`
+	RejectComment  string        `json:"reject_comment"`
+}
+
+const (
+	KYCPending = iota + 1
+	KYCApproved
+	KYCRejected
+	// KYCSuperseded 審核前使用者已重新送審
+	KYCSuperseded
+)
+
+const (
+	KYCRejectBlurryPhoto = iota + 1
+	KYCRejectNameMismatch
+	KYCRejectIdentityCardMismatch
+	KYCRejectDocumentExpired
+	KYCRejectFaceMismatch
+	KYCRejectOther
+)
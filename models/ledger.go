@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// LedgerAccount 每個使用者每種資產一個帳戶，UserID 為 0 時為系統帳戶。
+// Balance 為借方減貸方的快取值，真實數據以 Posting 為準。
+type LedgerAccount struct {
+	Model
+	UserID  uint            `json:"user_id" gorm:"uniqueIndex:idx_ledger_account"`
+	Name    string          `json:"name" gorm:"size:50;uniqueIndex:idx_ledger_account"`
+	Asset   string          `json:"asset" gorm:"size:50;uniqueIndex:idx_ledger_account"`
+	Balance decimal.Decimal `json:"balance" gorm:"type:numeric"`
+}
+
+type JournalEntry struct {
+	Model
+	Type        byte      `json:"type"`
+	ReferenceID uint      `json:"reference_id" gorm:"index"`
+	Comment     string    `json:"comment"`
+	Postings    []Posting `json:"postings"`
+}
+
+type Posting struct {
+	Model
+	JournalEntryID uint            `json:"journal_entry_id" gorm:"index"`
+	AccountID      uint            `json:"account_id" gorm:"index"`
+	Asset          string          `json:"asset" gorm:"size:50"`
+	Debit          decimal.Decimal `json:"debit" gorm:"type:numeric"`
+	Credit         decimal.Decimal `json:"credit" gorm:"type:numeric"`
+	BalanceAfter   decimal.Decimal `json:"balance_after" gorm:"type:numeric"`
+}
+
+var ErrImmutableLedger = errors.New("ledger records are immutable")
+
+func (entry *JournalEntry) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableLedger
+}
+
+func (entry *JournalEntry) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableLedger
+}
+
+func (posting *Posting) BeforeUpdate(tx *gorm.DB) error {
+	return ErrImmutableLedger
+}
+
+func (posting *Posting) BeforeDelete(tx *gorm.DB) error {
+	return ErrImmutableLedger
+}
+
+const (
+	AssetIVT  = "IVT"
+	AssetUSDT = "USDT"
+)
+
+func TokenAsset(productID uint) string {
+	return fmt.Sprintf("TOKEN-%d", productID)
+}
+
+// Ledger account names
+const (
+	LedgerWallet         = "wallet"
+	LedgerOpeningBalance = "opening_balance"
+	LedgerStackProfit    = "stack_profit"
+	LedgerWithdrawalHold = "withdrawal_hold"
+	LedgerWithdrawal     = "withdrawal"
+	LedgerWithdrawalFee  = "withdrawal_fee"
+	LedgerTokenIssuance  = "token_issuance"
+	LedgerTokenExchange  = "token_exchange"
+	LedgerReferral       = "referral_commission"
+)
+
+// Journal entry types
+const (
+	JournalOpeningBalance = iota + 1
+	JournalStackProfit
+	JournalStackProfitRevert
+	JournalWithdrawalHold
+	JournalWithdrawalRelease
+	JournalWithdrawalSettle
+	JournalTokenIssue
+	JournalTokenRevoke
+	JournalTokenExchange
+	JournalReferralCommission
+	JournalReferralCommissionRevert
+)
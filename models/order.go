@@ -8,15 +8,16 @@ import (
 
 type Order struct {
 	Model
-	UserID           uint            `json:"user_id"`
-	Serial           string          `json:"serial"`
-	OrderItems       []OrderItem     `json:"order_items"`
-	Status           byte            `json:"status"`
-	TotalAmount      decimal.Decimal `json:"total_amount" gorm:"type:numeric"`
-	TransactionChain string          `json:"transaction_chain"`
-	TransactionID    string          `json:"transaction_id"`
-	PaymentLimitTime time.Time       `json:"payment_limit_time"`
-	Comment          string          `json:"comment"`
+	UserID           uint                 `json:"user_id"`
+	Serial           string               `json:"serial"`
+	OrderItems       []OrderItem          `json:"order_items"`
+	Status           byte                 `json:"status"`
+	TotalAmount      decimal.Decimal      `json:"total_amount" gorm:"type:numeric"`
+	TransactionChain string               `json:"transaction_chain" gorm:"uniqueIndex:idx_order_transaction,where:transaction_id <> ''"`
+	TransactionID    string               `json:"transaction_id" gorm:"uniqueIndex:idx_order_transaction,where:transaction_id <> ''"`
+	PaymentLimitTime time.Time            `json:"payment_limit_time"`
+	Comment          string               `json:"comment"`
+	StatusHistories  []OrderStatusHistory `json:"status_histories"`
 }
 
 const (
@@ -25,4 +26,5 @@ const (
 	WaitConfirmPayment
 	PaymentFailed
 	Completed
+	Refunded
 )
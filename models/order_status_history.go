@@ -0,0 +1,12 @@
+package models
+
+type OrderStatusHistory struct {
+	Model
+	OrderID       uint   `json:"order_id" gorm:"index"`
+	FromStatus    byte   `json:"from_status"`
+	ToStatus      byte   `json:"to_status"`
+	ActorRoleType uint   `json:"actor_role_type"`
+	ActorRoleID   uint   `json:"actor_role_id"`
+	IP            string `json:"ip"`
+	Reason        string `json:"reason"`
+}
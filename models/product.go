@@ -18,4 +18,7 @@ type Product struct {
 	ContractType    string          `json:"contract_type" form:"contract_type"`
 	ContractAddress string          `json:"contract_address" form:"contract_address"`
 	BuyPermissions  pq.Int32Array   `json:"buy_permissions" form:"buy_permissions" gorm:"type:integer[]" swaggertype:"array,number"`
+	// ImageURL 與 PreviewImageURL 為圖片的限時下載網址，回應前才產生
+	ImageURL        string `json:"image_url" form:"-" gorm:"-"`
+	PreviewImageURL string `json:"preview_image_url" form:"-" gorm:"-"`
 }
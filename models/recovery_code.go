@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RecoveryCode 二階段驗證的備用救援碼，只儲存雜湊值且只能使用一次。
+type RecoveryCode struct {
+	Model
+	RoleType uint       `json:"role_type" gorm:"index:idx_recovery_code_role"`
+	RoleID   uint       `json:"role_id" gorm:"index:idx_recovery_code_role"`
+	CodeHash string     `json:"-" gorm:"size:64"`
+	UsedAt   *time.Time `json:"used_at"`
+}
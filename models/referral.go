@@ -0,0 +1,27 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// CommissionRule 推薦獎勵規則，Level 1 為直接推薦人，2 為推薦人的推薦人，依此類推。
+// ProductID 為 0 時套用於所有商品，同一層級有指定商品的規則優先。
+type CommissionRule struct {
+	Model
+	Level     uint            `json:"level"`
+	ProductID uint            `json:"product_id"`
+	Rate      decimal.Decimal `json:"rate" gorm:"type:numeric"`
+	Asset     string          `json:"asset" gorm:"size:50"`
+	Status    bool            `json:"status"`
+}
+
+// ReferralCommission 推薦獎勵的發放紀錄，同一張訂單對同一個推薦人的同一層級只會發放一次。
+type ReferralCommission struct {
+	Model
+	OrderID    uint            `json:"order_id" gorm:"uniqueIndex:idx_referral_commission"`
+	RefereeID  uint            `json:"referee_id" gorm:"index"`
+	ReferrerID uint            `json:"referrer_id" gorm:"uniqueIndex:idx_referral_commission;index"`
+	Level      uint            `json:"level" gorm:"uniqueIndex:idx_referral_commission"`
+	RuleID     uint            `json:"rule_id"`
+	Asset      string          `json:"asset" gorm:"size:50"`
+	Amount     decimal.Decimal `json:"amount" gorm:"type:numeric"`
+	Reverted   bool            `json:"reverted"`
+}
@@ -4,15 +4,24 @@ import (
 	"time"
 )
 
+// RefreshToken 只儲存令牌的雜湊值，Token 只在簽發當下回傳給使用者。
+// 每次更換令牌都會產生同一個 FamilyID 的新令牌，一個 Family 即為一個登入工作階段。
 type RefreshToken struct {
 	Model
-	RoleType    uint      `json:"role_type"`
-	RoleID      uint      `json:"role_id"`
-	Token       string    `json:"token"`
-	Expires     time.Time `json:"expires"`
-	Revoked     time.Time `json:"revoked"`
-	CreatedByIP string    `json:"created_by_ip"`
-	RevokedByIP string    `json:"revoked_by_ip"`
+	RoleType         uint      `json:"role_type" gorm:"index:idx_refresh_token_role"`
+	RoleID           uint      `json:"role_id" gorm:"index:idx_refresh_token_role"`
+	Token            string    `json:"-" gorm:"-"`
+	TokenHash        string    `json:"-" gorm:"size:64;uniqueIndex"`
+	FamilyID         uint      `json:"family_id" gorm:"index"`
+	ReplacedByID     uint      `json:"replaced_by_id"`
+	Expires          time.Time `json:"expires"`
+	Revoked          time.Time `json:"revoked"`
+	SessionCreatedAt time.Time `json:"session_created_at"`
+	CreatedByIP      string    `json:"created_by_ip"`
+	UserAgent        string    `json:"user_agent"`
+	LastUsedAt       time.Time `json:"last_used_at"`
+	LastUsedIP       string    `json:"last_used_ip"`
+	RevokedByIP      string    `json:"revoked_by_ip"`
 }
 
 func (refreshToken *RefreshToken) IsRevoked() bool {
@@ -12,4 +12,5 @@ type Stack struct {
 	ContractTimeBound   uint            `json:"contract_time_bound"`
 	ProfitIntervalMonth uint            `json:"profit_interval_month"`
 	StackPermissions    pq.Int32Array   `json:"stack_permissions" gorm:"type:integer[]" swaggertype:"array,number"`
+	ExchangeRate        decimal.Decimal `json:"exchange_rate" gorm:"type:numeric"`
 }
@@ -1,12 +1,16 @@
 package models
 
 import (
+	"time"
+
 	"github.com/shopspring/decimal"
 )
 
+// StackProfitRecord Period 為自動配息對應的配息時間，同一筆質押的同一期只能有一筆，管理者手動新增的配息為 nil。
 type StackProfitRecord struct {
 	Model
-	StackRecordID uint            `json:"stack_record_id"`
+	StackRecordID uint            `json:"stack_record_id" gorm:"uniqueIndex:idx_stack_profit_period"`
+	Period        *time.Time      `json:"period" gorm:"uniqueIndex:idx_stack_profit_period"`
 	Profit        decimal.Decimal `json:"profit" gorm:"type:numeric"`
 	Comment       string          `json:"comment"`
 }
@@ -6,11 +6,14 @@ import (
 
 type StackRecord struct {
 	Model
-	Serial             string              `json:"serial"`
-	Status             byte                `json:"status"`
-	UserID             uint                `json:"user_id"`
-	StackID            uint                `json:"stack_id"`
-	Quantity           uint                `json:"quantity"`
+	Serial    string `json:"serial"`
+	Status    byte   `json:"status"`
+	UserID    uint   `json:"user_id"`
+	StackID   uint   `json:"stack_id"`
+	Quantity  uint   `json:"quantity"`
+	AutoRenew bool   `json:"auto_renew"`
+	// ProfitStartTime 配息與合約到期時間都從此時間起算，避免月底日期逐期偏移
+	ProfitStartTime    time.Time           `json:"profit_start_time"`
 	NextGetProfitTime  time.Time           `json:"next_get_profit_time"`
 	EndGetProfitTime   time.Time           `json:"end_get_profit_time"`
 	StackProfitRecords []StackProfitRecord `json:"stack_profit_records"`
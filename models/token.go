@@ -11,6 +11,7 @@ type Token struct {
 	ContractType    string          `json:"contract_type"`
 	ContractAddress string          `json:"contract_address"`
 	OwnID           uint            `json:"own_id"`
+	StackRecordID   uint            `json:"stack_record_id" gorm:"index"`
 	Quantity        decimal.Decimal `json:"quantity" gorm:"type:numeric"`
 }
 
@@ -18,4 +19,5 @@ const (
 	Unlock = iota
 	Stacking
 	StackExpired
+	Exchanged
 )
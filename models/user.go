@@ -12,11 +12,13 @@ type User struct {
 	UserName     string        `json:"username" gorm:"not null;size:50"`
 	Password     []byte        `json:"-"`
 	Referrer     string        `json:"referrer" gorm:"size:50"`
+	ReferrerID   uint          `json:"referrer_id" gorm:"index"`
 	ReferrerCode string        `json:"referrer_code" gorm:"size:50;unique"`
 	Comment      string        `json:"commet"`
 	UserKYC      UserKYC       `json:"user_kyc"`
 	TFACode      []byte        `json:"-"`
 	TFAEnable    bool          `json:"-"`
+	TokenVersion uint          `json:"-"`
 	Orders       []Order       `json:"-"`
 	WhiteLists   []WhiteList   `json:"-"`
 	StackRecords []StackRecord `json:"-"`
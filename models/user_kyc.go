@@ -2,11 +2,16 @@ package models
 
 type UserKYC struct {
 	Model
-	UserID       uint   `json:"user_id"`
-	Name         string `json:"name"`
-	IdentityCard string `json:"identity_card"`
-	Photo        string `json:"photo"`
-	IDPhotoFront string `json:"id_photo_front"`
-	IDPhotoBack  string `json:"id_photo_back"`
-	PhotoWithID  string `json:"photo_with_id"`
+	UserID         uint   `json:"user_id"`
+	Name           string `json:"name"`
+	IdentityCard   string `json:"identity_card"`
+	PhotoID        uint   `json:"photo_id"`
+	IDPhotoFrontID uint   `json:"id_photo_front_id"`
+	IDPhotoBackID  uint   `json:"id_photo_back_id"`
+	PhotoWithIDID  uint   `json:"photo_with_id_id"`
+	// 舊版以明文保存在 static 底下的路徑，啟動時搬移到加密儲存後清空
+	Photo        string `json:"-"`
+	IDPhotoFront string `json:"-"`
+	IDPhotoBack  string `json:"-"`
+	PhotoWithID  string `json:"-"`
 }
@@ -0,0 +1,11 @@
+package models
+
+// UserStatusLog 管理者停用或啟用使用者的紀錄，啟用時依此還原停用前的狀態
+type UserStatusLog struct {
+	Model
+	UserID     uint   `json:"user_id" gorm:"index"`
+	AdminID    uint   `json:"admin_id"`
+	FromStatus byte   `json:"from_status"`
+	ToStatus   byte   `json:"to_status"`
+	Reason     string `json:"reason"`
+}
@@ -4,8 +4,24 @@ import "github.com/shopspring/decimal"
 
 type Withdrawal struct {
 	Model
-	UserID   uint            `json:"-"`
-	Quantity decimal.Decimal `json:"quantity" gorm:"type:numeric"`
-	Fee      decimal.Decimal `json:"fee" gorm:"type:numeric"`
-	Status   uint            `json:"status"`
+	UserID        uint            `json:"user_id"`
+	WhiteListID   uint            `json:"whitelist_id"`
+	Asset         string          `json:"asset" gorm:"size:50"`
+	Quantity      decimal.Decimal `json:"quantity" gorm:"type:numeric"`
+	Fee           decimal.Decimal `json:"fee" gorm:"type:numeric"`
+	Status        uint            `json:"status"`
+	ChainName     string          `json:"chain_name"`
+	Address       string          `json:"address"`
+	TransactionID string          `json:"transaction_id"`
+	ReviewerID    uint            `json:"reviewer_id"`
+	Comment       string          `json:"comment"`
 }
+
+const (
+	WithdrawalRequested = iota + 1
+	WithdrawalHeld
+	WithdrawalApproved
+	WithdrawalBroadcast
+	WithdrawalCompleted
+	WithdrawalRejected
+)
@@ -15,10 +15,76 @@ const (
 	ModifyStack
 	DeleteStack
 	QueryBank
+	QueryWithdrawal
+	ModifyWithdrawal
+	QueryReferral
+	ModifyReferral
+	QueryKYC
+	ModifyKYC
 )
 
+type Permission struct {
+	Code        int32  `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Role 預設的權限群組，啟動時建立到 admin_roles
+type Role struct {
+	Name        string
+	Description string
+	Permissions []int32
+}
+
+var permissions = []Permission{
+	{QueryAdmin, "QueryAdmin", "查詢管理者、角色與令牌金鑰"},
+	{ModifyAdmin, "ModifyAdmin", "新增、修改、停用管理者與角色"},
+	{QueryUser, "QueryUser", "查詢使用者"},
+	{ModifyUser, "ModifyUser", "修改、停用與登出使用者"},
+	{QueryWhiteList, "QueryWhiteList", "查詢白名單"},
+	{ModifyWhiteList, "ModifyWhiteList", "修改白名單"},
+	{QueryProduct, "QueryProduct", "查詢商品"},
+	{ModifyProduct, "ModifyProduct", "新增與修改商品"},
+	{QueryOrder, "QueryOrder", "查詢訂單"},
+	{ModifyOrder, "ModifyOrder", "取消、完成與退款訂單"},
+	{QueryStack, "QueryStack", "查詢質押方案與質押紀錄"},
+	{ModifyStack, "ModifyStack", "新增與修改質押方案與收益"},
+	{DeleteStack, "DeleteStack", "刪除質押收益紀錄"},
+	{QueryBank, "QueryBank", "查詢錢包與帳本"},
+	{QueryWithdrawal, "QueryWithdrawal", "查詢提領"},
+	{ModifyWithdrawal, "ModifyWithdrawal", "審核與完成提領"},
+	{QueryReferral, "QueryReferral", "查詢推薦關係與佣金規則"},
+	{ModifyReferral, "ModifyReferral", "修改佣金規則"},
+	{QueryKYC, "QueryKYC", "查詢實名資料與審核佇列"},
+	{ModifyKYC, "ModifyKYC", "認領、指派與審核實名資料"},
+}
+
+func GetPermissions() []Permission {
+	return permissions
+}
+
 func GetDefaultAdminPermission() []int32 {
-	return []int32{QueryAdmin, ModifyAdmin, QueryUser, ModifyUser,
-		QueryWhiteList, ModifyWhiteList, QueryProduct, ModifyProduct,
-		QueryOrder, ModifyOrder, QueryStack, ModifyStack, DeleteStack, QueryBank}
+	codes := make([]int32, 0, len(permissions))
+	for _, p := range permissions {
+		codes = append(codes, p.Code)
+	}
+	return codes
+}
+
+func GetDefaultRoles() []Role {
+	return []Role{
+		{"super_admin", "超級管理者，擁有所有權限", GetDefaultAdminPermission()},
+		{"support", "客服，查詢使用者、訂單與質押", []int32{QueryUser, QueryKYC, QueryWhiteList, QueryProduct,
+			QueryOrder, QueryStack, QueryBank, QueryReferral}},
+		{"finance", "財務，處理訂單、提領與佣金", []int32{QueryUser, QueryOrder, ModifyOrder,
+			QueryStack, QueryBank, QueryWithdrawal, ModifyWithdrawal, QueryReferral, ModifyReferral}},
+		{"compliance", "法遵，審核實名與白名單", []int32{QueryUser, ModifyUser, QueryKYC, ModifyKYC,
+			QueryWhiteList, ModifyWhiteList, QueryWithdrawal}},
+		{"operator", "營運，管理商品與質押方案", []int32{QueryProduct, ModifyProduct,
+			QueryStack, ModifyStack, DeleteStack}},
+	}
+}
+
+func IsValidPermission(code int32) bool {
+	return code >= QueryAdmin && code <= ModifyKYC
 }
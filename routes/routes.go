@@ -4,6 +4,7 @@ import (
 	"invar/controllers"
 	"invar/middlewares"
 	"invar/permission"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,48 +16,111 @@ func Setup(rg *gin.RouterGroup) {
 	public := rg.Group("public", middlewares.Auth(), middlewares.LoggerToFile())
 	public.Static("/", "./public")
 
+	// 下載網址由瀏覽器直接開啟，請求不帶 Content-Type，不能套用 RequestSizeLimit
+	file := rg.Group("/api/v1", middlewares.LoggerToFile())
+	file.GET("file/*key", controllers.GetFile)
+
 	api := rg.Group("/api", middlewares.LoggerToFile(), middlewares.RequestSizeLimit())
 	v1 := api.Group("/v1")
-	v1.POST("register", controllers.RegisterUser)
-	v1.POST("get_email_code", controllers.GetEmailCode)
+	v1.POST("register", middlewares.RateLimit("register", 5, time.Hour, middlewares.RateLimitByIP), controllers.RegisterUser)
+	v1.POST("get_email_code", middlewares.RateLimit("email_code", 5, 10*time.Minute, middlewares.RateLimitByIP), controllers.GetEmailCode)
 	v1.POST("auth", controllers.Authenticate)
 	v1.POST("admin_auth", controllers.AuthenticateAdmin)
+	v1.POST("admin_reset_password", controllers.ResetRequiredAdminPassword)
 	v1.POST("refresh_token", controllers.RefreshToken)
 	v1.POST("revoke_token", controllers.RevokeToken)
-	v1.POST("forget_password", controllers.ForgetPassword)
+	v1.GET("unlock_account", controllers.UnlockAccount)
+	v1.POST("forget_password", middlewares.RateLimit("forget_password", 5, time.Hour, middlewares.RateLimitByIP), controllers.ForgetPassword)
 	v1.GET("forget_password_access", controllers.ForgetPasswordAccess)
 	v1.POST("reset_password_with_token", controllers.ResetPasswordWithToken)
 
-	v1WithAuth := v1.Use(middlewares.Auth())
+	v1WithAuth := v1.Group("", middlewares.Auth(), middlewares.CheckPolicy(middlewares.ActiveUserPolicy))
+	v1WithKYC := v1.Group("", middlewares.Auth(), middlewares.CheckUserPremission())
 
 	v1WithAuth.POST("change_password", controllers.ChangeUserPassword)
+	v1WithAuth.GET("session", controllers.GetSessions)
+	v1WithAuth.DELETE("session/:id", controllers.RevokeSession)
+	v1WithAuth.POST("logout_all", controllers.RevokeAllSessions)
+
+	v1WithAuth.GET("tfa", controllers.GetUserTFA)
+	v1WithAuth.POST("enable_tfa", controllers.EnableUserTFA)
+	v1WithAuth.POST("disable_tfa", controllers.DisableUserTFA)
+	v1WithAuth.POST("recovery_code", controllers.RegenerateUserRecoveryCodes)
 
 	v1WithAuth.GET("kyc", controllers.GetKYC)
 	v1WithAuth.POST("kyc", controllers.AddKYC)
 	v1WithAuth.PATCH("kyc", controllers.UpdateKYC)
+	v1WithAuth.GET("kyc_submission", controllers.GetKYCSubmissions)
+	v1WithAuth.GET("kyc_document/:id", controllers.GetKYCDocument)
 
 	v1WithAuth.GET("whitelist", controllers.GetWhiteLists)
-	v1WithAuth.POST("whitelist", controllers.AddWhiteList)
-	v1WithAuth.PATCH("whitelist/:id", controllers.UpdateWhiteList)
-	v1WithAuth.DELETE("whitelist/:id", controllers.DeleteWhiteList)
+	v1WithKYC.POST("whitelist", controllers.AddWhiteList)
+	v1WithKYC.PATCH("whitelist/:id", controllers.UpdateWhiteList)
+	v1WithKYC.DELETE("whitelist/:id", controllers.DeleteWhiteList)
 
 	v1WithAuth.GET("product", controllers.GetProducts)
 	v1WithAuth.GET("product/:id", controllers.GetProduct)
 
 	v1WithAuth.GET("order", controllers.GetOrders)
-	v1WithAuth.POST("order", controllers.AddOrder)
+	v1WithAuth.GET("order/:id", controllers.GetOrder)
+	v1WithKYC.POST("order", middlewares.RateLimit("order", 10, time.Minute, middlewares.RateLimitByRole), controllers.AddOrder)
 	v1WithAuth.PATCH("cancel_order/:id", controllers.CancelOrder)
-	v1WithAuth.PATCH("payment_order/:id", controllers.PaymentOrder)
+	v1WithKYC.PATCH("payment_order/:id", controllers.PaymentOrder)
 
 	v1WithAuth.GET("bank/:id", controllers.GetBank)
 
+	v1WithAuth.GET("withdrawal", controllers.GetWithdrawals)
+	v1WithKYC.POST("withdrawal", controllers.AddWithdrawal)
+	v1WithKYC.PATCH("confirm_withdrawal/:id", controllers.ConfirmWithdrawal)
+
+	v1WithAuth.GET("referee", controllers.GetReferees)
+	v1WithAuth.GET("referral_commission", controllers.GetReferralCommissions)
+
 	v1WithAuth.GET("stack", controllers.GetStacks)
 	v1WithAuth.GET("stack/:id", controllers.GetStack)
 	v1WithAuth.GET("stack_record", controllers.GetStacksRecord)
+	v1WithAuth.GET("stack_record/:id", controllers.GetStackRecord)
+	v1WithKYC.POST("stack_record", controllers.AddStackRecord)
+	v1WithKYC.PATCH("unlock_stack_record/:id", controllers.UnlockStackRecord)
+	v1WithKYC.PATCH("auto_renew_stack_record/:id", controllers.AutoRenewStackRecord)
+	v1WithKYC.PATCH("exchange_stack_record/:id", controllers.ExchangeStack)
 
-	admin := v1.Group("/admin", middlewares.Auth())
+	admin := v1.Group("/admin", middlewares.Auth(), middlewares.CheckPolicy(middlewares.AdminPolicy))
 
 	admin.POST("change_password", controllers.ChangeAdminPassword)
+	admin.GET("session", controllers.GetSessions)
+	admin.DELETE("session/:id", controllers.RevokeSession)
+	admin.POST("logout_all", controllers.RevokeAllSessions)
+	admin.GET("tfa", controllers.GetAdminTFA)
+	admin.POST("enable_tfa", controllers.EnableAdminTFA)
+	admin.POST("disable_tfa", controllers.DisableAdminTFA)
+	admin.POST("recovery_code", controllers.RegenerateAdminRecoveryCodes)
+	admin.GET("user_session/:id", middlewares.CheckAdminPermission(permission.QueryUser), controllers.GetUserSessions)
+	admin.POST("logout_user/:id", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.RevokeUserSessions)
+	admin.GET("login_lockout", middlewares.CheckAdminPermission(permission.QueryUser), controllers.GetLoginLockouts)
+	admin.DELETE("login_lockout", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.ClearLoginLockout)
+	admin.PATCH("disable_user_tfa/:id", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.DisableUserTFAByAdmin)
+	admin.GET("token_key", middlewares.CheckAdminPermission(permission.QueryAdmin), controllers.GetTokenKeys)
+	admin.POST("rotate_token_key", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.RotateTokenKey)
+	admin.GET("admin_account", middlewares.CheckAdminPermission(permission.QueryAdmin), controllers.GetAdmins)
+	admin.GET("admin_account/:id", middlewares.CheckAdminPermission(permission.QueryAdmin), controllers.GetAdmin)
+	admin.POST("admin_account", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.AddAdmin)
+	admin.DELETE("admin_account/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.DeleteAdmin)
+	admin.PATCH("admin_permission/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.UpdateAdminPermission)
+	admin.PATCH("disable_admin/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.DisableAdmin)
+	admin.PATCH("enable_admin/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.EnableAdmin)
+	admin.PATCH("reset_admin_password/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.ResetAdminPassword)
+	admin.GET("permission", middlewares.CheckAdminPermission(permission.QueryAdmin), controllers.GetPermissions)
+	admin.GET("admin_role", middlewares.CheckAdminPermission(permission.QueryAdmin), controllers.GetAdminRoles)
+	admin.POST("admin_role", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.AddAdminRole)
+	admin.PATCH("admin_role/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.UpdateAdminRole)
+	admin.DELETE("admin_role/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.DeleteAdminRole)
+	admin.PATCH("admin_role_binding/:id", middlewares.CheckAdminPermission(permission.ModifyAdmin), controllers.SetAdminRoles)
+	admin.GET("user", middlewares.CheckAdminPermission(permission.QueryUser), controllers.SearchUsers)
+	admin.GET("user/:id", middlewares.CheckAdminPermission(permission.QueryUser), controllers.GetUserDetail)
+	admin.PATCH("disable_user/:id", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.DisableUser)
+	admin.PATCH("enable_user/:id", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.EnableUser)
+	admin.PATCH("user_comment/:id", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.UpdateUserComment)
 	admin.POST("change_password_by_admin", middlewares.CheckAdminPermission(permission.ModifyUser), controllers.ChangeUserPasswordByAdmin)
 
 	admin.GET("whitelist/:id", middlewares.CheckAdminPermission(permission.QueryWhiteList), controllers.GetWhiteListsByAdmin)
@@ -64,11 +128,28 @@ func Setup(rg *gin.RouterGroup) {
 	admin.PATCH("whitelist/:id", middlewares.CheckAdminPermission(permission.ModifyWhiteList), controllers.UpdateWhiteList)
 	admin.DELETE("whitelist/:id", middlewares.CheckAdminPermission(permission.ModifyWhiteList), controllers.DeleteWhiteList)
 
-	admin.GET("kyc/:id", middlewares.CheckAdminPermission(permission.QueryWhiteList), controllers.GetWhiteListsByAdmin)
-	admin.PATCH("kyc/:id", middlewares.CheckAdminPermission(permission.ModifyWhiteList), controllers.UpdateKYCByAdmin)
+	admin.GET("kyc/:id", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCByAdmin)
+	admin.PATCH("kyc/:id", middlewares.CheckAdminPermission(permission.ModifyKYC), controllers.UpdateKYCByAdmin)
+	admin.GET("kyc_submission/:id", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCSubmissionsByAdmin)
+	admin.GET("kyc_document/:id", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCDocumentByAdmin)
+	admin.GET("kyc_access_log/:id", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCAccessLogs)
+	admin.GET("kyc_queue", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCQueue)
+	admin.GET("kyc_reject_reason", middlewares.CheckAdminPermission(permission.QueryKYC), controllers.GetKYCRejectReasons)
+	admin.PATCH("claim_kyc/:id", middlewares.CheckAdminPermission(permission.ModifyKYC), controllers.ClaimKYC)
+	admin.PATCH("assign_kyc/:id", middlewares.CheckAdminPermission(permission.ModifyKYC), controllers.AssignKYC)
+	admin.PATCH("approve_kyc/:id", middlewares.CheckAdminPermission(permission.ModifyKYC), controllers.ApproveKYC)
+	admin.PATCH("reject_kyc/:id", middlewares.CheckAdminPermission(permission.ModifyKYC), controllers.RejectKYC)
 
 	admin.GET("bank", middlewares.CheckAdminPermission(permission.QueryBank), controllers.GetBanks)
 	admin.GET("bank/:id", middlewares.CheckAdminPermission(permission.QueryBank), controllers.GetBank)
+	admin.GET("ledger/:id", middlewares.CheckAdminPermission(permission.QueryBank), controllers.GetLedger)
+	admin.GET("ledger/:id/reconcile", middlewares.CheckAdminPermission(permission.QueryBank), controllers.ReconcileLedger)
+
+	admin.GET("withdrawal", middlewares.CheckAdminPermission(permission.QueryWithdrawal), controllers.GetWithdrawalsByAdmin)
+	admin.PATCH("approve_withdrawal/:id", middlewares.CheckAdminPermission(permission.ModifyWithdrawal), controllers.ApproveWithdrawal)
+	admin.PATCH("reject_withdrawal/:id", middlewares.CheckAdminPermission(permission.ModifyWithdrawal), controllers.RejectWithdrawal)
+	admin.PATCH("broadcast_withdrawal/:id", middlewares.CheckAdminPermission(permission.ModifyWithdrawal), controllers.BroadcastWithdrawal)
+	admin.PATCH("completed_withdrawal/:id", middlewares.CheckAdminPermission(permission.ModifyWithdrawal), controllers.CompletedWithdrawal)
 
 	admin.GET("product", middlewares.CheckAdminPermission(permission.QueryProduct), controllers.GetProductsByAdmin)
 	admin.GET("product/:id", middlewares.CheckAdminPermission(permission.QueryProduct), controllers.GetProduct)
@@ -76,14 +157,24 @@ func Setup(rg *gin.RouterGroup) {
 	admin.PATCH("product/:id", middlewares.CheckAdminPermission(permission.ModifyProduct), controllers.UpdateProduct)
 
 	admin.GET("order", middlewares.CheckAdminPermission(permission.QueryOrder), controllers.GetOrdersByAdmin)
+	admin.GET("order/:id", middlewares.CheckAdminPermission(permission.QueryOrder), controllers.GetOrder)
 	admin.PATCH("cancel_order/:id", middlewares.CheckAdminPermission(permission.ModifyOrder), controllers.CancelOrder)
 	admin.PATCH("completed_order/:id", middlewares.CheckAdminPermission(permission.ModifyOrder), controllers.CompletedOrder)
+	admin.PATCH("refund_order/:id", middlewares.CheckAdminPermission(permission.ModifyOrder), controllers.RefundOrder)
 
 	admin.GET("stack", middlewares.CheckAdminPermission(permission.QueryStack), controllers.GetStacks)
 	admin.GET("stack/:id", middlewares.CheckAdminPermission(permission.QueryStack), controllers.GetStack)
-	admin.POST("stack", middlewares.CheckAdminPermission(permission.QueryStack), controllers.AddStack)
-	admin.PATCH("stack/:id", middlewares.CheckAdminPermission(permission.QueryStack), controllers.UpdateStack)
+	admin.POST("stack", middlewares.CheckAdminPermission(permission.ModifyStack), controllers.AddStack)
+	admin.PATCH("stack/:id", middlewares.CheckAdminPermission(permission.ModifyStack), controllers.UpdateStack)
 	admin.GET("stack_record", middlewares.CheckAdminPermission(permission.QueryStack), controllers.GetStacksRecordByAdmin)
-	admin.POST("stack_profit_record", middlewares.CheckAdminPermission(permission.QueryStack), controllers.AddStackProfitRecord)
-	admin.DELETE("stack_profit_record", middlewares.CheckAdminPermission(permission.QueryStack), controllers.DeleteStackProfitRecord)
+	admin.GET("stack_record/:id", middlewares.CheckAdminPermission(permission.QueryStack), controllers.GetStackRecord)
+	admin.GET("stack_profit_preview", middlewares.CheckAdminPermission(permission.QueryStack), controllers.GetStackProfitPreview)
+	admin.POST("stack_profit_record", middlewares.CheckAdminPermission(permission.ModifyStack), controllers.AddStackProfitRecord)
+	admin.DELETE("stack_profit_record", middlewares.CheckAdminPermission(permission.DeleteStack), controllers.DeleteStackProfitRecord)
+
+	admin.GET("referral_tree/:id", middlewares.CheckAdminPermission(permission.QueryReferral), controllers.GetReferralTree)
+	admin.GET("commission_rule", middlewares.CheckAdminPermission(permission.QueryReferral), controllers.GetCommissionRules)
+	admin.POST("commission_rule", middlewares.CheckAdminPermission(permission.ModifyReferral), controllers.AddCommissionRule)
+	admin.PATCH("commission_rule/:id", middlewares.CheckAdminPermission(permission.ModifyReferral), controllers.UpdateCommissionRule)
+	admin.DELETE("commission_rule/:id", middlewares.CheckAdminPermission(permission.ModifyReferral), controllers.DeleteCommissionRule)
 }
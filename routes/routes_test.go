@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"invar/database"
+	"invar/middlewares"
+	"invar/models"
+	"invar/services"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 需要設定 TEST_DB_DSN 指向測試用的 Postgres
+func TestAdminRoutesRejectUserToken(t *testing.T) {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("Connect test database fail=", err)
+	}
+	database.DB = db
+	database.AutoMigrate()
+
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:1"
+	}
+	database.RDS = redis.NewClient(&redis.Options{Addr: addr})
+
+	os.Setenv("PASETO_KEYS", "test:707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f")
+	defer os.Unsetenv("PASETO_KEYS")
+	services.InitSymmetricKey()
+
+	user := models.User{
+		Email:    "routes-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "@example.com",
+		UserName: "routes",
+	}
+	user.SetPassword("password")
+	err = services.RegisterUser(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := services.GenerateAccessToken(middlewares.User, user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	Setup(&app.RouterGroup)
+
+	for _, path := range []string{"/api/v1/admin/tfa", "/api/v1/admin/session"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		request.Header.Set("Content-Type", "application/json")
+
+		recorder := httptest.NewRecorder()
+		app.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusForbidden {
+			t.Fatal("user token should be rejected by ", path, ", code=", recorder.Code)
+		}
+	}
+}
@@ -5,10 +5,35 @@ import (
 	"errors"
 	"invar/database"
 	"invar/models"
+	"invar/permission"
+	"invar/utils"
 
+	"github.com/lib/pq"
 	"github.com/sec51/twofactor"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	ErrAdminAccountUsed      = errors.New("admin account was used")
+	ErrInvalidPermission     = errors.New("invalid permission")
+	ErrRemoveOwnModifyAdmin  = errors.New("cannot remove own modify admin permission")
+	ErrLastSuperAdmin        = errors.New("cannot remove the last super admin")
+	ErrModifySelf            = errors.New("cannot modify self")
+	ErrRequireSuperAdmin     = errors.New("require super admin")
+	ErrAdminStatusNotChanged = errors.New("admin status not changed")
+)
+
+// AdminReq 新增或修改管理者的資料，由呼叫端決定要更新哪些欄位
+type AdminReq struct {
+	Account            string  `json:"account"`
+	Password           string  `json:"password"`
+	Permissions        []int32 `json:"permissions"`
+	RevokedPermissions []int32 `json:"revoked_permissions"`
+	RoleIDs            []uint  `json:"role_ids"`
+	SuperAdmin         bool    `json:"super_admin"`
+}
+
 func RegisterAdmin(admin *models.Admin) error {
 	err := database.DB.Create(&admin).Error
 	if err != nil {
@@ -25,15 +50,371 @@ func UpdateAdmin(admin *models.Admin) error {
 	return nil
 }
 
-func CheckRepeatAdminEmail(email string) error {
+// ChangeAdminPassword 更新密碼並遞增令牌版本，已簽發的 access token 會立即失效。
+func ChangeAdminPassword(admin *models.Admin, password string) error {
+	admin.SetPassword(password)
+	admin.MustResetPassword = false
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(admin).UpdateColumns(map[string]interface{}{
+			"password":            admin.Password,
+			"must_reset_password": false,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, admin.ID)
+	return nil
+}
+
+func CheckRepeatAdminAccount(account string) error {
 	var admin models.Admin
-	result := database.DB.Where("email = ?", email).First(&admin)
+	result := database.DB.Unscoped().Where("account = ?", account).Limit(1).Find(&admin)
+	if result.Error != nil {
+		return result.Error
+	}
 	if result.RowsAffected > 0 {
-		return errors.New("email was used")
+		return ErrAdminAccountUsed
+	}
+	return nil
+}
+
+func GetAdmins() ([]models.Admin, error) {
+	var admins []models.Admin
+	err := database.DB.Preload("Roles").Order("id").Find(&admins).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range admins {
+		admins[i].EffectivePermissions = ResolvePermissions(admins[i])
+	}
+
+	return admins, nil
+}
+
+// CreateAdmin 新增管理者，只有超級管理者可以新增超級管理者。
+func CreateAdmin(operator models.Admin, data AdminReq) (models.Admin, error) {
+	var admin models.Admin
+
+	if data.SuperAdmin && !operator.SuperAdmin {
+		return admin, ErrRequireSuperAdmin
+	}
+
+	permissions, err := normalizePermissions(data.Permissions)
+	if err != nil {
+		return admin, err
+	}
+
+	revokedPermissions, err := normalizePermissions(data.RevokedPermissions)
+	if err != nil {
+		return admin, err
+	}
+
+	roles, err := getAdminRolesByID(data.RoleIDs)
+	if err != nil {
+		return admin, err
+	}
+
+	admin = models.Admin{
+		Account:            data.Account,
+		Premissions:        permissions,
+		RevokedPermissions: revokedPermissions,
+		Roles:              roles,
+		Status:             models.AdminActive,
+		SuperAdmin:         data.SuperAdmin,
+	}
+
+	err = checkGrantable(operator, ResolvePermissions(admin))
+	if err != nil {
+		return admin, err
+	}
+
+	err = CheckRepeatAdminAccount(data.Account)
+	if err != nil {
+		return admin, err
+	}
+
+	admin.SetPassword(data.Password)
+
+	err = RegisterAdmin(&admin)
+	admin.EffectivePermissions = ResolvePermissions(admin)
+	return admin, err
+}
+
+// UpdateAdminPermissions 修改管理者個別授予與撤銷的權限及超級管理者身分，修改後已簽發的令牌立即失效。
+func UpdateAdminPermissions(operator models.Admin, adminID uint, data AdminReq) (models.Admin, error) {
+	var admin models.Admin
+
+	permissions, err := normalizePermissions(data.Permissions)
+	if err != nil {
+		return admin, err
+	}
+
+	revokedPermissions, err := normalizePermissions(data.RevokedPermissions)
+	if err != nil {
+		return admin, err
+	}
+
+	err = checkGrantable(operator, permissions)
+	if err != nil {
+		return admin, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		admin, err = lockAdmin(tx, adminID)
+		if err != nil {
+			return err
+		}
+
+		if (admin.SuperAdmin || data.SuperAdmin) && !operator.SuperAdmin {
+			return ErrRequireSuperAdmin
+		}
+
+		if admin.SuperAdmin && !data.SuperAdmin {
+			err = checkRemainingSuperAdmin(tx, admin.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&admin).Association("Roles").Find(&admin.Roles)
+		if err != nil {
+			return err
+		}
+
+		admin.Premissions = permissions
+		admin.RevokedPermissions = revokedPermissions
+		admin.SuperAdmin = data.SuperAdmin
+		if admin.ID == operator.ID && !hasPermission(ResolvePermissions(admin), permission.ModifyAdmin) {
+			return ErrRemoveOwnModifyAdmin
+		}
+
+		err = tx.Model(&admin).UpdateColumns(map[string]interface{}{
+			"premissions":         admin.Premissions,
+			"revoked_permissions": admin.RevokedPermissions,
+			"super_admin":         admin.SuperAdmin,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return admin, err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, admin.ID)
+	clearAdminPermissionCache(admin.ID)
+	admin.EffectivePermissions = ResolvePermissions(admin)
+	return admin, nil
+}
+
+// SetAdminStatus 停用或啟用管理者，停用時登出所有工作階段。
+func SetAdminStatus(operator models.Admin, adminID uint, adminStatus byte, ip string) (models.Admin, error) {
+	var admin models.Admin
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		admin, err = lockAdmin(tx, adminID)
+		if err != nil {
+			return err
+		}
+
+		err = checkManageAdmin(operator, admin)
+		if err != nil {
+			return err
+		}
+
+		if admin.Status == adminStatus {
+			return ErrAdminStatusNotChanged
+		}
+
+		if adminStatus == models.AdminDisabled && admin.SuperAdmin {
+			err = checkRemainingSuperAdmin(tx, admin.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		admin.Status = adminStatus
+		err = tx.Model(&admin).UpdateColumn("status", admin.Status).Error
+		if err != nil {
+			return err
+		}
+
+		if adminStatus != models.AdminDisabled {
+			return nil
+		}
+
+		err = revokeSessions(tx, roleTypeAdmin, admin.ID, 0, ip)
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return admin, err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, admin.ID)
+	clearAdminPermissionCache(admin.ID)
+	return admin, nil
+}
+
+// ResetAdminPassword 產生臨時密碼並要求管理者下次登入時修改，臨時密碼只回傳一次。
+func ResetAdminPassword(operator models.Admin, adminID uint, ip string) (string, error) {
+	password, err := utils.GenerateRefreshToken(12)
+	if err != nil {
+		return "", err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		admin, err := lockAdmin(tx, adminID)
+		if err != nil {
+			return err
+		}
+
+		err = checkManageAdmin(operator, admin)
+		if err != nil {
+			return err
+		}
+
+		admin.SetPassword(password)
+		err = tx.Model(&admin).UpdateColumns(map[string]interface{}{
+			"password":            admin.Password,
+			"must_reset_password": true,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = revokeSessions(tx, roleTypeAdmin, admin.ID, 0, ip)
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return "", err
 	}
+
+	clearTokenVersionCache(roleTypeAdmin, adminID)
+	return password, nil
+}
+
+// DeleteAdmin 刪除管理者並登出所有工作階段。
+func DeleteAdmin(operator models.Admin, adminID uint, ip string) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		admin, err := lockAdmin(tx, adminID)
+		if err != nil {
+			return err
+		}
+
+		err = checkManageAdmin(operator, admin)
+		if err != nil {
+			return err
+		}
+
+		if admin.SuperAdmin {
+			err = checkRemainingSuperAdmin(tx, admin.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = revokeSessions(tx, roleTypeAdmin, admin.ID, 0, ip)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(&admin).Error
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, adminID)
+	clearAdminPermissionCache(adminID)
 	return nil
 }
 
+func lockAdmin(tx *gorm.DB, adminID uint) (models.Admin, error) {
+	var admin models.Admin
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&admin, adminID).Error
+	return admin, err
+}
+
+// checkManageAdmin 不能停用、重設或刪除自己，非超級管理者不能管理超級管理者。
+func checkManageAdmin(operator, admin models.Admin) error {
+	if admin.ID == operator.ID {
+		return ErrModifySelf
+	}
+
+	if admin.SuperAdmin && !operator.SuperAdmin {
+		return ErrRequireSuperAdmin
+	}
+
+	return nil
+}
+
+// checkRemainingSuperAdmin 確認排除 adminID 後仍有啟用中的超級管理者。
+func checkRemainingSuperAdmin(tx *gorm.DB, adminID uint) error {
+	var admins []models.Admin
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("super_admin = ? AND status = ? AND id <> ?", true, models.AdminActive, adminID).
+		Find(&admins).Error
+	if err != nil {
+		return err
+	}
+
+	if len(admins) == 0 {
+		return ErrLastSuperAdmin
+	}
+
+	return nil
+}
+
+func normalizePermissions(permissions []int32) (pq.Int32Array, error) {
+	result := pq.Int32Array{}
+	for _, code := range permissions {
+		if !permission.IsValidPermission(code) {
+			return nil, ErrInvalidPermission
+		}
+
+		if !hasPermission(result, code) {
+			result = append(result, code)
+		}
+	}
+
+	return result, nil
+}
+
+func hasPermission(permissions []int32, code int32) bool {
+	for _, v := range permissions {
+		if v == code {
+			return true
+		}
+	}
+
+	return false
+}
+
 func GetAdminByAccount(account string) (models.Admin, error) {
 	var admin models.Admin
 
@@ -61,6 +442,11 @@ func GetAdminById(id uint) (models.Admin, error) {
 func GetAdminTFA(admin *models.Admin) ([]byte, error) {
 	var otp *twofactor.Totp
 	var err error
+
+	if admin.TFAEnable {
+		return nil, ErrTFAEnabled
+	}
+
 	if admin.TFACode == nil {
 		otp, err = twofactor.NewTOTP(admin.Account, admin.Account, crypto.SHA1, 6)
 		if err != nil {
@@ -73,7 +459,7 @@ func GetAdminTFA(admin *models.Admin) ([]byte, error) {
 		}
 
 		admin.TFACode = data
-		err = UpdateAdmin(admin)
+		err = database.DB.Model(admin).UpdateColumn("tfa_code", admin.TFACode).Error
 		if err != nil {
 			return nil, err
 		}
@@ -92,51 +478,81 @@ func GetAdminTFA(admin *models.Admin) ([]byte, error) {
 	return qrCode, nil
 }
 
-func EnableAdminTFA(admin *models.Admin, code string) error {
+// EnableAdminTFA 驗證成功後啟用二階段驗證，並回傳新的救援碼。
+func EnableAdminTFA(admin *models.Admin, code string) ([]string, error) {
 	if admin.TFAEnable {
-		return errors.New("Enabled")
+		return nil, ErrTFAEnabled
 	}
 
-	otp, err := twofactor.TOTPFromBytes(admin.TFACode, admin.Account)
-	if err != nil {
-		return err
+	if admin.TFACode == nil {
+		return nil, ErrIncorrectTFA
 	}
 
-	err = otp.Validate(code)
+	err := validateTOTP(roleTypeAdmin, admin.ID, admin.TFACode, admin.Account, code)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	admin.TFAEnable = true
-	err = UpdateAdmin(admin)
-	if err != nil {
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(admin).UpdateColumn("tfa_enable", true).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = generateRecoveryCodes(tx, roleTypeAdmin, admin.ID)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	admin.TFAEnable = true
+	return codes, nil
 }
 
+// CheckAdminTFA 未啟用二階段驗證時直接通過。
 func CheckAdminTFA(admin *models.Admin, code string) error {
-	otp, err := twofactor.TOTPFromBytes(admin.TFACode, admin.Account)
-	if err != nil {
-		return err
+	if !admin.TFAEnable {
+		return nil
 	}
 
+	return validateTOTP(roleTypeAdmin, admin.ID, admin.TFACode, admin.Account, code)
+}
+
+// CheckAdminRecoveryCode 以救援碼取代 TOTP 驗證碼，救援碼使用後即失效。
+func CheckAdminRecoveryCode(admin *models.Admin, code string) error {
 	if !admin.TFAEnable {
-		return errors.New("Not enable tfa.")
+		return ErrTFANotEnabled
 	}
 
-	err = otp.Validate(code)
+	return useRecoveryCode(roleTypeAdmin, admin.ID, code)
+}
+
+// RegenerateAdminRecoveryCodes 重新產生救援碼，舊的救援碼全部失效。
+func RegenerateAdminRecoveryCodes(admin *models.Admin, code string) ([]string, error) {
+	if !admin.TFAEnable {
+		return nil, ErrTFANotEnabled
+	}
+
+	err := CheckAdminTFA(admin, code)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		codes, err = generateRecoveryCodes(tx, roleTypeAdmin, admin.ID)
+		return err
+	})
+
+	return codes, err
 }
 
+// DisableAdminTFA 停用二階段驗證並清除金鑰與救援碼。
 func DisableAdminTFA(admin *models.Admin, code string) error {
 	if !admin.TFAEnable {
-		return errors.New("Disabled")
+		return ErrTFANotEnabled
 	}
 
 	err := CheckAdminTFA(admin, code)
@@ -144,11 +560,22 @@ func DisableAdminTFA(admin *models.Admin, code string) error {
 		return err
 	}
 
-	admin.TFAEnable = false
-	err = UpdateAdmin(admin)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(admin).UpdateColumns(map[string]interface{}{
+			"tfa_enable": false,
+			"tfa_code":   nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return deleteRecoveryCodes(tx, roleTypeAdmin, admin.ID)
+	})
 	if err != nil {
 		return err
 	}
 
+	admin.TFAEnable = false
+	admin.TFACode = nil
 	return nil
 }
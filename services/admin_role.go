@@ -0,0 +1,412 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/permission"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAdminRoleNameUsed      = errors.New("admin role name was used")
+	ErrBuiltInRole            = errors.New("built-in role cannot be deleted")
+	ErrPermissionNotGrantable = errors.New("permission not grantable")
+)
+
+// 有效權限快取的存活時間，快取清除失敗時最多延遲這段時間才生效
+const adminPermissionCacheTTL = 5 * time.Minute
+
+type AdminRoleReq struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Permissions []int32 `json:"permissions"`
+}
+
+// ResolvePermissions 計算管理者的有效權限：所屬角色的權限加上個別授予的權限，再扣除個別撤銷的權限。
+// 超級管理者擁有所有權限。
+func ResolvePermissions(admin models.Admin) []int32 {
+	if admin.SuperAdmin {
+		return permission.GetDefaultAdminPermission()
+	}
+
+	set := map[int32]bool{}
+	for _, role := range admin.Roles {
+		for _, code := range role.Permissions {
+			set[code] = true
+		}
+	}
+
+	for _, code := range admin.Premissions {
+		set[code] = true
+	}
+
+	for _, code := range admin.RevokedPermissions {
+		delete(set, code)
+	}
+
+	permissions := make([]int32, 0, len(set))
+	for code := range set {
+		permissions = append(permissions, code)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+
+	return permissions
+}
+
+// GetEffectivePermissions 取得管理者的有效權限，優先使用 Redis 快取。
+func GetEffectivePermissions(adminID uint) ([]int32, error) {
+	var ctx = context.Background()
+	key := adminPermissionCacheKey(adminID)
+
+	result, err := database.RDS.Get(ctx, key).Result()
+	if err == nil {
+		var permissions []int32
+		err = json.Unmarshal([]byte(result), &permissions)
+		if err == nil {
+			return permissions, nil
+		}
+	}
+
+	admin, err := GetAdminDetail(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(admin.EffectivePermissions)
+	if err == nil {
+		err = database.RDS.Set(ctx, key, bytes, adminPermissionCacheTTL).Err()
+	}
+	if err != nil {
+		logrus.Error("Set admin permission cache fail=", err)
+	}
+
+	return admin.EffectivePermissions, nil
+}
+
+// GetAdminDetail 取得管理者與所屬角色，並計算有效權限。
+func GetAdminDetail(adminID uint) (models.Admin, error) {
+	var admin models.Admin
+
+	err := database.DB.Preload("Roles").First(&admin, adminID).Error
+	if err != nil {
+		return admin, err
+	}
+
+	admin.EffectivePermissions = ResolvePermissions(admin)
+	return admin, nil
+}
+
+func GetAdminRoles() ([]models.AdminRole, error) {
+	var roles []models.AdminRole
+	err := database.DB.Order("id").Find(&roles).Error
+	return roles, err
+}
+
+func CreateAdminRole(operator models.Admin, data AdminRoleReq) (models.AdminRole, error) {
+	var role models.AdminRole
+
+	permissions, err := normalizePermissions(data.Permissions)
+	if err != nil {
+		return role, err
+	}
+
+	err = checkGrantable(operator, permissions)
+	if err != nil {
+		return role, err
+	}
+
+	err = checkRepeatAdminRoleName(database.DB, data.Name, 0)
+	if err != nil {
+		return role, err
+	}
+
+	role = models.AdminRole{
+		Name:        data.Name,
+		Description: data.Description,
+		Permissions: permissions,
+	}
+
+	err = database.DB.Create(&role).Error
+	return role, err
+}
+
+// UpdateAdminRole 修改角色，所屬管理者已簽發的令牌立即失效。
+func UpdateAdminRole(operator models.Admin, roleID uint, data AdminRoleReq) (models.AdminRole, error) {
+	var role models.AdminRole
+	var adminIDs []uint
+
+	permissions, err := normalizePermissions(data.Permissions)
+	if err != nil {
+		return role, err
+	}
+
+	err = checkGrantable(operator, permissions)
+	if err != nil {
+		return role, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&role, roleID).Error
+		if err != nil {
+			return err
+		}
+
+		err = checkRepeatAdminRoleName(tx, data.Name, role.ID)
+		if err != nil {
+			return err
+		}
+
+		role.Name = data.Name
+		role.Description = data.Description
+		role.Permissions = permissions
+
+		err = checkOwnModifyAdmin(tx, operator, func(admin *models.Admin) {
+			for i := range admin.Roles {
+				if admin.Roles[i].ID == role.ID {
+					admin.Roles[i] = role
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&role).UpdateColumns(map[string]interface{}{
+			"name":        role.Name,
+			"description": role.Description,
+			"permissions": role.Permissions,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		adminIDs, err = bumpRoleAdmins(tx, role.ID)
+		return err
+	})
+	if err != nil {
+		return role, err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, adminIDs...)
+	clearAdminPermissionCache(adminIDs...)
+	return role, nil
+}
+
+// DeleteAdminRole 刪除角色並解除所有管理者的綁定，預設角色無法刪除。
+func DeleteAdminRole(operator models.Admin, roleID uint) error {
+	var adminIDs []uint
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var role models.AdminRole
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&role, roleID).Error
+		if err != nil {
+			return err
+		}
+
+		if role.BuiltIn {
+			return ErrBuiltInRole
+		}
+
+		err = checkOwnModifyAdmin(tx, operator, func(admin *models.Admin) {
+			roles := admin.Roles[:0]
+			for _, r := range admin.Roles {
+				if r.ID != role.ID {
+					roles = append(roles, r)
+				}
+			}
+			admin.Roles = roles
+		})
+		if err != nil {
+			return err
+		}
+
+		adminIDs, err = bumpRoleAdmins(tx, role.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("DELETE FROM admin_role_bindings WHERE admin_role_id = ?", role.ID).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, adminIDs...)
+	clearAdminPermissionCache(adminIDs...)
+	return nil
+}
+
+// SetAdminRoles 設定管理者所屬的角色，修改後已簽發的令牌立即失效。
+func SetAdminRoles(operator models.Admin, adminID uint, roleIDs []uint) (models.Admin, error) {
+	var admin models.Admin
+
+	roles, err := getAdminRolesByID(roleIDs)
+	if err != nil {
+		return admin, err
+	}
+
+	for _, role := range roles {
+		err = checkGrantable(operator, role.Permissions)
+		if err != nil {
+			return admin, err
+		}
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		admin, err = lockAdmin(tx, adminID)
+		if err != nil {
+			return err
+		}
+
+		if admin.SuperAdmin && !operator.SuperAdmin {
+			return ErrRequireSuperAdmin
+		}
+
+		admin.Roles = roles
+		if admin.ID == operator.ID && !hasPermission(ResolvePermissions(admin), permission.ModifyAdmin) {
+			return ErrRemoveOwnModifyAdmin
+		}
+
+		err = tx.Model(&admin).Association("Roles").Replace(roles)
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeAdmin, admin.ID)
+	})
+	if err != nil {
+		return admin, err
+	}
+
+	clearTokenVersionCache(roleTypeAdmin, admin.ID)
+	clearAdminPermissionCache(admin.ID)
+	admin.EffectivePermissions = ResolvePermissions(admin)
+	return admin, nil
+}
+
+func getAdminRolesByID(roleIDs []uint) ([]models.AdminRole, error) {
+	roles := []models.AdminRole{}
+	if len(roleIDs) == 0 {
+		return roles, nil
+	}
+
+	err := database.DB.Where("id IN ?", roleIDs).Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[uint]bool{}
+	for _, id := range roleIDs {
+		ids[id] = true
+	}
+
+	if len(roles) != len(ids) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return roles, nil
+}
+
+func checkRepeatAdminRoleName(tx *gorm.DB, name string, exceptID uint) error {
+	var count int64
+	err := tx.Model(&models.AdminRole{}).Where("name = ? AND id <> ?", name, exceptID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrAdminRoleNameUsed
+	}
+
+	return nil
+}
+
+// checkGrantable 非超級管理者只能授予自己擁有的權限。
+func checkGrantable(operator models.Admin, permissions []int32) error {
+	if operator.SuperAdmin {
+		return nil
+	}
+
+	owned, err := GetEffectivePermissions(operator.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range permissions {
+		if !hasPermission(owned, code) {
+			return ErrPermissionNotGrantable
+		}
+	}
+
+	return nil
+}
+
+// checkOwnModifyAdmin 套用角色變更後，操作者必須仍保有 ModifyAdmin 權限。
+func checkOwnModifyAdmin(tx *gorm.DB, operator models.Admin, apply func(admin *models.Admin)) error {
+	var admin models.Admin
+	err := tx.Preload("Roles").First(&admin, operator.ID).Error
+	if err != nil {
+		return err
+	}
+
+	apply(&admin)
+	if !hasPermission(ResolvePermissions(admin), permission.ModifyAdmin) {
+		return ErrRemoveOwnModifyAdmin
+	}
+
+	return nil
+}
+
+// bumpRoleAdmins 讓角色所屬的管理者已簽發的令牌失效，回傳受影響的管理者。
+func bumpRoleAdmins(tx *gorm.DB, roleID uint) ([]uint, error) {
+	var adminIDs []uint
+	err := tx.Table("admin_role_bindings").Where("admin_role_id = ?", roleID).Pluck("admin_id", &adminIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, adminID := range adminIDs {
+		err = BumpTokenVersion(tx, roleTypeAdmin, adminID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return adminIDs, nil
+}
+
+func clearAdminPermissionCache(adminIDs ...uint) {
+	var ctx = context.Background()
+
+	if len(adminIDs) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(adminIDs))
+	for _, adminID := range adminIDs {
+		keys = append(keys, adminPermissionCacheKey(adminID))
+	}
+
+	err := database.RDS.Del(ctx, keys...).Err()
+	if err != nil {
+		logrus.Error("Clear admin permission cache fail=", err)
+	}
+}
+
+func adminPermissionCacheKey(adminID uint) string {
+	return database.AdminPermissionCache + ":" + strconv.Itoa(int(adminID))
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"invar/models"
+	"invar/permission"
+	"reflect"
+	"testing"
+)
+
+func TestResolvePermissions(t *testing.T) {
+	admin := models.Admin{
+		Roles: []models.AdminRole{
+			{Permissions: []int32{permission.QueryUser, permission.QueryOrder}},
+			{Permissions: []int32{permission.QueryOrder, permission.ModifyOrder}},
+		},
+		Premissions:        []int32{permission.QueryBank},
+		RevokedPermissions: []int32{permission.ModifyOrder},
+	}
+
+	want := []int32{permission.QueryUser, permission.QueryOrder, permission.QueryBank}
+	got := ResolvePermissions(admin)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// 超級管理者不受撤銷的權限影響
+	admin.SuperAdmin = true
+	got = ResolvePermissions(admin)
+	if !reflect.DeepEqual(got, permission.GetDefaultAdminPermission()) {
+		t.Fatalf("super admin got %v", got)
+	}
+}
@@ -1,146 +1,244 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"invar/database"
 	"invar/models"
-	"invar/utils"
+	"os"
 	"strconv"
 	"time"
 
 	"aidanwoods.dev/go-paseto"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
-// Token
-var symmetricKey paseto.V4SymmetricKey
+// 與 middlewares 的角色類型相同，services 無法引用 middlewares
+const (
+	roleTypeAdmin = 1
+	roleTypeUser  = 2
+)
+
+const accessTokenLifetime = 15 * time.Minute
 
-func InitSymmetricKey() {
-	symmetricKey = paseto.NewV4SymmetricKey()
-	logrus.Info("Paseto Key was created.")
+// 令牌版本快取的存活時間，快取清除失敗時最多延遲這段時間才生效
+const tokenVersionCacheTTL = 5 * time.Minute
+
+var (
+	ErrTokenVersionMismatch = errors.New("token version mismatch")
+	ErrRoleDisabled         = errors.New("role was disabled")
+)
+
+// AccessClaims 存放在 access token 內的自訂 claims，Permissions 為簽發當下管理者權限的快照。
+type AccessClaims struct {
+	TokenID      string  `json:"jti"`
+	RoleType     uint    `json:"role_type"`
+	RoleID       uint    `json:"role_id"`
+	SessionID    uint    `json:"session_id"`
+	TokenVersion uint    `json:"token_version"`
+	Permissions  []int32 `json:"permissions,omitempty"`
 }
 
-func ParseToken(token string) (roleType int, roleID int, err error) {
+func ParseToken(token string) (AccessClaims, error) {
+	var claims AccessClaims
+
 	parser := paseto.NewParser()
+	parser.AddRule(paseto.ForAudience(tokenAudience()))
+	parser.AddRule(paseto.IssuedBy(tokenIssuer()))
+	parser.AddRule(paseto.ValidAt(time.Now()))
 
-	data, err := parser.ParseV4Local(symmetricKey, token, nil)
+	data, err := keyRing.Decrypt(parser, token)
 	if err != nil {
-		return roleType, roleID, err
+		return claims, err
 	}
 
-	roleTypeStr, err := data.GetJti()
+	err = json.Unmarshal(data.ClaimsJSON(), &claims)
 	if err != nil {
-		return roleType, roleID, err
+		return claims, err
 	}
 
-	roleIdStr, err := data.GetSubject()
-	if err != nil {
-		return roleType, roleID, err
+	if claims.TokenID == "" || claims.RoleType == 0 || claims.RoleID == 0 {
+		return claims, errors.New("missing claims")
 	}
 
-	roleType, err = strconv.Atoi(roleTypeStr)
+	version, err := GetTokenVersion(claims.RoleType, claims.RoleID)
 	if err != nil {
-		return roleType, roleID, err
+		return claims, err
 	}
 
-	roleID, err = strconv.Atoi(roleIdStr)
-	if err != nil {
-		return roleType, roleID, err
+	if version != claims.TokenVersion {
+		return claims, ErrTokenVersionMismatch
 	}
 
-	return roleType, roleID, nil
-}
+	if isSessionRevoked(claims.SessionID) {
+		return claims, ErrSessionRevoked
+	}
 
-func GenerateAccessToken(roleType, roleID uint) string {
-	token := paseto.NewToken()
-	token.SetJti(strconv.Itoa(int(roleType)))
-	token.SetSubject(strconv.Itoa(int(roleID)))
-	token.SetExpiration(time.Now().Add(15 * time.Minute))
-	encrypted := token.V4Encrypt(symmetricKey, nil)
-	return encrypted
+	return claims, nil
 }
 
-func GenerateRefreshToken(roleType, roleID uint, ip string) (models.RefreshToken, error) {
-	var refreshToken models.RefreshToken
-	for {
-		token, err := utils.GenerateRefreshToken(64)
+func GenerateAccessToken(roleType, roleID, sessionID uint) (string, error) {
+	claims := AccessClaims{
+		TokenID:   uuid.NewString(),
+		RoleType:  roleType,
+		RoleID:    roleID,
+		SessionID: sessionID,
+	}
+
+	switch roleType {
+	case roleTypeAdmin:
+		admin, err := GetAdminById(roleID)
 		if err != nil {
-			return refreshToken, err
+			return "", err
 		}
-
-		result := database.DB.Where("token = ?", token).First(&refreshToken)
-		if result.RowsAffected == 0 {
-			refreshToken = models.RefreshToken{
-				RoleType:    roleType,
-				RoleID:      roleID,
-				Token:       token,
-				Expires:     time.Now().Add(time.Hour * 24),
-				CreatedByIP: ip,
-			}
-
-			err = database.DB.Create(&refreshToken).Error
-			if err != nil {
-				logrus.Error(err)
-			}
-
-			return refreshToken, nil
+		if admin.Status == models.AdminDisabled {
+			return "", ErrRoleDisabled
+		}
+		permissions, err := GetEffectivePermissions(admin.ID)
+		if err != nil {
+			return "", err
+		}
+		claims.TokenVersion = admin.TokenVersion
+		claims.Permissions = permissions
+	case roleTypeUser:
+		user, err := GetUserById(roleID)
+		if err != nil {
+			return "", err
 		}
+		if user.Status == models.Disabled {
+			return "", ErrRoleDisabled
+		}
+		claims.TokenVersion = user.TokenVersion
+	default:
+		return "", errors.New("unknown role type")
 	}
-}
-
-func RevokeRefreshToken(token string, ip string) (models.RefreshToken, error) {
-	refreshtoken, err := findRefeshToken(token)
 
-	if err != nil {
-		return refreshtoken, err
+	token := paseto.NewToken()
+	token.SetJti(claims.TokenID)
+	token.SetSubject(strconv.Itoa(int(roleID)))
+	token.SetAudience(tokenAudience())
+	token.SetIssuer(tokenIssuer())
+	token.SetIssuedAt(time.Now())
+	token.SetNotBefore(time.Now())
+	token.SetExpiration(time.Now().Add(accessTokenLifetime))
+
+	err := token.Set("role_type", claims.RoleType)
+	if err == nil {
+		err = token.Set("role_id", claims.RoleID)
 	}
-
-	err = RevokeToken(token, ip)
-	if err != nil {
-		return refreshtoken, err
+	if err == nil {
+		err = token.Set("session_id", claims.SessionID)
+	}
+	if err == nil {
+		err = token.Set("token_version", claims.TokenVersion)
+	}
+	if err == nil && claims.Permissions != nil {
+		err = token.Set("permissions", claims.Permissions)
 	}
-
-	newRefreshToken, err := GenerateRefreshToken(refreshtoken.RoleType, refreshtoken.RoleID, ip)
 	if err != nil {
-		return refreshtoken, err
+		return "", err
 	}
 
-	return newRefreshToken, nil
+	return keyRing.Encrypt(token), nil
 }
 
-func RevokeToken(token string, ip string) error {
-	refreshtoken, err := findRefeshToken(token)
+// GetTokenVersion 取得角色目前的令牌版本，優先使用 Redis 快取。
+func GetTokenVersion(roleType, roleID uint) (uint, error) {
+	var ctx = context.Background()
+	key := tokenVersionCacheKey(roleType, roleID)
 
-	if err != nil {
-		return err
+	result, err := database.RDS.Get(ctx, key).Result()
+	if err == nil {
+		version, err := strconv.ParseUint(result, 10, 64)
+		if err == nil {
+			return uint(version), nil
+		}
 	}
 
-	refreshtoken.SetToRevoked(ip)
+	var version uint
+	switch roleType {
+	case roleTypeAdmin:
+		admin, err := GetAdminById(roleID)
+		if err != nil {
+			return 0, err
+		}
+		version = admin.TokenVersion
+	case roleTypeUser:
+		user, err := GetUserById(roleID)
+		if err != nil {
+			return 0, err
+		}
+		version = user.TokenVersion
+	default:
+		return 0, errors.New("unknown role type")
+	}
 
-	err = database.DB.Updates(&refreshtoken).Error
+	err = database.RDS.Set(ctx, key, version, tokenVersionCacheTTL).Err()
 	if err != nil {
-		return err
+		logrus.Error("Set token version cache fail=", err)
 	}
 
-	return nil
+	return version, nil
 }
 
-func findRefeshToken(token string) (models.RefreshToken, error) {
-	var refreshToken models.RefreshToken
+// BumpTokenVersion 讓角色已簽發的 access token 立即失效，用於修改密碼、停用帳號或變更權限。
+// 交易提交後需呼叫 clearTokenVersionCache，提交前清除快取可能被其他請求以舊版本重新寫入。
+func BumpTokenVersion(tx *gorm.DB, roleType, roleID uint) error {
+	var model interface{}
+	switch roleType {
+	case roleTypeAdmin:
+		model = &models.Admin{}
+	case roleTypeUser:
+		model = &models.User{}
+	default:
+		return errors.New("unknown role type")
+	}
 
-	result := database.DB.Where("token = ?", token).First(&refreshToken)
+	return tx.Model(model).Where("id = ?", roleID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+func clearTokenVersionCache(roleType uint, roleIDs ...uint) {
+	var ctx = context.Background()
+
+	if len(roleIDs) == 0 {
+		return
+	}
 
-	if result.Error != nil {
-		return refreshToken, result.Error
+	keys := make([]string, 0, len(roleIDs))
+	for _, roleID := range roleIDs {
+		keys = append(keys, tokenVersionCacheKey(roleType, roleID))
 	}
 
-	if refreshToken.IsRevoked() {
-		return refreshToken, errors.New("was revoked")
+	err := database.RDS.Del(ctx, keys...).Err()
+	if err != nil {
+		logrus.Error("Clear token version cache fail=", err)
 	}
+}
 
-	if refreshToken.Expires.Sub(time.Now()) < 0 {
-		return refreshToken, errors.New("was expired")
+func tokenVersionCacheKey(roleType, roleID uint) string {
+	return database.TokenVersionCache + ":" + tokenVersionField(roleType, roleID)
+}
+
+func tokenVersionField(roleType, roleID uint) string {
+	return strconv.Itoa(int(roleType)) + ":" + strconv.Itoa(int(roleID))
+}
+
+func tokenAudience() string {
+	audience := os.Getenv("TOKEN_AUDIENCE")
+	if audience == "" {
+		return "invar-api"
 	}
+	return audience
+}
 
-	return refreshToken, nil
+func tokenIssuer() string {
+	issuer := os.Getenv("TOKEN_ISSUER")
+	if issuer == "" {
+		return "invar"
+	}
+	return issuer
 }
@@ -50,16 +50,6 @@ func GetBank(userID uint) (models.Bank, error) {
 	return bank, nil
 }
 
-func UpdateBank(bank models.Bank) error {
-	result := database.DB.Updates(&bank)
-
-	if result.Error != nil {
-		return result.Error
-	}
-
-	return nil
-}
-
 func AddToken(token *models.Token) error {
 	result := database.DB.Create(&token)
 
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"invar/database"
+	"invar/models"
+	"invar/storage"
+	"invar/utils"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultOrphanFileGrace = 24 * time.Hour
+
+// SweepOrphanFiles 刪除沒有被任何商品或實名資料參照的檔案。
+// 只處理超過 ORPHAN_FILE_GRACE 的檔案，避免刪除上傳中尚未寫入資料庫的檔案；
+// 送審紀錄參照的舊版實名照片需保留給審核紀錄，不會被刪除。
+func SweepOrphanFiles() {
+	if storage.Default() == nil {
+		return
+	}
+
+	before := time.Now().Add(-utils.EnvDuration("ORPHAN_FILE_GRACE", defaultOrphanFileGrace))
+
+	err := sweepProductImages(before)
+	if err != nil {
+		logrus.Error("Sweep product images fail=", err)
+	}
+
+	err = sweepKYCDocuments(before)
+	if err != nil {
+		logrus.Error("Sweep kyc documents fail=", err)
+	}
+}
+
+func sweepProductImages(before time.Time) error {
+	// 已刪除的商品仍可能被訂單顯示，圖片一併保留
+	var products []models.Product
+	err := database.DB.Unscoped().Select("image", "preview_image").Find(&products).Error
+	if err != nil {
+		return err
+	}
+
+	referenced := map[string]bool{}
+	for _, product := range products {
+		referenced[product.Image] = true
+		referenced[product.PreviewImage] = true
+	}
+
+	return sweepObjects(ProductImagePrefix, before, func(key string) bool {
+		return referenced[key]
+	})
+}
+
+func sweepKYCDocuments(before time.Time) error {
+	// 先刪除沒有被實名資料或送審紀錄參照的照片紀錄，檔案再由下方一併清除
+	documentIDs, err := referencedKYCDocumentIDs()
+	if err != nil {
+		return err
+	}
+
+	var docs []models.KYCDocument
+	err = database.DB.Where("created_at < ?", before).Find(&docs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if documentIDs[doc.ID] {
+			continue
+		}
+
+		err = database.DB.Delete(&doc).Error
+		if err != nil {
+			logrus.Error("Delete orphan kyc document fail, document=", doc.ID, ", err=", err)
+		}
+	}
+
+	var storageKeys []string
+	err = database.DB.Model(&models.KYCDocument{}).Pluck("storage_key", &storageKeys).Error
+	if err != nil {
+		return err
+	}
+
+	referenced := map[string]bool{}
+	for _, storageKey := range storageKeys {
+		referenced[kycObjectKey(storageKey)] = true
+	}
+
+	return sweepObjects(kycDocumentPrefix, before, func(key string) bool {
+		return referenced[key]
+	})
+}
+
+// referencedKYCDocumentIDs 取得實名資料與送審紀錄參照的所有照片 ID
+func referencedKYCDocumentIDs() (map[uint]bool, error) {
+	var kycs []models.UserKYC
+	err := database.DB.Unscoped().Select("photo_id", "id_photo_front_id", "id_photo_back_id", "photo_with_id_id").
+		Find(&kycs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var submissions []models.KYCSubmission
+	err = database.DB.Unscoped().Select("photo_id", "id_photo_front_id", "id_photo_back_id", "photo_with_id_id").
+		Find(&submissions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[uint]bool{}
+	for _, kyc := range kycs {
+		ids[kyc.PhotoID] = true
+		ids[kyc.IDPhotoFrontID] = true
+		ids[kyc.IDPhotoBackID] = true
+		ids[kyc.PhotoWithIDID] = true
+	}
+	for _, submission := range submissions {
+		ids[submission.PhotoID] = true
+		ids[submission.IDPhotoFrontID] = true
+		ids[submission.IDPhotoBackID] = true
+		ids[submission.PhotoWithIDID] = true
+	}
+
+	return ids, nil
+}
+
+func sweepObjects(prefix string, before time.Time, referenced func(key string) bool) error {
+	objects, err := storage.Default().List(context.Background(), prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if referenced(object.Key) || object.ModTime.After(before) || !strings.HasPrefix(object.Key, prefix) {
+			continue
+		}
+
+		err = storage.Default().Delete(context.Background(), object.Key)
+		if err != nil {
+			logrus.Error("Delete orphan file fail, key=", object.Key, ", err=", err)
+			continue
+		}
+		logrus.Info("Deleted orphan file, key=", object.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"invar/utils"
+	"io"
+	"mime/multipart"
+)
+
+const (
+	defaultImageMaxDimension = 8000
+	defaultImageMaxPixels    = 40000000
+	defaultThumbnailSize     = 480
+)
+
+// ImageSupportTypes 商品與實名照片接受的圖片類型
+var ImageSupportTypes = []int{utils.PNG, utils.JPEG, utils.JPG}
+
+// ReadUploadedImage 依檔案內容驗證上傳的圖片並重新編碼，長寬上限由 IMAGE_MAX_DIMENSION 與 IMAGE_MAX_PIXELS 設定。
+func ReadUploadedImage(fileHeader *multipart.FileHeader) (utils.ProcessedImage, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return utils.ProcessedImage{}, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return utils.ProcessedImage{}, err
+	}
+
+	return utils.ProcessImage(data, ImageSupportTypes,
+		utils.EnvInt("IMAGE_MAX_DIMENSION", defaultImageMaxDimension),
+		utils.EnvInt("IMAGE_MAX_PIXELS", defaultImageMaxPixels))
+}
+
+// SaveProductImages 將商品圖片寫入 upload 並回傳圖片與預覽圖的 key。
+// 未上傳預覽圖時由圖片產生縮圖，預覽圖一律縮小到 THUMBNAIL_SIZE 以內。
+func SaveProductImages(upload *Upload, large, small *multipart.FileHeader) (string, string, error) {
+	image, err := ReadUploadedImage(large)
+	if err != nil {
+		return "", "", err
+	}
+
+	preview := image
+	if small != nil {
+		preview, err = ReadUploadedImage(small)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	preview, err = utils.Thumbnail(preview, utils.EnvInt("THUMBNAIL_SIZE", defaultThumbnailSize))
+	if err != nil {
+		return "", "", err
+	}
+
+	imageKey, err := upload.Save(ProductImagePrefix, image.Ext, image.ContentType, image.Data)
+	if err != nil {
+		return "", "", err
+	}
+
+	previewKey, err := upload.Save(ProductImagePrefix, preview.Ext, preview.ContentType, preview.Data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return imageKey, previewKey, nil
+}
+
+// SaveProductPreviewImage 只更新預覽圖時使用，同樣縮小到 THUMBNAIL_SIZE 以內。
+func SaveProductPreviewImage(upload *Upload, small *multipart.FileHeader) (string, error) {
+	preview, err := ReadUploadedImage(small)
+	if err != nil {
+		return "", err
+	}
+
+	preview, err = utils.Thumbnail(preview, utils.EnvInt("THUMBNAIL_SIZE", defaultThumbnailSize))
+	if err != nil {
+		return "", err
+	}
+
+	return upload.Save(ProductImagePrefix, preview.Ext, preview.ContentType, preview.Data)
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultKeyFile = "./keys/paseto_keys.json"
+const defaultRotationWindow = time.Hour
+
+var ErrUnknownKeyID = errors.New("unknown key id")
+var ErrKeyRingReadOnly = errors.New("key ring is loaded from PASETO_KEYS and cannot be rotated")
+
+// SigningKey RetiredAt 為被新的金鑰取代的時間，取代後在輪替期間內仍可驗證舊的令牌。
+type SigningKey struct {
+	KeyID     string    `json:"kid"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitempty"`
+}
+
+type keyRingFile struct {
+	Current string       `json:"current"`
+	Keys    []SigningKey `json:"keys"`
+}
+
+type KeyRingInfo struct {
+	Current string       `json:"current"`
+	Keys    []KeyRingKey `json:"keys"`
+}
+
+type KeyRingKey struct {
+	KeyID     string    `json:"kid"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitempty"`
+}
+
+type tokenFooter struct {
+	KeyID string `json:"kid"`
+}
+
+// KeyRing 存放簽發與驗證 PASETO 令牌的金鑰，current 用於簽發，其他未過輪替期限的金鑰只用於驗證。
+type KeyRing struct {
+	mu       sync.RWMutex
+	path     string
+	readOnly bool
+	window   time.Duration
+	modTime  time.Time
+	current  string
+	keys     map[string]paseto.V4SymmetricKey
+	retired  map[string]time.Time
+	file     keyRingFile
+}
+
+var keyRing *KeyRing
+
+// InitSymmetricKey 從 PASETO_KEYS 或金鑰檔載入金鑰，金鑰檔不存在時會建立新的金鑰。
+func InitSymmetricKey() {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		panic("Load paseto key ring fail: " + err.Error())
+	}
+
+	keyRing = ring
+	logrus.Info("Paseto key ring was loaded, current kid=", ring.CurrentKeyID())
+
+	if !ring.readOnly {
+		go ring.watch(time.Minute)
+	}
+}
+
+func LoadKeyRing() (*KeyRing, error) {
+	ring := &KeyRing{
+		path:   os.Getenv("PASETO_KEY_FILE"),
+		window: rotationWindow(),
+	}
+
+	if ring.path == "" {
+		ring.path = defaultKeyFile
+	}
+
+	if keys := os.Getenv("PASETO_KEYS"); keys != "" {
+		ring.readOnly = true
+		return ring, ring.loadFromEnv(keys)
+	}
+
+	_, err := os.Stat(ring.path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, err = ring.Rotate()
+		return ring, err
+	}
+
+	return ring, ring.reload()
+}
+
+// RotateSymmetricKey 產生新的金鑰作為簽發用金鑰，舊的金鑰在輪替期間內仍可驗證。
+func RotateSymmetricKey() (string, error) {
+	return keyRing.Rotate()
+}
+
+func GetKeyRingInfo() KeyRingInfo {
+	return keyRing.Info()
+}
+
+func (ring *KeyRing) CurrentKeyID() string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.current
+}
+
+func (ring *KeyRing) Info() KeyRingInfo {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	info := KeyRingInfo{Current: ring.current, Keys: make([]KeyRingKey, 0)}
+	for _, key := range ring.file.Keys {
+		info.Keys = append(info.Keys, KeyRingKey{KeyID: key.KeyID, CreatedAt: key.CreatedAt, RetiredAt: key.RetiredAt})
+	}
+
+	return info
+}
+
+func (ring *KeyRing) Encrypt(token paseto.Token) string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	footer, _ := json.Marshal(tokenFooter{KeyID: ring.current})
+	token.SetFooter(footer)
+	return token.V4Encrypt(ring.keys[ring.current], nil)
+}
+
+// Decrypt 依令牌 footer 的 kid 選擇金鑰，找不到時重新讀取金鑰檔，以取得其他實例輪替後的金鑰。
+func (ring *KeyRing) Decrypt(parser paseto.Parser, tainted string) (*paseto.Token, error) {
+	footerBytes, err := parser.UnsafeParseFooter(paseto.V4Local, tainted)
+	if err != nil {
+		return nil, err
+	}
+
+	var footer tokenFooter
+	err = json.Unmarshal(footerBytes, &footer)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := ring.lookup(footer.KeyID)
+	if !ok && !ring.readOnly {
+		err = ring.reloadIfChanged()
+		if err != nil {
+			logrus.Error("Reload paseto key ring fail=", err)
+		}
+		key, ok = ring.lookup(footer.KeyID)
+	}
+
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	return parser.ParseV4Local(key, tainted, nil)
+}
+
+// Rotate 在檔案鎖內重新讀取金鑰檔後再加入新的金鑰，多個實例同時輪替時不會覆寫彼此的金鑰。
+func (ring *KeyRing) Rotate() (string, error) {
+	if ring.readOnly {
+		return "", ErrKeyRingReadOnly
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	lock, err := lockKeyRingFile(ring.path)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+
+	current, err := readKeyRingFile(ring.path)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	kid := uuid.NewString()
+	file := keyRingFile{Current: kid}
+	for _, key := range current.Keys {
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = now
+		}
+
+		if now.Sub(key.RetiredAt) < ring.window {
+			file.Keys = append(file.Keys, key)
+		}
+	}
+
+	file.Keys = append(file.Keys, SigningKey{
+		KeyID:     kid,
+		Key:       paseto.NewV4SymmetricKey().ExportHex(),
+		CreatedAt: now,
+	})
+
+	err = writeKeyRingFile(ring.path, file)
+	if err != nil {
+		return "", err
+	}
+
+	err = ring.apply(file)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(ring.path)
+	if err == nil {
+		ring.modTime = info.ModTime()
+	}
+
+	return kid, nil
+}
+
+// lookup 金鑰檔沒有變動時不會重新載入，超過輪替期限的舊金鑰在這裡排除
+func (ring *KeyRing) lookup(kid string) (paseto.V4SymmetricKey, bool) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	key, ok := ring.keys[kid]
+	if retiredAt, retired := ring.retired[kid]; ok && retired && time.Since(retiredAt) >= ring.window {
+		return key, false
+	}
+
+	return key, ok
+}
+
+func (ring *KeyRing) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := ring.reloadIfChanged()
+		if err != nil {
+			logrus.Error("Reload paseto key ring fail=", err)
+		}
+	}
+}
+
+func (ring *KeyRing) reloadIfChanged() error {
+	info, err := os.Stat(ring.path)
+	if err != nil {
+		return err
+	}
+
+	ring.mu.RLock()
+	changed := !info.ModTime().Equal(ring.modTime)
+	ring.mu.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	return ring.reload()
+}
+
+func (ring *KeyRing) reload() error {
+	info, err := os.Stat(ring.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := readKeyRingFile(ring.path)
+	if err != nil {
+		return err
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	err = ring.apply(file)
+	if err != nil {
+		return err
+	}
+
+	ring.modTime = info.ModTime()
+	return nil
+}
+
+// apply 呼叫前需持有寫入鎖
+func (ring *KeyRing) apply(file keyRingFile) error {
+	now := time.Now()
+	keys := make(map[string]paseto.V4SymmetricKey)
+	retired := make(map[string]time.Time)
+
+	for _, v := range file.Keys {
+		if v.KeyID != file.Current && !v.RetiredAt.IsZero() {
+			if now.Sub(v.RetiredAt) >= ring.window {
+				continue
+			}
+			retired[v.KeyID] = v.RetiredAt
+		}
+
+		key, err := paseto.V4SymmetricKeyFromHex(v.Key)
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %w", v.KeyID, err)
+		}
+		keys[v.KeyID] = key
+	}
+
+	if _, ok := keys[file.Current]; !ok {
+		return fmt.Errorf("current key %q not found", file.Current)
+	}
+
+	ring.file = file
+	ring.current = file.Current
+	ring.keys = keys
+	ring.retired = retired
+	return nil
+}
+
+// loadFromEnv 格式為 kid:hex,kid:hex，第一把為簽發用金鑰。
+func (ring *KeyRing) loadFromEnv(value string) error {
+	file := keyRingFile{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			return errors.New("invalid PASETO_KEYS format")
+		}
+
+		if file.Current == "" {
+			file.Current = parts[0]
+		}
+		file.Keys = append(file.Keys, SigningKey{KeyID: parts[0], Key: parts[1]})
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return ring.apply(file)
+}
+
+// lockKeyRingFile 取得金鑰檔的排他鎖，關閉回傳的檔案即釋放
+func lockKeyRingFile(path string) (*os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// readKeyRingFile 金鑰檔不存在時回傳空的金鑰檔
+func readKeyRingFile(path string) (keyRingFile, error) {
+	var file keyRingFile
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return file, err
+	}
+
+	err = json.Unmarshal(data, &file)
+	return file, err
+}
+
+func writeKeyRingFile(path string, file keyRingFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// 先寫入暫存檔再改名，避免其他實例讀到寫到一半的檔案
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func rotationWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("PASETO_ROTATION_WINDOW"))
+	if err != nil || window <= 0 {
+		return defaultRotationWindow
+	}
+
+	return window
+}
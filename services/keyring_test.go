@@ -0,0 +1,142 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	os.Setenv("PASETO_KEY_FILE", filepath.Join(t.TempDir(), "keys.json"))
+	os.Setenv("PASETO_ROTATION_WINDOW", "1h")
+	defer os.Unsetenv("PASETO_KEY_FILE")
+	defer os.Unsetenv("PASETO_ROTATION_WINDOW")
+
+	ring, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := paseto.NewToken()
+	token.SetSubject("1")
+	token.SetExpiration(time.Now().Add(time.Minute))
+	oldToken := ring.Encrypt(token)
+
+	// 重新載入金鑰檔後仍可驗證，代表重啟不會登出使用者
+	reloaded, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reloaded.Decrypt(paseto.NewParser(), oldToken)
+	if err != nil {
+		t.Fatal("token should be valid after reload:", err)
+	}
+
+	oldKeyID := ring.CurrentKeyID()
+	newKeyID, err := ring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newKeyID == oldKeyID {
+		t.Fatal("rotation should change the current key id")
+	}
+
+	_, err = ring.Decrypt(paseto.NewParser(), oldToken)
+	if err != nil {
+		t.Fatal("token signed by the previous key should be valid during rotation window:", err)
+	}
+
+	// 其他實例在找不到 kid 時會重新讀取金鑰檔
+	newToken := ring.Encrypt(token)
+	_, err = reloaded.Decrypt(paseto.NewParser(), newToken)
+	if err != nil {
+		t.Fatal("token signed by the rotated key should be valid on other instances:", err)
+	}
+
+	ring.window = 0
+	_, err = ring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ring.Decrypt(paseto.NewParser(), oldToken)
+	if err != ErrUnknownKeyID {
+		t.Fatal("token signed by an expired key should be rejected, got", err)
+	}
+}
+
+func TestKeyRingRetiredKeyExpiresWithoutReload(t *testing.T) {
+	os.Setenv("PASETO_KEY_FILE", filepath.Join(t.TempDir(), "keys.json"))
+	os.Setenv("PASETO_ROTATION_WINDOW", "1h")
+	defer os.Unsetenv("PASETO_KEY_FILE")
+	defer os.Unsetenv("PASETO_ROTATION_WINDOW")
+
+	ring, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := paseto.NewToken()
+	token.SetSubject("1")
+	token.SetExpiration(time.Now().Add(time.Minute))
+	oldToken := ring.Encrypt(token)
+
+	_, err = ring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ring.Decrypt(paseto.NewParser(), oldToken)
+	if err != nil {
+		t.Fatal("token signed by the previous key should be valid during rotation window:", err)
+	}
+
+	// 金鑰檔沒有變動，輪替期限過後舊金鑰也不能再驗證
+	ring.window = 0
+	_, err = ring.Decrypt(paseto.NewParser(), oldToken)
+	if err != ErrUnknownKeyID {
+		t.Fatal("token signed by an expired key should be rejected without reload, got", err)
+	}
+}
+
+func TestKeyRingConcurrentRotation(t *testing.T) {
+	os.Setenv("PASETO_KEY_FILE", filepath.Join(t.TempDir(), "keys.json"))
+	defer os.Unsetenv("PASETO_KEY_FILE")
+
+	first, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 兩個實例各自輪替，後輪替的實例不能覆寫先輪替的金鑰
+	firstKeyID, err := first.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondKeyID, err := second.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kid := range []string{firstKeyID, secondKeyID} {
+		if _, ok := reloaded.lookup(kid); !ok {
+			t.Fatal("key was lost after concurrent rotation, kid=", kid)
+		}
+	}
+}
@@ -1,22 +1,58 @@
 package services
 
 import (
+	"errors"
 	"invar/database"
 	"invar/models"
+	"invar/permission"
+	"invar/utils"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var (
+	ErrKYCApproved         = errors.New("kyc was approved")
+	ErrKYCNotPending       = errors.New("kyc submission is not pending")
+	ErrKYCClaimed          = errors.New("kyc submission was claimed by another reviewer")
+	ErrKYCNotClaimed       = errors.New("kyc submission was not claimed by reviewer")
+	ErrInvalidReviewer     = errors.New("invalid kyc reviewer")
+	ErrInvalidRejectReason = errors.New("invalid kyc reject reason")
+)
+
+// KYCRejectReason 審核失敗時可選擇的原因
+type KYCRejectReason struct {
+	Code        int32  `json:"code"`
+	Description string `json:"description"`
+}
+
+var kycRejectReasons = []KYCRejectReason{
+	{models.KYCRejectBlurryPhoto, "照片模糊或無法辨識"},
+	{models.KYCRejectNameMismatch, "姓名與證件不符"},
+	{models.KYCRejectIdentityCardMismatch, "身分證號碼與證件不符"},
+	{models.KYCRejectDocumentExpired, "證件已過期"},
+	{models.KYCRejectFaceMismatch, "本人照片與證件不符"},
+	{models.KYCRejectOther, "其他，請參考備註"},
+}
+
+// KYCQueueSearch 審核佇列的篩選條件，Unassigned 只列出尚未被認領的送審
+type KYCQueueSearch struct {
+	ReviewerID uint `form:"reviewer_id"`
+	Unassigned bool `form:"unassigned"`
+}
+
 func GetKYC(key uint) (models.UserKYC, error) {
 	kyc := models.UserKYC{}
-	kyc.ID = key
 
-	result := database.DB.First(&kyc)
-	if result.Error != nil {
-		logrus.Error("Get KYC fail=", result.Error)
+	err := database.DB.First(&kyc, key).Error
+	if err != nil {
+		logrus.Error("Get KYC fail=", err)
 	}
 
-	return kyc, nil
+	return kyc, err
 }
 
 func GetKYCByUserID(userID uint) (models.UserKYC, error) {
@@ -26,27 +62,324 @@ func GetKYCByUserID(userID uint) (models.UserKYC, error) {
 		logrus.Error("Get KYC fail=", result.Error)
 	}
 
-	return kyc, nil
+	return kyc, result.Error
+}
+
+// UpdateKYC 更新實名資料，與 files 內的照片一起寫入
+func UpdateKYC(kyc *models.UserKYC, files *KYCFiles) error {
+	err := files.commit(kyc, func(tx *gorm.DB) error {
+		return tx.Updates(kyc).Error
+	})
+	if err != nil {
+		logrus.Error("Update kyc fail, err", err)
+	}
+
+	return err
+}
+
+func GetKYCRejectReasons() []KYCRejectReason {
+	return kycRejectReasons
+}
+
+// SubmitKYC 保存使用者目前的實名資料與 files 內的照片並建立新版本的送審紀錄，尚未審核的舊版本標記為已取代。
+// 任一步驟失敗時本次上傳的照片會被刪除。
+func SubmitKYC(userID uint, kyc models.UserKYC, files *KYCFiles) (models.KYCSubmission, error) {
+	var submission models.KYCSubmission
+
+	err := files.commit(&kyc, func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if err != nil {
+			return err
+		}
+
+		if user.Status == models.AuditSuccess {
+			return ErrKYCApproved
+		}
+
+		var current models.UserKYC
+		err = tx.Where("user_id = ?", userID).Find(&current).Error
+		if err != nil {
+			return err
+		}
+
+		kyc.ID = current.ID
+		kyc.CreatedAt = current.CreatedAt
+		kyc.UserID = userID
+		err = tx.Save(&kyc).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&models.KYCSubmission{}).
+			Where("user_id = ? AND status = ?", userID, models.KYCPending).
+			UpdateColumn("status", models.KYCSuperseded).Error
+		if err != nil {
+			return err
+		}
+
+		var version uint
+		err = tx.Model(&models.KYCSubmission{}).Where("user_id = ?", userID).
+			Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+		if err != nil {
+			return err
+		}
+
+		submission = models.KYCSubmission{
+			UserID:         userID,
+			Version:        version + 1,
+			Status:         models.KYCPending,
+			Name:           kyc.Name,
+			IdentityCard:   kyc.IdentityCard,
+			PhotoID:        kyc.PhotoID,
+			IDPhotoFrontID: kyc.IDPhotoFrontID,
+			IDPhotoBackID:  kyc.IDPhotoBackID,
+			PhotoWithIDID:  kyc.PhotoWithIDID,
+		}
+
+		err = tx.Create(&submission).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&user).UpdateColumn("status", models.Auditing).Error
+	})
+
+	return submission, err
+}
+
+// GetKYCSubmissions 取得使用者所有版本的送審紀錄，新版本在前。
+func GetKYCSubmissions(userID uint) ([]models.KYCSubmission, error) {
+	submissions := []models.KYCSubmission{}
+	err := database.DB.Where("user_id = ?", userID).Order("version DESC").Find(&submissions).Error
+	return submissions, err
+}
+
+// GetKYCQueue 取得待審核的送審紀錄，只列出使用者仍在審核中的最新版本，先送審的在前。
+func GetKYCQueue(search KYCQueueSearch) ([]models.KYCSubmission, error) {
+	submissions := []models.KYCSubmission{}
+
+	query := database.DB.Preload("User").
+		Joins("JOIN users ON users.id = kyc_submissions.user_id AND users.deleted_at IS NULL").
+		Where("kyc_submissions.status = ? AND users.status = ?", models.KYCPending, models.Auditing)
+
+	if search.Unassigned {
+		query = query.Where("kyc_submissions.reviewer_id = 0")
+	} else if search.ReviewerID != 0 {
+		query = query.Where("kyc_submissions.reviewer_id = ?", search.ReviewerID)
+	}
+
+	err := query.Order("kyc_submissions.created_at").Find(&submissions).Error
+	return submissions, err
+}
+
+// ClaimKYCSubmission 審核者認領送審紀錄，已被其他審核者認領時無法認領。
+func ClaimKYCSubmission(reviewerID, submissionID uint) (models.KYCSubmission, error) {
+	var submission models.KYCSubmission
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		submission, err = lockPendingKYCSubmission(tx, submissionID)
+		if err != nil {
+			return err
+		}
+
+		if submission.ReviewerID == reviewerID {
+			return nil
+		}
+
+		if submission.ReviewerID != 0 {
+			return ErrKYCClaimed
+		}
+
+		return assignKYCSubmission(tx, &submission, reviewerID)
+	})
+
+	return submission, err
+}
+
+// AssignKYCSubmission 指派送審紀錄給審核者，審核者需為啟用中且擁有 ModifyKYC 權限的管理者。
+func AssignKYCSubmission(submissionID, reviewerID uint) (models.KYCSubmission, error) {
+	var submission models.KYCSubmission
+
+	var reviewer models.Admin
+	err := database.DB.First(&reviewer, reviewerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return submission, ErrInvalidReviewer
+	}
+	if err != nil {
+		return submission, err
+	}
+
+	if reviewer.Status != models.AdminActive {
+		return submission, ErrInvalidReviewer
+	}
+
+	permissions, err := GetEffectivePermissions(reviewer.ID)
+	if err != nil {
+		return submission, err
+	}
+
+	if !hasPermission(permissions, permission.ModifyKYC) {
+		return submission, ErrInvalidReviewer
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		submission, err = lockPendingKYCSubmission(tx, submissionID)
+		if err != nil {
+			return err
+		}
+
+		return assignKYCSubmission(tx, &submission, reviewerID)
+	})
+
+	return submission, err
 }
 
-func AddKYC(kyc *models.UserKYC) error {
-	err := database.DB.Create(&kyc)
+// ApproveKYCSubmission 審核通過，使用者狀態改為通過實名認證並寄信通知。
+func ApproveKYCSubmission(reviewerID, submissionID uint) (models.KYCSubmission, error) {
+	submission, user, err := reviewKYCSubmission(reviewerID, submissionID, models.KYCApproved, nil, "")
+	if err != nil {
+		return submission, err
+	}
+
+	text := "您的實名認證已通過審核，現在可以使用購買、提領與質押功能。"
+	go utils.SendEmail(user.Email, "InVar實名認證通過", text)
+
+	return submission, nil
+}
+
+// RejectKYCSubmission 審核失敗需提供原因，使用者狀態改為審核失敗並寄信通知原因。
+func RejectKYCSubmission(reviewerID, submissionID uint, reasons []int32, comment string) (models.KYCSubmission, error) {
+	comment = strings.TrimSpace(comment)
 
+	descriptions, err := kycRejectReasonDescriptions(reasons, comment)
 	if err != nil {
-		logrus.Error("Create kyc fail, err", err)
-		return err.Error
+		return models.KYCSubmission{}, err
 	}
 
-	return nil
+	submission, user, err := reviewKYCSubmission(reviewerID, submissionID, models.KYCRejected, reasons, comment)
+	if err != nil {
+		return submission, err
+	}
+
+	text := "您的實名認證未通過審核，原因：" + strings.Join(descriptions, "、") + "。"
+	if comment != "" {
+		text += "備註：" + comment + "。"
+	}
+	text += "請修正後重新提交。"
+	go utils.SendEmail(user.Email, "InVar實名認證未通過", text)
+
+	return submission, nil
 }
 
-func UpdateKYC(kyc *models.UserKYC) error {
-	err := database.DB.Updates(&kyc)
+func reviewKYCSubmission(reviewerID, submissionID uint, result byte, reasons []int32, comment string) (models.KYCSubmission, models.User, error) {
+	var submission models.KYCSubmission
+	var user models.User
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 與 SubmitKYC 相同先鎖定使用者再鎖定送審紀錄，避免兩者同時執行時互相等待
+		err := tx.Select("user_id").First(&submission, submissionID).Error
+		if err != nil {
+			return err
+		}
 
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, submission.UserID).Error
+		if err != nil {
+			return err
+		}
+
+		submission, err = lockPendingKYCSubmission(tx, submissionID)
+		if err != nil {
+			return err
+		}
+
+		if submission.ReviewerID == 0 {
+			return ErrKYCNotClaimed
+		}
+
+		if submission.ReviewerID != reviewerID {
+			return ErrKYCClaimed
+		}
+
+		if user.Status != models.Auditing {
+			return ErrKYCNotPending
+		}
+
+		now := time.Now()
+		submission.Status = result
+		submission.ReviewedAt = &now
+		submission.RejectReasons = reasons
+		submission.RejectComment = comment
+
+		err = tx.Model(&submission).UpdateColumns(map[string]interface{}{
+			"status":         submission.Status,
+			"reviewed_at":    submission.ReviewedAt,
+			"reject_reasons": submission.RejectReasons,
+			"reject_comment": submission.RejectComment,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		user.Status = models.AuditFailed
+		if result == models.KYCApproved {
+			user.Status = models.AuditSuccess
+		}
+
+		return tx.Model(&user).UpdateColumn("status", user.Status).Error
+	})
+
+	return submission, user, err
+}
+
+func lockPendingKYCSubmission(tx *gorm.DB, submissionID uint) (models.KYCSubmission, error) {
+	var submission models.KYCSubmission
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&submission, submissionID).Error
 	if err != nil {
-		logrus.Error("Update kyc fail, err", err)
-		return err.Error
+		return submission, err
+	}
+
+	if submission.Status != models.KYCPending {
+		return submission, ErrKYCNotPending
+	}
+
+	return submission, nil
+}
+
+func assignKYCSubmission(tx *gorm.DB, submission *models.KYCSubmission, reviewerID uint) error {
+	now := time.Now()
+	submission.ReviewerID = reviewerID
+	submission.ClaimedAt = &now
+
+	return tx.Model(submission).UpdateColumns(map[string]interface{}{
+		"reviewer_id": submission.ReviewerID,
+		"claimed_at":  submission.ClaimedAt,
+	}).Error
+}
+
+// kycRejectReasonDescriptions 檢查審核失敗原因，選擇其他時必須填寫備註。
+func kycRejectReasonDescriptions(reasons []int32, comment string) ([]string, error) {
+	if len(reasons) == 0 {
+		return nil, ErrInvalidRejectReason
+	}
+
+	descriptions := make([]string, 0, len(reasons))
+	for _, code := range reasons {
+		found := false
+		for _, reason := range kycRejectReasons {
+			if reason.Code == code {
+				descriptions = append(descriptions, reason.Description)
+				found = true
+				break
+			}
+		}
+
+		if !found || (code == models.KYCRejectOther && comment == "") {
+			return nil, ErrInvalidRejectReason
+		}
 	}
 
-	return nil
+	return descriptions, nil
 }
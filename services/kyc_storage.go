@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/utils"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// defaultLegacyKYCDir 舊版直接存放加密檔案的目錄，僅供 MigrateStorage 搬移使用
+const defaultLegacyKYCDir = "./private/kyc"
+
+var ErrKYCMasterKeyMismatch = errors.New("kyc document was encrypted by another master key")
+
+var (
+	kycMasterKey   []byte
+	kycMasterKeyID string
+)
+
+// InitKYCStorage 載入 KYC_MASTER_KEY，加密後的檔案寫入 InitStorage 設定的儲存後端。
+// 主金鑰為 base64 編碼的 32 bytes，遺失後已加密的檔案將無法解密。
+func InitKYCStorage() {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("KYC_MASTER_KEY"))
+	if err != nil || len(key) != 32 {
+		panic("KYC_MASTER_KEY must be a base64 encoded 32 bytes key")
+	}
+
+	sum := sha256.Sum256(key)
+	kycMasterKey = key
+	kycMasterKeyID = hex.EncodeToString(sum[:8])
+
+	migrateLegacyKYCFiles()
+}
+
+// KYCFiles 一次送審上傳的實名照片。照片加密後先寫入儲存後端，
+// 與實名資料在同一個交易中建立紀錄，交易失敗時刪除已寫入的照片。
+type KYCFiles struct {
+	upload *Upload
+	docs   []models.KYCDocument
+}
+
+func NewKYCFiles() *KYCFiles {
+	return &KYCFiles{upload: NewUpload()}
+}
+
+// Stage 以新的資料金鑰加密並寫入照片，Commit 前不會建立紀錄
+func (f *KYCFiles) Stage(userID uint, kind, contentType string, src io.Reader) error {
+	storageKey, err := newKYCStorageKey()
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := utils.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := utils.WrapKey(kycMasterKey, dataKey, []byte(storageKey))
+	if err != nil {
+		return err
+	}
+
+	size, err := writeKYCFile(f.upload, storageKey, src, dataKey)
+	if err != nil {
+		return err
+	}
+
+	f.docs = append(f.docs, models.KYCDocument{
+		UserID:      userID,
+		Kind:        kind,
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  storageKey,
+		WrappedKey:  wrappedKey,
+		MasterKeyID: kycMasterKeyID,
+	})
+
+	return nil
+}
+
+// Rollback 刪除已寫入的照片
+func (f *KYCFiles) Rollback() {
+	f.upload.Rollback()
+}
+
+// commit 在交易中建立照片紀錄並填入 kyc 對應的欄位後執行 fn
+func (f *KYCFiles) commit(kyc *models.UserKYC, fn func(tx *gorm.DB) error) error {
+	return f.upload.Commit(func(tx *gorm.DB) error {
+		for i := range f.docs {
+			err := tx.Create(&f.docs[i]).Error
+			if err != nil {
+				return err
+			}
+
+			field := kycDocumentField(kyc, f.docs[i].Kind)
+			if field != nil {
+				*field = f.docs[i].ID
+			}
+		}
+
+		return fn(tx)
+	})
+}
+
+func GetKYCDocument(documentID uint) (models.KYCDocument, error) {
+	var doc models.KYCDocument
+	err := database.DB.First(&doc, documentID).Error
+	return doc, err
+}
+
+// KYCDocumentReader 已通過金鑰檢查並開啟的實名照片，呼叫端需 Close
+type KYCDocumentReader struct {
+	file    io.ReadCloser
+	dataKey []byte
+}
+
+// OpenKYCDocument 檢查主金鑰、解開資料金鑰並開啟檔案，讓呼叫端在寫出回應標頭前就能處理錯誤。
+// 檔案不存在時回傳 storage.ErrNotFound。
+func OpenKYCDocument(doc models.KYCDocument) (*KYCDocumentReader, error) {
+	if doc.MasterKeyID != kycMasterKeyID {
+		return nil, ErrKYCMasterKeyMismatch
+	}
+
+	dataKey, err := utils.UnwrapKey(kycMasterKey, doc.WrappedKey, []byte(doc.StorageKey))
+	if err != nil {
+		return nil, err
+	}
+
+	file, _, err := OpenStoredFile(kycObjectKey(doc.StorageKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &KYCDocumentReader{file: file, dataKey: dataKey}, nil
+}
+
+// Decrypt 逐塊解密檔案並寫入 dst，每塊都通過驗證後才輸出。
+func (r *KYCDocumentReader) Decrypt(dst io.Writer) error {
+	return utils.DecryptStream(dst, bufio.NewReader(r.file), r.dataKey)
+}
+
+func (r *KYCDocumentReader) Close() error {
+	return r.file.Close()
+}
+
+// LogKYCAccess 記錄讀取實名照片的角色與來源
+func LogKYCAccess(doc models.KYCDocument, roleType, roleID uint, ip, userAgent string) error {
+	log := models.KYCAccessLog{
+		DocumentID: doc.ID,
+		OwnerID:    doc.UserID,
+		RoleType:   roleType,
+		RoleID:     roleID,
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+
+	return database.DB.Create(&log).Error
+}
+
+// GetKYCAccessLogs 取得使用者實名照片的讀取紀錄，新的在前。
+func GetKYCAccessLogs(userID uint) ([]models.KYCAccessLog, error) {
+	logs := []models.KYCAccessLog{}
+	err := database.DB.Where("owner_id = ?", userID).Order("id DESC").Find(&logs).Error
+	return logs, err
+}
+
+// kycContentType 依副檔名判斷舊版實名照片的類型
+func kycContentType(fileName string) string {
+	return mime.TypeByExtension(strings.ToLower(path.Ext(fileName)))
+}
+
+// writeKYCFile 先加密到暫存檔，取得大小後再寫入儲存後端
+func writeKYCFile(upload *Upload, storageKey string, src io.Reader, dataKey []byte) (int64, error) {
+	file, err := os.CreateTemp("", "invar-kyc-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	counter := &countingReader{reader: src}
+	writer := bufio.NewWriter(file)
+	err = utils.EncryptStream(writer, counter, dataKey)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	err = upload.Put(kycObjectKey(storageKey), file, size, "application/octet-stream")
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.count, nil
+}
+
+func kycDocumentField(kyc *models.UserKYC, kind string) *uint {
+	switch kind {
+	case models.KYCPhoto:
+		return &kyc.PhotoID
+	case models.KYCIDPhotoFront:
+		return &kyc.IDPhotoFrontID
+	case models.KYCIDPhotoBack:
+		return &kyc.IDPhotoBackID
+	case models.KYCPhotoWithID:
+		return &kyc.PhotoWithIDID
+	}
+
+	return nil
+}
+
+// kycObjectKey StorageKey 是資料金鑰的驗證資料，不含前綴，寫入儲存後端時才加上
+func kycObjectKey(storageKey string) string {
+	return kycDocumentPrefix + storageKey
+}
+
+func newKYCStorageKey() (string, error) {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes) + ".enc", nil
+}
+
+// migrateLegacyKYCFiles 將舊版保存在 static 底下的明文照片加密搬移，完成後刪除明文檔案。
+func migrateLegacyKYCFiles() {
+	var kycs []models.UserKYC
+	err := database.DB.Where("photo <> '' OR id_photo_front <> '' OR id_photo_back <> '' OR photo_with_id <> ''").
+		Find(&kycs).Error
+	if err != nil {
+		logrus.Error("Find legacy kyc files fail=", err)
+		return
+	}
+
+	for _, kyc := range kycs {
+		fields := []struct {
+			kind   string
+			legacy *string
+		}{
+			{models.KYCPhoto, &kyc.Photo},
+			{models.KYCIDPhotoFront, &kyc.IDPhotoFront},
+			{models.KYCIDPhotoBack, &kyc.IDPhotoBack},
+			{models.KYCPhotoWithID, &kyc.PhotoWithID},
+		}
+
+		// 讀取失敗的照片保留舊路徑，下次再搬移
+		files := NewKYCFiles()
+		migrated := []string{}
+		for _, field := range fields {
+			if *field.legacy == "" {
+				continue
+			}
+
+			// 舊版部分路徑少了開頭的「.」
+			legacyPath := "." + strings.TrimPrefix(*field.legacy, ".")
+			err := stageLegacyKYCFile(files, kyc.UserID, field.kind, legacyPath)
+			if err != nil {
+				logrus.Error("Migrate legacy kyc file fail, kyc=", kyc.ID, ", err=", err)
+				continue
+			}
+
+			*field.legacy = ""
+			migrated = append(migrated, legacyPath)
+		}
+
+		err = files.commit(&kyc, func(tx *gorm.DB) error {
+			return tx.Model(&kyc).UpdateColumns(map[string]interface{}{
+				"photo_id":          kyc.PhotoID,
+				"id_photo_front_id": kyc.IDPhotoFrontID,
+				"id_photo_back_id":  kyc.IDPhotoBackID,
+				"photo_with_id_id":  kyc.PhotoWithIDID,
+				"photo":             kyc.Photo,
+				"id_photo_front":    kyc.IDPhotoFront,
+				"id_photo_back":     kyc.IDPhotoBack,
+				"photo_with_id":     kyc.PhotoWithID,
+			}).Error
+		})
+		if err != nil {
+			logrus.Error("Update migrated kyc fail, kyc=", kyc.ID, ", err=", err)
+			continue
+		}
+
+		for _, legacyPath := range migrated {
+			err = utils.RemoveFile(legacyPath)
+			if err != nil {
+				logrus.Error("Remove legacy kyc file fail=", err)
+			}
+		}
+	}
+}
+
+func stageLegacyKYCFile(files *KYCFiles, userID uint, kind, legacyPath string) error {
+	data, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return err
+	}
+
+	// 能解碼的照片重新編碼以移除 GPS 等中繼資料，無法解碼時保留原始檔案
+	image, err := utils.ProcessImage(data, ImageSupportTypes, math.MaxInt32, math.MaxInt32)
+	if err != nil {
+		return files.Stage(userID, kind, kycContentType(legacyPath), bytes.NewReader(data))
+	}
+
+	return files.Stage(userID, kind, image.ContentType, bytes.NewReader(image.Data))
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
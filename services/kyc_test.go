@@ -0,0 +1,32 @@
+package services
+
+import (
+	"invar/models"
+	"testing"
+)
+
+func TestKYCRejectReasonDescriptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		reasons []int32
+		comment string
+		wantErr bool
+	}{
+		{"no reason", nil, "", true},
+		{"unknown reason", []int32{0}, "", true},
+		{"known reasons", []int32{models.KYCRejectBlurryPhoto, models.KYCRejectNameMismatch}, "", false},
+		{"other without comment", []int32{models.KYCRejectOther}, "", true},
+		{"other with comment", []int32{models.KYCRejectOther}, "證件被遮擋", false},
+	}
+
+	for _, tt := range tests {
+		descriptions, err := kycRejectReasonDescriptions(tt.reasons, tt.comment)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+
+		if err == nil && len(descriptions) != len(tt.reasons) {
+			t.Fatalf("%s: got %d descriptions, want %d", tt.name, len(descriptions), len(tt.reasons))
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"sort"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientBalance = errors.New(status.ErrorText(status.InsufficientBalance))
+var ErrUnbalancedEntry = errors.New("journal entry is unbalanced")
+
+type LedgerLeg struct {
+	UserID  uint
+	Account string
+	Asset   string
+	Debit   decimal.Decimal
+	Credit  decimal.Decimal
+}
+
+func DebitUser(userID uint, asset string, amount decimal.Decimal) LedgerLeg {
+	return LedgerLeg{UserID: userID, Account: models.LedgerWallet, Asset: asset, Debit: amount}
+}
+
+func CreditUser(userID uint, asset string, amount decimal.Decimal) LedgerLeg {
+	return LedgerLeg{UserID: userID, Account: models.LedgerWallet, Asset: asset, Credit: amount}
+}
+
+func DebitSystem(account string, asset string, amount decimal.Decimal) LedgerLeg {
+	return LedgerLeg{Account: account, Asset: asset, Debit: amount}
+}
+
+func CreditSystem(account string, asset string, amount decimal.Decimal) LedgerLeg {
+	return LedgerLeg{Account: account, Asset: asset, Credit: amount}
+}
+
+func (leg LedgerLeg) isUserWallet() bool {
+	return leg.UserID != 0 && leg.Account == models.LedgerWallet
+}
+
+func (leg LedgerLeg) key() string {
+	return fmt.Sprintf("%010d|%s|%s", leg.UserID, leg.Account, leg.Asset)
+}
+
+// PostJournalEntry 必須在交易內呼叫，寫入分錄後會同步更新使用者 Bank 的餘額。
+func PostJournalEntry(tx *gorm.DB, entryType byte, referenceID uint, comment string, legs []LedgerLeg) (models.JournalEntry, error) {
+	entry := models.JournalEntry{
+		Type:        entryType,
+		ReferenceID: referenceID,
+		Comment:     comment,
+	}
+
+	err := checkLegsBalanced(legs)
+	if err != nil {
+		return entry, err
+	}
+
+	// 依固定順序鎖定帳戶，避免交易互相死結
+	sorted := make([]LedgerLeg, len(legs))
+	copy(sorted, legs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].key() < sorted[j].key()
+	})
+
+	accounts := make(map[string]*models.LedgerAccount)
+	for _, leg := range sorted {
+		if _, ok := accounts[leg.key()]; ok {
+			continue
+		}
+		account, err := lockLedgerAccount(tx, leg.UserID, leg.Account, leg.Asset)
+		if err != nil {
+			return entry, err
+		}
+		accounts[leg.key()] = &account
+	}
+
+	err = tx.Create(&entry).Error
+	if err != nil {
+		return entry, err
+	}
+
+	for _, leg := range legs {
+		account := accounts[leg.key()]
+		account.Balance = account.Balance.Add(leg.Debit).Sub(leg.Credit)
+		if leg.isUserWallet() && account.Balance.IsNegative() {
+			return entry, ErrInsufficientBalance
+		}
+
+		posting := models.Posting{
+			JournalEntryID: entry.ID,
+			AccountID:      account.ID,
+			Asset:          leg.Asset,
+			Debit:          leg.Debit,
+			Credit:         leg.Credit,
+			BalanceAfter:   account.Balance,
+		}
+		err = tx.Create(&posting).Error
+		if err != nil {
+			return entry, err
+		}
+		entry.Postings = append(entry.Postings, posting)
+	}
+
+	for _, leg := range sorted {
+		account := accounts[leg.key()]
+		err = tx.Model(account).UpdateColumn("balance", account.Balance).Error
+		if err != nil {
+			return entry, err
+		}
+
+		if leg.isUserWallet() {
+			err = syncBankBalance(tx, account)
+			if err != nil {
+				return entry, err
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+func checkLegsBalanced(legs []LedgerLeg) error {
+	if len(legs) < 2 {
+		return ErrUnbalancedEntry
+	}
+
+	sums := make(map[string]decimal.Decimal)
+	for _, leg := range legs {
+		if leg.Debit.IsNegative() || leg.Credit.IsNegative() {
+			return ErrUnbalancedEntry
+		}
+		if leg.Debit.IsZero() == leg.Credit.IsZero() {
+			return ErrUnbalancedEntry
+		}
+		sums[leg.Asset] = sums[leg.Asset].Add(leg.Debit).Sub(leg.Credit)
+	}
+
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	return nil
+}
+
+func lockLedgerAccount(tx *gorm.DB, userID uint, name, asset string) (models.LedgerAccount, error) {
+	account := models.LedgerAccount{
+		UserID:  userID,
+		Name:    name,
+		Asset:   asset,
+		Balance: decimal.Zero,
+	}
+
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error
+	if err != nil {
+		return account, err
+	}
+
+	account = models.LedgerAccount{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND name = ? AND asset = ?", userID, name, asset).
+		First(&account).Error
+	if err != nil {
+		return account, err
+	}
+
+	return account, nil
+}
+
+func syncBankBalance(tx *gorm.DB, account *models.LedgerAccount) error {
+	var column string
+	switch account.Asset {
+	case models.AssetIVT:
+		column = "InVarCoin"
+	case models.AssetUSDT:
+		column = "USDTCoin"
+	default:
+		// Token 的數量由 Token 資料表自行維護
+		return nil
+	}
+
+	bank, err := getOrCreateBank(tx, account.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&bank).UpdateColumn(column, account.Balance).Error
+}
+
+func getOrCreateBank(tx *gorm.DB, userID uint) (models.Bank, error) {
+	var bank models.Bank
+
+	result := tx.Where("user_id = ?", userID).Limit(1).Find(&bank)
+	if result.Error != nil {
+		return bank, result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		return bank, nil
+	}
+
+	bank = models.Bank{
+		UserID:    userID,
+		InVarCoin: decimal.Zero,
+		USDTCoin:  decimal.Zero,
+	}
+	err := tx.Create(&bank).Error
+	return bank, err
+}
+
+func GetLedgerAccounts(userID uint) ([]models.LedgerAccount, error) {
+	var accounts []models.LedgerAccount
+
+	result := database.DB.Where("user_id = ?", userID).Order("asset").Find(&accounts)
+	if result.Error != nil {
+		return accounts, result.Error
+	}
+
+	return accounts, nil
+}
+
+func GetLedgerEntries(userID uint) ([]models.JournalEntry, error) {
+	var entries []models.JournalEntry
+
+	accountIDs := database.DB.Model(&models.LedgerAccount{}).Select("id").Where("user_id = ?", userID)
+	entryIDs := database.DB.Model(&models.Posting{}).Select("journal_entry_id").Where("account_id IN (?)", accountIDs)
+
+	result := database.DB.Preload("Postings").Where("id IN (?)", entryIDs).Order("id desc").Find(&entries)
+	if result.Error != nil {
+		return entries, result.Error
+	}
+
+	return entries, nil
+}
+
+type LedgerReconciliation struct {
+	Asset            string          `json:"asset"`
+	PostingBalance   decimal.Decimal `json:"posting_balance"`
+	AccountBalance   decimal.Decimal `json:"account_balance"`
+	ProjectedBalance decimal.Decimal `json:"projected_balance"`
+	Matched          bool            `json:"matched"`
+}
+
+// ReconcileUserLedger 由分錄重新計算餘額，並與帳戶快取和 Bank/Token 的餘額比對。
+func ReconcileUserLedger(userID uint) ([]LedgerReconciliation, error) {
+	results := make([]LedgerReconciliation, 0)
+
+	bank, err := GetBank(userID)
+	if err != nil {
+		return results, err
+	}
+
+	projections := map[string]decimal.Decimal{
+		models.AssetIVT:  bank.InVarCoin,
+		models.AssetUSDT: bank.USDTCoin,
+	}
+
+	if bank.ID != 0 {
+		var tokenSums []struct {
+			ProductID uint
+			Quantity  decimal.Decimal
+		}
+		err = database.DB.Model(&models.Token{}).
+			Select("product_id, SUM(quantity) AS quantity").
+			Where("bank_id = ?", bank.ID).
+			Group("product_id").
+			Scan(&tokenSums).Error
+		if err != nil {
+			return results, err
+		}
+
+		for _, v := range tokenSums {
+			projections[models.TokenAsset(v.ProductID)] = v.Quantity
+		}
+	}
+
+	accounts, err := GetLedgerAccounts(userID)
+	if err != nil {
+		return results, err
+	}
+
+	accountMap := make(map[string]models.LedgerAccount)
+	for _, account := range accounts {
+		if account.Name == models.LedgerWallet {
+			accountMap[account.Asset] = account
+		}
+	}
+
+	assets := make([]string, 0)
+	for asset := range projections {
+		assets = append(assets, asset)
+	}
+	for asset := range accountMap {
+		if _, ok := projections[asset]; !ok {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets)
+
+	for _, asset := range assets {
+		reconciliation := LedgerReconciliation{
+			Asset:            asset,
+			ProjectedBalance: projections[asset],
+		}
+
+		if account, ok := accountMap[asset]; ok {
+			var sum struct {
+				Debit  decimal.Decimal
+				Credit decimal.Decimal
+			}
+			err = database.DB.Model(&models.Posting{}).
+				Select("COALESCE(SUM(debit), 0) AS debit, COALESCE(SUM(credit), 0) AS credit").
+				Where("account_id = ?", account.ID).
+				Scan(&sum).Error
+			if err != nil {
+				return results, err
+			}
+
+			reconciliation.PostingBalance = sum.Debit.Sub(sum.Credit)
+			reconciliation.AccountBalance = account.Balance
+		}
+
+		reconciliation.Matched = reconciliation.PostingBalance.Equal(reconciliation.AccountBalance) &&
+			reconciliation.PostingBalance.Equal(reconciliation.ProjectedBalance)
+		results = append(results, reconciliation)
+	}
+
+	return results, nil
+}
+
+// InitLedgerOpeningBalances 將帳本建立前就存在的 Bank 餘額與 Token 數量補上期初分錄，只會執行一次。
+func InitLedgerOpeningBalances() {
+	database.RunDataMigration("ledger_opening_balances", postLedgerOpeningBalances)
+}
+
+// postLedgerOpeningBalances 已經有錢包帳戶的使用者代表帳本已在使用中，不再補期初分錄
+func postLedgerOpeningBalances(tx *gorm.DB) error {
+	var banks []models.Bank
+
+	err := tx.Preload("Tokens").Order("id").Find(&banks).Error
+	if err != nil {
+		return err
+	}
+
+	for _, bank := range banks {
+		balances := map[string]decimal.Decimal{
+			models.AssetIVT:  bank.InVarCoin,
+			models.AssetUSDT: bank.USDTCoin,
+		}
+		for _, token := range bank.Tokens {
+			asset := models.TokenAsset(token.ProductID)
+			balances[asset] = balances[asset].Add(token.Quantity)
+		}
+
+		for asset, balance := range balances {
+			if !balance.IsPositive() {
+				continue
+			}
+
+			var count int64
+			err = tx.Model(&models.LedgerAccount{}).
+				Where("user_id = ? AND name = ? AND asset = ?", bank.UserID, models.LedgerWallet, asset).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+
+			_, err = PostJournalEntry(tx, models.JournalOpeningBalance, bank.ID, "opening balance", []LedgerLeg{
+				DebitUser(bank.UserID, asset, balance),
+				CreditSystem(models.LedgerOpeningBalance, asset, balance),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"invar/models"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckLegsBalanced(t *testing.T) {
+	ten := decimal.NewFromInt(10)
+	five := decimal.NewFromInt(5)
+
+	cases := []struct {
+		name  string
+		legs  []LedgerLeg
+		valid bool
+	}{
+		{"balanced", []LedgerLeg{
+			DebitUser(1, models.AssetIVT, ten),
+			CreditSystem(models.LedgerStackProfit, models.AssetIVT, ten),
+		}, true},
+		{"single leg", []LedgerLeg{
+			DebitUser(1, models.AssetIVT, ten),
+		}, false},
+		{"amount mismatch", []LedgerLeg{
+			DebitUser(1, models.AssetIVT, ten),
+			CreditSystem(models.LedgerStackProfit, models.AssetIVT, five),
+		}, false},
+		{"asset mismatch", []LedgerLeg{
+			DebitUser(1, models.AssetIVT, ten),
+			CreditSystem(models.LedgerStackProfit, models.AssetUSDT, ten),
+		}, false},
+		{"zero leg", []LedgerLeg{
+			DebitUser(1, models.AssetIVT, decimal.Zero),
+			CreditSystem(models.LedgerStackProfit, models.AssetIVT, decimal.Zero),
+		}, false},
+		{"multi asset", []LedgerLeg{
+			CreditUser(1, models.TokenAsset(2), five),
+			DebitSystem(models.LedgerStackProfit, models.TokenAsset(2), five),
+			DebitUser(1, models.AssetIVT, ten),
+			CreditSystem(models.LedgerStackProfit, models.AssetIVT, ten),
+		}, true},
+	}
+
+	for _, v := range cases {
+		err := checkLegsBalanced(v.legs)
+		if (err == nil) != v.valid {
+			t.Errorf("%s: expected valid=%v, got err=%v", v.name, v.valid, err)
+		}
+	}
+}
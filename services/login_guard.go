@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"invar/database"
+	"invar/utils"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultLoginFailWindow       = 15 * time.Minute
+	defaultLoginLockoutDuration  = 30 * time.Minute
+	defaultLoginMaxAccountFailed = 5
+	defaultLoginMaxIPFailed      = 20
+	// 連續失敗達到此次數後開始要求等待，每次失敗等待時間加倍
+	loginDelayAfterFailures = 2
+	maxLoginDelay           = time.Minute
+)
+
+var (
+	ErrLoginLocked    = errors.New("login locked")
+	ErrLoginDelayed   = errors.New("login delayed")
+	ErrUnlockInvalid  = errors.New("unlock token is invalid")
+	dummyPasswordOnce sync.Once
+	dummyPassword     []byte
+)
+
+// LoginLockout 帳號因連續登入失敗而暫時鎖定的紀錄。
+type LoginLockout struct {
+	RoleType  uint      `json:"role_type"`
+	Account   string    `json:"account"`
+	IP        string    `json:"ip"`
+	Failures  int64     `json:"failures"`
+	LockedAt  time.Time `json:"locked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CheckLoginAttempt 檢查帳號與IP是否允許登入，被拒絕時回傳需要等待的時間。
+func CheckLoginAttempt(roleType uint, account, ip string) (time.Duration, error) {
+	var ctx = context.Background()
+	account = normalizeLoginAccount(account)
+
+	ttl, err := database.RDS.TTL(ctx, loginLockKey(roleType, account)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		return ttl, ErrLoginLocked
+	}
+
+	ipFailures, err := countLoginFailures(ctx, loginIPFailKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if ipFailures >= int64(loginLimit("LOGIN_MAX_IP_FAILURES", defaultLoginMaxIPFailed)) {
+		return loginFailWindow(), ErrLoginLocked
+	}
+
+	ttl, err = database.RDS.PTTL(ctx, loginDelayKey(roleType, account)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		return ttl, ErrLoginDelayed
+	}
+
+	return 0, nil
+}
+
+// RecordLoginFailure 記錄一次登入失敗，達到上限時鎖定帳號並寄出解鎖信。
+func RecordLoginFailure(roleType uint, account, ip string) {
+	var ctx = context.Background()
+	account = normalizeLoginAccount(account)
+
+	_, err := addLoginFailure(ctx, loginIPFailKey(ip))
+	if err != nil {
+		logrus.Error("Record login failure fail=", err)
+	}
+
+	failures, err := addLoginFailure(ctx, loginAccountFailKey(roleType, account))
+	if err != nil {
+		logrus.Error("Record login failure fail=", err)
+		return
+	}
+
+	if failures >= int64(loginLimit("LOGIN_MAX_ACCOUNT_FAILURES", defaultLoginMaxAccountFailed)) {
+		lockLoginAccount(ctx, roleType, account, ip, failures)
+		return
+	}
+
+	if failures >= loginDelayAfterFailures {
+		delay := time.Second << (failures - loginDelayAfterFailures)
+		if delay > maxLoginDelay {
+			delay = maxLoginDelay
+		}
+
+		err = database.RDS.Set(ctx, loginDelayKey(roleType, account), 1, delay).Err()
+		if err != nil {
+			logrus.Error("Set login delay fail=", err)
+		}
+	}
+}
+
+// ResetLoginFailures 登入成功後清除帳號的失敗紀錄。
+func ResetLoginFailures(roleType uint, account string) {
+	var ctx = context.Background()
+	account = normalizeLoginAccount(account)
+
+	err := database.RDS.Del(ctx, loginAccountFailKey(roleType, account), loginDelayKey(roleType, account)).Err()
+	if err != nil {
+		logrus.Error("Reset login failures fail=", err)
+	}
+}
+
+// CompareDummyPassword 帳號不存在時仍比對一次密碼，避免以回應時間判斷帳號是否存在。
+func CompareDummyPassword(password string) {
+	dummyPasswordOnce.Do(func() {
+		dummyPassword, _ = bcrypt.GenerateFromPassword([]byte("invar-dummy-password"), 12)
+	})
+
+	_ = bcrypt.CompareHashAndPassword(dummyPassword, []byte(password))
+}
+
+// UnlockLoginAccount 以解鎖信中的令牌解除帳號鎖定。
+func UnlockLoginAccount(token string) error {
+	var ctx = context.Background()
+
+	result, err := database.RDS.GetDel(ctx, loginUnlockKey(token)).Result()
+	if err == redis.Nil {
+		return ErrUnlockInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(result, ":", 2)
+	if len(parts) != 2 {
+		return ErrUnlockInvalid
+	}
+
+	roleType, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ErrUnlockInvalid
+	}
+
+	return ClearLoginLockout(uint(roleType), parts[1])
+}
+
+// GetLoginLockouts 取得目前所有被鎖定的帳號。
+func GetLoginLockouts() ([]LoginLockout, error) {
+	var ctx = context.Background()
+	lockouts := []LoginLockout{}
+
+	iter := database.RDS.Scan(ctx, 0, database.LoginLockCache+":*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		result, err := database.RDS.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var lockout LoginLockout
+		err = json.Unmarshal([]byte(result), &lockout)
+		if err != nil {
+			logrus.Error("Unmarshal login lockout fail=", err)
+			continue
+		}
+
+		lockouts = append(lockouts, lockout)
+	}
+
+	return lockouts, iter.Err()
+}
+
+// ClearLoginLockout 解除帳號鎖定並清除失敗紀錄。
+func ClearLoginLockout(roleType uint, account string) error {
+	var ctx = context.Background()
+	account = normalizeLoginAccount(account)
+
+	return database.RDS.Del(ctx,
+		loginLockKey(roleType, account),
+		loginAccountFailKey(roleType, account),
+		loginDelayKey(roleType, account),
+	).Err()
+}
+
+// ClearIPLoginFailures 清除IP的登入失敗紀錄。
+func ClearIPLoginFailures(ip string) error {
+	var ctx = context.Background()
+	return database.RDS.Del(ctx, loginIPFailKey(ip)).Err()
+}
+
+func lockLoginAccount(ctx context.Context, roleType uint, account, ip string, failures int64) {
+	duration := loginDuration("LOGIN_LOCKOUT_DURATION", defaultLoginLockoutDuration)
+	now := time.Now()
+
+	bytes, err := json.Marshal(LoginLockout{
+		RoleType:  roleType,
+		Account:   account,
+		IP:        ip,
+		Failures:  failures,
+		LockedAt:  now,
+		ExpiresAt: now.Add(duration),
+	})
+	if err != nil {
+		logrus.Error("Marshal login lockout fail=", err)
+		return
+	}
+
+	ok, err := database.RDS.SetNX(ctx, loginLockKey(roleType, account), bytes, duration).Result()
+	if err != nil {
+		logrus.Error("Set login lockout fail=", err)
+		return
+	}
+
+	// 鎖定期間的失敗不重複寄信
+	if !ok {
+		return
+	}
+
+	logrus.Warnf("Login locked, role_type=%d account=%s ip=%s", roleType, account, ip)
+	sendUnlockEmail(ctx, roleType, account, duration)
+}
+
+// sendUnlockEmail 只有使用者帳號為信箱，管理者帳號只能等待鎖定到期或由其他管理者解除。
+func sendUnlockEmail(ctx context.Context, roleType uint, account string, duration time.Duration) {
+	if roleType != roleTypeUser {
+		return
+	}
+
+	user, err := GetUserByEmail(account)
+	if err != nil {
+		return
+	}
+
+	token, err := utils.GenerateRefreshToken(32)
+	if err != nil {
+		logrus.Error("Generate unlock token fail=", err)
+		return
+	}
+
+	field := strconv.Itoa(int(roleType)) + ":" + account
+	err = database.RDS.Set(ctx, loginUnlockKey(token), field, duration).Err()
+	if err != nil {
+		logrus.Error("Set unlock token fail=", err)
+		return
+	}
+
+	url := os.Getenv("BASE_URL") + "api/v1/unlock_account?token=" + token
+	minutes := strconv.Itoa(int((duration + time.Minute - 1) / time.Minute))
+	text := "您的帳號因登入失敗次數過多已被暫時鎖定，將於" + minutes + "分鐘後自動解除。" +
+		"若是您本人操作，可點擊以下連結立即解除鎖定：" + url +
+		" 若不是您本人操作，請盡快變更密碼。"
+	go utils.SendEmail(user.Email, "InVar帳號已鎖定", text)
+}
+
+func addLoginFailure(ctx context.Context, key string) (int64, error) {
+	now := time.Now()
+	window := loginFailWindow()
+
+	pipe := database.RDS.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return count.Val(), nil
+}
+
+func countLoginFailures(ctx context.Context, key string) (int64, error) {
+	min := strconv.FormatInt(time.Now().Add(-loginFailWindow()).UnixNano(), 10)
+	return database.RDS.ZCount(ctx, key, min, "+inf").Result()
+}
+
+func normalizeLoginAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func loginAccountFailKey(roleType uint, account string) string {
+	return database.LoginFailCache + ":account:" + strconv.Itoa(int(roleType)) + ":" + account
+}
+
+func loginIPFailKey(ip string) string {
+	return database.LoginFailCache + ":ip:" + ip
+}
+
+func loginDelayKey(roleType uint, account string) string {
+	return database.LoginDelayCache + ":" + strconv.Itoa(int(roleType)) + ":" + account
+}
+
+func loginLockKey(roleType uint, account string) string {
+	return database.LoginLockCache + ":" + strconv.Itoa(int(roleType)) + ":" + account
+}
+
+func loginUnlockKey(token string) string {
+	return database.LoginUnlockCache + ":" + utils.HashToken(token)
+}
+
+func loginFailWindow() time.Duration {
+	return loginDuration("LOGIN_FAIL_WINDOW", defaultLoginFailWindow)
+}
+
+func loginDuration(name string, fallback time.Duration) time.Duration {
+	return utils.EnvDuration(name, fallback)
+}
+
+func loginLimit(name string, fallback int) int {
+	return utils.EnvInt(name, fallback)
+}
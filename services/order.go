@@ -6,17 +6,53 @@ import (
 	"invar/models"
 	"invar/status"
 	"invar/utils"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrOrderCannotCancel = errors.New(status.ErrorText(status.OrderCannotCancel))
+var ErrOrderCannotPay = errors.New(status.ErrorText(status.OrderCannotPay))
+var ErrOrderCannotFail = errors.New(status.ErrorText(status.OrderCannotFail))
+var ErrOrderCannotComplete = errors.New(status.ErrorText(status.OrderCannotComplete))
+var ErrOrderCannotRefund = errors.New(status.ErrorText(status.OrderCannotRefund))
+var ErrDuplicateTransaction = errors.New(status.ErrorText(status.DuplicateTransaction))
+var ErrTokenNotRefundable = errors.New(status.ErrorText(status.TokenNotRefundable))
+
+var orderTransitions = map[byte][]byte{
+	models.Ordered:            {models.Cancel, models.WaitConfirmPayment},
+	models.WaitConfirmPayment: {models.Completed, models.PaymentFailed},
+	models.PaymentFailed:      {models.WaitConfirmPayment, models.Cancel},
+	models.Completed:          {models.Refunded},
+}
+
+var orderTransitionErrors = map[byte]error{
+	models.Cancel:             ErrOrderCannotCancel,
+	models.WaitConfirmPayment: ErrOrderCannotPay,
+	models.PaymentFailed:      ErrOrderCannotFail,
+	models.Completed:          ErrOrderCannotComplete,
+	models.Refunded:           ErrOrderCannotRefund,
+}
+
+// OrderActor 觸發訂單狀態變更的角色，RoleType 為 0 時代表系統。
+type OrderActor struct {
+	RoleType uint
+	RoleID   uint
+	IP       string
+}
+
+var SystemActor = OrderActor{}
+
 func GetOrders(userID uint) ([]models.Order, error) {
 	var orders []models.Order
 
-	result := database.DB.Preload("OrderItem").Where("user_id = ?", userID).Find(&orders)
+	result := database.DB.Preload("OrderItems").Preload("StatusHistories").Where("user_id = ?", userID).Find(&orders)
 	if result.Error != nil {
 		logrus.Error("Get Orders fail=", result.Error)
 	}
@@ -44,7 +80,7 @@ func GetOrdersByAdmin(serial, email, username string, createAt time.Time) ([]mod
 		result = result.Where("create_at = ?", createAt)
 	}
 
-	result = result.Preload("OrderItems").Find(&orders)
+	result = result.Preload("OrderItems").Preload("StatusHistories").Find(&orders)
 
 	if result.Error != nil {
 		logrus.Error("Get Orders fail=", result.Error)
@@ -55,17 +91,19 @@ func GetOrdersByAdmin(serial, email, username string, createAt time.Time) ([]mod
 
 func GetOrder(key uint) (models.Order, error) {
 	order := models.Order{}
-	order.ID = key
 
-	result := database.DB.Preload("OrderItem").Find(&order)
+	result := database.DB.Preload("OrderItems").Preload("StatusHistories").Where("id = ?", key).First(&order)
 	if result.Error != nil {
-		logrus.Error("Get Orders fail=", result.Error)
+		return order, result.Error
 	}
 
 	return order, nil
 }
 
-func AddOrder(userID uint, orderItems []models.OrderItem) error {
+var ErrOutOfStock = errors.New(status.ErrorText(status.OutOfStock))
+
+// AddOrder 在同一個交易內以條件式更新扣除庫存並建立訂單，庫存不足時整筆訂單失敗。
+func AddOrder(userID uint, orderItems []models.OrderItem, actor OrderActor) (models.Order, int) {
 	var order = models.Order{
 		UserID:           userID,
 		Status:           models.Ordered,
@@ -74,91 +112,418 @@ func AddOrder(userID uint, orderItems []models.OrderItem) error {
 		PaymentLimitTime: time.Now().Add(time.Hour * 24 * 7),
 	}
 
-	var products = make([]models.Product, 0)
-	for _, v := range orderItems {
-		product, err := GetProduct(v.ProductID)
-		if err != nil {
-			return err
-		}
-		if product.Stock < v.Quantity {
-			return errors.New(status.ErrorText(status.OutOfStock))
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, v := range sortItemsByProduct(orderItems) {
+			err := reserveStock(tx, v.ProductID, v.Quantity)
+			if err != nil {
+				return err
+			}
 		}
-		product.Stock -= v.Quantity
-		products = append(products, product)
-	}
 
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
 		err := tx.Create(&order).Error
 		if err != nil {
 			return err
 		}
 		code := utils.GenerateOrderSerialCode(order.ID)
 		order.Serial = code
-		err = tx.Updates(&order).Error
+		err = tx.Model(&order).UpdateColumn("serial", order.Serial).Error
 		if err != nil {
 			return err
 		}
+
+		return addOrderStatusHistory(tx, &order, 0, actor, "")
+	})
+
+	if err == ErrOutOfStock {
+		return order, status.OutOfStock
+	}
+
+	if err != nil {
+		logrus.Error("Add Orders fail=", err)
+		return order, status.Unkonwn
+	}
+
+	return order, status.Success
+}
+
+func CancelOrder(order *models.Order, actor OrderActor, reason string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitOrder(tx, order, models.Cancel, actor, reason)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range sortItemsByProduct(order.OrderItems) {
+			err = releaseStock(tx, v.ProductID, v.Quantity)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 
-	logrus.Error("Add Orders fail=", err)
-	return err
+	return orderErrorCode(err)
 }
 
-func UpdateOrder(order *models.Order) error {
-	result := database.DB.Updates(&order)
+// sortItemsByProduct 依商品 ID 排序後再更新庫存，多張訂單同時更新相同商品時以相同順序鎖定，避免死鎖。
+func sortItemsByProduct(orderItems []models.OrderItem) []models.OrderItem {
+	sorted := make([]models.OrderItem, len(orderItems))
+	copy(sorted, orderItems)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ProductID < sorted[j].ProductID })
+	return sorted
+}
 
+// reserveStock 只在庫存足夠且商品上架時扣除，避免並發下單時超賣。
+func reserveStock(tx *gorm.DB, productID uint, quantity uint) error {
+	result := tx.Model(&models.Product{}).
+		Where("id = ? AND status = ? AND stock >= ?", productID, true, quantity).
+		UpdateColumn("stock", gorm.Expr("stock - ?", quantity))
 	if result.Error != nil {
-		logrus.Error("Get Orders fail=", result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrOutOfStock
 	}
 
 	return nil
 }
 
-func CancelOrder(order *models.Order) error {
-	order.Status = models.Cancel
+func releaseStock(tx *gorm.DB, productID uint, quantity uint) error {
+	return tx.Model(&models.Product{}).
+		Where("id = ?", productID).
+		UpdateColumn("stock", gorm.Expr("stock + ?", quantity)).Error
+}
+
+// PaymentOrder 記錄付款的交易序號，同一筆交易不能用於多張訂單，付款結果由 VerifyPendingPayments 自動確認。
+func PaymentOrder(order *models.Order, transactionChain, transactionID string, actor OrderActor) int {
+	transactionChain = strings.ToUpper(strings.TrimSpace(transactionChain))
+	transactionID = strings.ToLower(strings.TrimSpace(transactionID))
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitOrder(tx, order, models.WaitConfirmPayment, actor, "")
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		err = tx.Model(&models.Order{}).
+			Where("transaction_chain = ? AND transaction_id = ? AND id <> ?", transactionChain, transactionID, order.ID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrDuplicateTransaction
+		}
+
+		order.TransactionChain = transactionChain
+		order.TransactionID = transactionID
+		err = tx.Model(order).Updates(map[string]interface{}{
+			"transaction_chain": order.TransactionChain,
+			"transaction_id":    order.TransactionID,
+		}).Error
+		// 同時送出相同交易序號時，上方的查詢都看不到對方，由唯一索引擋下
+		if isUniqueViolation(err) {
+			return ErrDuplicateTransaction
+		}
+		return err
+	})
+
+	return orderErrorCode(err)
+}
+
+// CompletedOrder 完成訂單並在同一個交易內將購買的代幣發放到使用者的 Bank。
+func CompletedOrder(order *models.Order, actor OrderActor, reason string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitOrder(tx, order, models.Completed, actor, reason)
+		if err != nil {
+			return err
+		}
+
+		err = issueOrderTokens(tx, order)
+		if err != nil {
+			return err
+		}
+
+		return payReferralCommissions(tx, order)
+	})
+
+	return orderErrorCode(err)
+}
+
+// RefundOrder 退款已完成的訂單，收回已發放的代幣與推薦獎勵並歸還庫存，代幣已被質押時無法退款。
+func RefundOrder(order *models.Order, actor OrderActor, reason string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := transitOrder(tx, order, models.Refunded, actor, reason)
+		if err != nil {
+			return err
+		}
+
+		err = revokeOrderTokens(tx, order)
+		if err != nil {
+			return err
+		}
+
+		err = revertReferralCommissions(tx, order)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range sortItemsByProduct(order.OrderItems) {
+			err = releaseStock(tx, v.ProductID, v.Quantity)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return orderErrorCode(err)
+}
+
+func issueOrderTokens(tx *gorm.DB, order *models.Order) error {
+	err := loadOrderItems(tx, order)
+	if err != nil {
+		return err
+	}
+
+	bank, err := getOrCreateBank(tx, order.UserID)
+	if err != nil {
+		return err
+	}
 
-	var products = make([]models.Product, 0)
 	for _, v := range order.OrderItems {
-		product, err := GetProduct(v.ProductID)
+		var product models.Product
+		err = tx.Where("id = ?", v.ProductID).First(&product).Error
+		if err != nil {
+			return err
+		}
+
+		quantity := decimal.NewFromInt(int64(v.Quantity))
+
+		var token models.Token
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bank_id = ? AND product_id = ? AND status = ?", bank.ID, product.ID, models.Unlock).
+			Limit(1).
+			Find(&token)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected > 0 {
+			err = tx.Model(&token).UpdateColumn("quantity", token.Quantity.Add(quantity)).Error
+		} else {
+			token = models.Token{
+				BankID:          bank.ID,
+				Status:          models.Unlock,
+				ProductID:       product.ID,
+				ContractChain:   product.ContractChain,
+				ContractType:    product.ContractType,
+				ContractAddress: product.ContractAddress,
+				Quantity:        quantity,
+			}
+			err = tx.Create(&token).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		asset := models.TokenAsset(product.ID)
+		_, err = PostJournalEntry(tx, models.JournalTokenIssue, order.ID, order.Serial, []LedgerLeg{
+			DebitUser(order.UserID, asset, quantity),
+			CreditSystem(models.LedgerTokenIssuance, asset, quantity),
+		})
 		if err != nil {
 			return err
 		}
-		product.Stock += v.Quantity
-		products = append(products, product)
 	}
 
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Updates(&products).Error
+	return nil
+}
+
+func revokeOrderTokens(tx *gorm.DB, order *models.Order) error {
+	err := loadOrderItems(tx, order)
+	if err != nil {
+		return err
+	}
+
+	bank, err := getOrCreateBank(tx, order.UserID)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range order.OrderItems {
+		quantity := decimal.NewFromInt(int64(v.Quantity))
+
+		var token models.Token
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bank_id = ? AND product_id = ? AND status = ?", bank.ID, v.ProductID, models.Unlock).
+			Limit(1).
+			Find(&token)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 || token.Quantity.LessThan(quantity) {
+			return ErrTokenNotRefundable
+		}
+
+		err = tx.Model(&token).UpdateColumn("quantity", token.Quantity.Sub(quantity)).Error
 		if err != nil {
 			return err
 		}
 
-		err = tx.Updates(&order).Error
+		asset := models.TokenAsset(v.ProductID)
+		_, err = PostJournalEntry(tx, models.JournalTokenRevoke, order.ID, order.Serial, []LedgerLeg{
+			CreditUser(order.UserID, asset, quantity),
+			DebitSystem(models.LedgerTokenIssuance, asset, quantity),
+		})
+		if err == ErrInsufficientBalance {
+			return ErrTokenNotRefundable
+		}
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+func loadOrderItems(tx *gorm.DB, order *models.Order) error {
+	if len(order.OrderItems) > 0 {
 		return nil
+	}
+
+	return tx.Where("order_id = ?", order.ID).Find(&order.OrderItems).Error
+}
+
+func FailOrderPayment(order *models.Order, actor OrderActor, reason string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return transitOrder(tx, order, models.PaymentFailed, actor, reason)
 	})
 
-	return err
+	return orderErrorCode(err)
 }
 
-func CompletedOrder(order *models.Order) error {
-	order.Status = models.Completed
+// ExpireUnpaidOrders 取消超過付款期限仍未付款或付款失敗的訂單，釋放庫存並通知使用者。
+func ExpireUnpaidOrders() {
+	var orders []models.Order
+
+	result := database.DB.Preload("OrderItems").
+		Where("status IN ? AND payment_limit_time < ?", []byte{models.Ordered, models.PaymentFailed}, time.Now()).
+		Limit(100).
+		Find(&orders)
+	if result.Error != nil {
+		logrus.Error("Get expired orders fail=", result.Error)
+		return
+	}
+
+	for _, order := range orders {
+		errCode := CancelOrder(&order, SystemActor, "payment time expired")
+		if errCode != status.Success {
+			logrus.Error("Expire order fail, id=", order.ID, ", code=", errCode)
+			continue
+		}
+
+		user, err := GetUserById(order.UserID)
+		if err != nil {
+			continue
+		}
 
-	err := database.DB.Updates(&order).Error
+		text := "您的訂單「" + order.Serial + "」已超過付款期限，系統已自動取消。"
+		go utils.SendEmail(user.Email, "InVar訂單已取消", text)
+	}
+}
+
+// transitOrder 鎖定訂單並檢查狀態轉換是否合法，同時寫入狀態歷程。
+func transitOrder(tx *gorm.DB, order *models.Order, to byte, actor OrderActor, reason string) error {
+	var current models.Order
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", order.ID).First(&current).Error
 	if err != nil {
 		return err
 	}
 
+	if !canTransitOrder(current.Status, to) {
+		return orderTransitionErrors[to]
+	}
+
+	err = tx.Model(&current).UpdateColumn("status", to).Error
+	if err != nil {
+		return err
+	}
+
+	order.Status = to
+	return addOrderStatusHistory(tx, order, current.Status, actor, reason)
+}
+
+func canTransitOrder(from, to byte) bool {
+	for _, v := range orderTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func addOrderStatusHistory(tx *gorm.DB, order *models.Order, from byte, actor OrderActor, reason string) error {
+	history := models.OrderStatusHistory{
+		OrderID:       order.ID,
+		FromStatus:    from,
+		ToStatus:      order.Status,
+		ActorRoleType: actor.RoleType,
+		ActorRoleID:   actor.RoleID,
+		IP:            actor.IP,
+		Reason:        reason,
+	}
+
+	err := tx.Create(&history).Error
+	if err != nil {
+		return err
+	}
+
+	order.StatusHistories = append(order.StatusHistories, history)
 	return nil
 }
 
+func orderErrorCode(err error) int {
+	switch err {
+	case nil:
+		return status.Success
+	case ErrOrderCannotCancel:
+		return status.OrderCannotCancel
+	case ErrOrderCannotPay:
+		return status.OrderCannotPay
+	case ErrOrderCannotFail:
+		return status.OrderCannotFail
+	case ErrOrderCannotComplete:
+		return status.OrderCannotComplete
+	case ErrOrderCannotRefund:
+		return status.OrderCannotRefund
+	case ErrDuplicateTransaction:
+		return status.DuplicateTransaction
+	case ErrTokenNotRefundable:
+		return status.TokenNotRefundable
+	case ErrInsufficientBalance:
+		return status.InsufficientBalance
+	}
+
+	logrus.Error("Update Orders fail=", err)
+	return status.Unkonwn
+}
+
+// isUniqueViolation 判斷是否為 Postgres 的唯一索引衝突
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 func clacOrderTotal(orderItems []models.OrderItem) decimal.Decimal {
 	total := decimal.NewFromInt(0)
 	for _, v := range orderItems {
-		total = total.Add(v.Price)
+		total = total.Add(v.Price.Mul(decimal.NewFromInt(int64(v.Quantity))))
 	}
 	return total
 }
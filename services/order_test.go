@@ -0,0 +1,170 @@
+package services
+
+import (
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 需要設定 TEST_DB_DSN 指向測試用的 Postgres，例如
+// TEST_DB_DSN="host=localhost user=invar password=invar dbname=invar_test port=5432 sslmode=disable"
+func setupTestDB(t *testing.T) {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal("Connect test database fail=", err)
+	}
+
+	database.DB = db
+	database.AutoMigrate()
+}
+
+func TestAddOrderConcurrentNoOversell(t *testing.T) {
+	setupTestDB(t)
+
+	const stock = 10
+	const buyers = 50
+
+	product := models.Product{
+		Stock:  stock,
+		Status: true,
+		Price:  decimal.NewFromInt(100),
+		Title:  "concurrency test",
+	}
+	err := database.DB.Create(&product).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var orderIDs []uint
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			items := []models.OrderItem{{ProductID: product.ID, Price: product.Price, Quantity: 1}}
+			order, errCode := AddOrder(userID, items, OrderActor{RoleID: userID})
+			if errCode == status.Success {
+				mu.Lock()
+				orderIDs = append(orderIDs, order.ID)
+				mu.Unlock()
+			} else if errCode != status.OutOfStock {
+				t.Error("unexpected error code=", errCode)
+			}
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	t.Cleanup(func() {
+		database.DB.Unscoped().Where("order_id IN ?", orderIDs).Delete(&models.OrderStatusHistory{})
+		database.DB.Unscoped().Where("order_id IN ?", orderIDs).Delete(&models.OrderItem{})
+		database.DB.Unscoped().Where("id IN ?", orderIDs).Delete(&models.Order{})
+		database.DB.Unscoped().Delete(&product)
+	})
+
+	if len(orderIDs) != stock {
+		t.Errorf("expected %d successful orders, got %d", stock, len(orderIDs))
+	}
+
+	var reloaded models.Product
+	err = database.DB.First(&reloaded, product.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Stock != 0 {
+		t.Errorf("expected stock 0, got %d", reloaded.Stock)
+	}
+
+	order, err := GetOrder(orderIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCode := CancelOrder(&order, SystemActor, "test")
+	if errCode != status.Success {
+		t.Fatal("cancel order fail, code=", errCode)
+	}
+
+	err = database.DB.First(&reloaded, product.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Stock != 1 {
+		t.Errorf("expected stock 1 after cancel, got %d", reloaded.Stock)
+	}
+}
+
+// 付款失敗後一直沒有重新付款的訂單，超過付款期限也要取消並釋放庫存
+func TestExpireUnpaidOrdersCancelsPaymentFailed(t *testing.T) {
+	setupTestDB(t)
+
+	product := models.Product{
+		Stock:  1,
+		Status: true,
+		Price:  decimal.NewFromInt(100),
+		Title:  "expire payment failed test",
+	}
+	err := database.DB.Create(&product).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []models.OrderItem{{ProductID: product.ID, Price: product.Price, Quantity: 1}}
+	order, errCode := AddOrder(990004, items, OrderActor{RoleID: 990004})
+	if errCode != status.Success {
+		t.Fatal("add order fail, code=", errCode)
+	}
+
+	t.Cleanup(func() {
+		database.DB.Unscoped().Where("order_id = ?", order.ID).Delete(&models.OrderStatusHistory{})
+		database.DB.Unscoped().Where("order_id = ?", order.ID).Delete(&models.OrderItem{})
+		database.DB.Unscoped().Delete(&order)
+		database.DB.Unscoped().Delete(&product)
+	})
+
+	err = database.DB.Model(&order).UpdateColumns(map[string]interface{}{
+		"status":             models.PaymentFailed,
+		"payment_limit_time": time.Now().Add(-time.Minute),
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ExpireUnpaidOrders()
+
+	var reloaded models.Order
+	err = database.DB.First(&reloaded, order.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Status != models.Cancel {
+		t.Errorf("expected status %d, got %d", models.Cancel, reloaded.Status)
+	}
+
+	var reloadedProduct models.Product
+	err = database.DB.First(&reloadedProduct, product.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloadedProduct.Stock != 1 {
+		t.Errorf("expected stock 1 after expiry, got %d", reloadedProduct.Stock)
+	}
+}
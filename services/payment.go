@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"invar/chain"
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"invar/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// 付款通知後超過此時間仍查不到交易，視為付款失敗
+const paymentNotFoundTimeout = 24 * time.Hour
+
+var ErrPaymentPending = errors.New("payment is pending")
+
+// paymentRejectedError 鏈上交易確定不符合訂單，只有這類錯誤會讓訂單付款失敗，
+// 資料庫、RPC 等暫時性錯誤只記錄下來，訂單維持等待確認付款。
+type paymentRejectedError struct {
+	reason string
+}
+
+func (e *paymentRejectedError) Error() string {
+	return e.reason
+}
+
+func rejectPayment(reason string) error {
+	return &paymentRejectedError{reason: reason}
+}
+
+// InitChainClients 依 EVM_CHAINS 註冊 EVM 鏈客戶端，例如 EVM_CHAINS=ETH,BSC 搭配 ETH_RPC_URL、BSC_RPC_URL。
+func InitChainClients() {
+	for _, name := range strings.Split(os.Getenv("EVM_CHAINS"), ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		url := os.Getenv(name + "_RPC_URL")
+		if url == "" {
+			logrus.Error("Missing rpc url for chain=", name)
+			continue
+		}
+
+		chain.Register(name, chain.NewEVMClient(url))
+	}
+}
+
+// VerifyPendingPayments 檢查等待確認付款的訂單，依鏈上交易結果自動完成或設為付款失敗。
+func VerifyPendingPayments() {
+	var orders []models.Order
+
+	result := database.DB.Preload("OrderItems").
+		Where("status = ?", models.WaitConfirmPayment).
+		Order("id").
+		Limit(100).
+		Find(&orders)
+	if result.Error != nil {
+		logrus.Error("Get pending payment orders fail=", result.Error)
+		return
+	}
+
+	for _, order := range orders {
+		verifyOrderPayment(&order)
+	}
+}
+
+func verifyOrderPayment(order *models.Order) {
+	err := checkOrderPayment(order)
+	if err == chain.ErrTransactionNotFound {
+		if time.Since(order.UpdatedAt) < paymentNotFoundTimeout {
+			return
+		}
+		err = rejectPayment(err.Error())
+	}
+
+	var rejected *paymentRejectedError
+	var errCode int
+	var text string
+	switch {
+	case err == nil:
+		errCode = CompletedOrder(order, SystemActor, "payment verified")
+		text = "您的訂單「" + order.Serial + "」已確認付款完成。"
+	case errors.As(err, &rejected):
+		errCode = FailOrderPayment(order, SystemActor, err.Error())
+		text = "您的訂單「" + order.Serial + "」付款驗證失敗，請確認交易內容後重新提交付款資訊。"
+	case err == ErrPaymentPending:
+		return
+	default:
+		logrus.Error("Check order payment fail, id=", order.ID, ", err=", err)
+		return
+	}
+
+	if errCode != status.Success {
+		logrus.Error("Verify order payment fail, id=", order.ID, ", code=", errCode)
+		return
+	}
+
+	user, err := GetUserById(order.UserID)
+	if err != nil {
+		return
+	}
+
+	go utils.SendEmail(user.Email, "InVar訂單付款通知", text)
+}
+
+// checkOrderPayment 回傳 nil 表示付款正確，ErrPaymentPending 表示確認數不足需稍後再查，
+// paymentRejectedError 表示交易確定不符合訂單，其他錯誤為暫時性錯誤，下次排程再查。
+func checkOrderPayment(order *models.Order) error {
+	client, err := chain.GetClient(order.TransactionChain)
+	if err != nil {
+		return err
+	}
+
+	required, err := paymentRequirements(order)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := client.GetTransaction(ctx, order.TransactionID)
+	if err != nil {
+		if err == chain.ErrTransactionNotFound {
+			return err
+		}
+		logrus.Error("Get transaction fail=", err)
+		return ErrPaymentPending
+	}
+
+	senders, err := paymentSenders(order.UserID)
+	if err != nil {
+		return err
+	}
+
+	return checkPaymentTransaction(tx, required, os.Getenv("PAYMENT_RECEIVING_ADDRESS"), senders, paymentMinConfirmations())
+}
+
+// paymentSenders 取得使用者白名單中的地址，只有從這些地址轉出的款項才算是該使用者的付款。
+func paymentSenders(userID uint) ([]string, error) {
+	var senders []string
+	err := database.DB.Model(&models.WhiteList{}).Where("user_id = ?", userID).Pluck("address", &senders).Error
+	return senders, err
+}
+
+// paymentRequirements 依訂單商品的合約代幣加總應付金額，商品必須與付款在同一條鏈上。
+func paymentRequirements(order *models.Order) (map[string]decimal.Decimal, error) {
+	required := make(map[string]decimal.Decimal)
+
+	for _, item := range order.OrderItems {
+		product, err := GetProduct(item.ProductID)
+		if err != nil {
+			return required, err
+		}
+
+		if !strings.EqualFold(product.ContractChain, order.TransactionChain) || product.ContractAddress == "" {
+			return required, rejectPayment("product is not payable on chain " + order.TransactionChain)
+		}
+
+		token := strings.ToLower(product.ContractAddress)
+		amount := item.Price.Mul(decimal.NewFromInt(int64(item.Quantity)))
+		required[token] = required[token].Add(amount)
+	}
+
+	return required, nil
+}
+
+// checkPaymentTransaction 只計算從 senders 轉入 receiver 的款項，避免使用者以他人的交易冒充付款。
+func checkPaymentTransaction(tx chain.Transaction, required map[string]decimal.Decimal, receiver string, senders []string, minConfirmations uint64) error {
+	if receiver == "" {
+		return errors.New("payment receiving address is not configured")
+	}
+
+	if !tx.Success {
+		return rejectPayment("transaction reverted")
+	}
+
+	if tx.Confirmations < minConfirmations {
+		return ErrPaymentPending
+	}
+
+	received := make(map[string]decimal.Decimal)
+	for _, transfer := range tx.Transfers {
+		if !chain.SameAddress(transfer.To, receiver) || !containsAddress(senders, transfer.From) {
+			continue
+		}
+		token := strings.ToLower(transfer.Token)
+		received[token] = received[token].Add(transfer.Amount)
+	}
+
+	for token, amount := range required {
+		if received[token].LessThan(amount) {
+			return rejectPayment("insufficient payment for token " + token + ", required " + amount.String() + ", received " + received[token].String())
+		}
+	}
+
+	return nil
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, v := range addresses {
+		if chain.SameAddress(v, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func paymentMinConfirmations() uint64 {
+	confirmations, err := strconv.ParseUint(os.Getenv("PAYMENT_MIN_CONFIRMATIONS"), 10, 64)
+	if err != nil {
+		return 1
+	}
+
+	return confirmations
+}
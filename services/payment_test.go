@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"invar/chain"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckPaymentTransaction(t *testing.T) {
+	const receiver = "0x00000000000000000000000000000000000000aa"
+	const token = "0x00000000000000000000000000000000000000bb"
+	const sender = "0x00000000000000000000000000000000000000dd"
+
+	client := chain.NewFakeClient()
+	client.AddTransaction(chain.Transaction{
+		Hash:          "0x01",
+		Confirmations: 12,
+		Success:       true,
+		Transfers: []chain.Transfer{
+			{Token: token, From: "0x00000000000000000000000000000000000000DD", To: "0x00000000000000000000000000000000000000AA", Amount: decimal.NewFromInt(60)},
+			{Token: token, From: sender, To: receiver, Amount: decimal.NewFromInt(40)},
+		},
+	})
+	client.AddTransaction(chain.Transaction{Hash: "0x02", Confirmations: 3, Success: true})
+	client.AddTransaction(chain.Transaction{Hash: "0x03", Confirmations: 12, Success: false})
+	client.AddTransaction(chain.Transaction{
+		Hash:          "0x04",
+		Confirmations: 12,
+		Success:       true,
+		Transfers: []chain.Transfer{
+			{Token: token, From: sender, To: "0x00000000000000000000000000000000000000cc", Amount: decimal.NewFromInt(100)},
+		},
+	})
+	client.AddTransaction(chain.Transaction{
+		Hash:          "0x05",
+		Confirmations: 12,
+		Success:       true,
+		Transfers: []chain.Transfer{
+			{Token: token, From: "0x00000000000000000000000000000000000000ee", To: receiver, Amount: decimal.NewFromInt(100)},
+		},
+	})
+
+	required := map[string]decimal.Decimal{token: decimal.NewFromInt(100)}
+
+	tests := []struct {
+		name     string
+		hash     string
+		wantErr  bool
+		pending  bool
+		rejected bool
+	}{
+		{"paid in full", "0x01", false, false, false},
+		{"not enough confirmations", "0x02", true, true, false},
+		{"reverted", "0x03", true, false, true},
+		{"wrong receiver", "0x04", true, false, true},
+		{"sender not whitelisted", "0x05", true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := client.GetTransaction(context.Background(), tt.hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkPaymentTransaction(tx, required, receiver, []string{sender}, 6)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPaymentTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (err == ErrPaymentPending) != tt.pending {
+				t.Errorf("checkPaymentTransaction() error = %v, pending %v", err, tt.pending)
+			}
+			var rejected *paymentRejectedError
+			if errors.As(err, &rejected) != tt.rejected {
+				t.Errorf("checkPaymentTransaction() error = %v, rejected %v", err, tt.rejected)
+			}
+		})
+	}
+
+	// 收款地址未設定是設定問題，不能讓訂單付款失敗
+	tx, _ := client.GetTransaction(context.Background(), "0x01")
+	err := checkPaymentTransaction(tx, required, "", []string{sender}, 6)
+	var rejected *paymentRejectedError
+	if err == nil || errors.As(err, &rejected) {
+		t.Errorf("expected a non-rejecting error without receiver, got %v", err)
+	}
+
+	_, err = client.GetTransaction(context.Background(), "0x06")
+	if err != chain.ErrTransactionNotFound {
+		t.Errorf("expected ErrTransactionNotFound, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"invar/models"
 
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func GetProducts() ([]models.Product, error) {
@@ -18,13 +19,16 @@ func GetProducts() ([]models.Product, error) {
 	return products, nil
 }
 
-func AddProduct(product *models.Product) error {
-	result := database.DB.Create(&product)
-	if result.Error != nil {
-		logrus.Error("Add Products fail=", result.Error)
+// AddProduct 建立商品，失敗時刪除 upload 寫入的圖片
+func AddProduct(product *models.Product, upload *Upload) error {
+	err := upload.Commit(func(tx *gorm.DB) error {
+		return tx.Create(product).Error
+	})
+	if err != nil {
+		logrus.Error("Add Products fail=", err)
 	}
 
-	return nil
+	return err
 }
 
 func GetProduct(key uint) (models.Product, error) {
@@ -33,18 +37,41 @@ func GetProduct(key uint) (models.Product, error) {
 
 	result := database.DB.First(&product)
 	if result.Error != nil {
-		logrus.Error("Get Products fail=", result.Error)
+		return product, result.Error
 	}
 
 	return product, nil
 }
 
-func UpdateProduct(product *models.Product) error {
-	result := database.DB.Updates(&product)
+// UpdateProduct 更新商品，成功後才刪除被取代的舊圖片
+func UpdateProduct(product *models.Product, upload *Upload) error {
+	err := upload.Commit(func(tx *gorm.DB) error {
+		return tx.Updates(product).Error
+	})
+	if err != nil {
+		logrus.Error("Update Products fail=", err)
+	}
 
-	if result.Error != nil {
-		logrus.Error("Get Products fail=", result.Error)
+	return err
+}
+
+// FillProductURL 產生商品圖片的限時下載網址
+func FillProductURL(product *models.Product) {
+	product.ImageURL = StoredFileURL(product.Image)
+	product.PreviewImageURL = StoredFileURL(product.PreviewImage)
+}
+
+// CanBuyProduct 商品未設定購買權限時所有使用者都能購買，否則使用者的角色需在權限內。
+func CanBuyProduct(user *models.User, product *models.Product) bool {
+	if len(product.BuyPermissions) == 0 {
+		return true
+	}
+
+	for _, v := range product.BuyPermissions {
+		if uint(v) == user.Role {
+			return true
+		}
 	}
 
-	return nil
+	return false
 }
@@ -1 +1,299 @@
 package services
+
+import (
+	"context"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotExistReferrerCode = errors.New(status.ErrorText(status.NotExistReferrerCode))
+
+// 推薦關係樹最多查詢的層數
+const maxReferralDepth = 5
+
+type Referee struct {
+	ID        uint      `json:"id"`
+	UserName  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReferralNode struct {
+	Referee
+	Email    string         `json:"email"`
+	Referees []ReferralNode `json:"referees"`
+}
+
+// GetReferrerIDByCode 以邀請碼查詢推薦人，優先使用 Redis 快取。
+func GetReferrerIDByCode(code string) (uint, error) {
+	var ctx = context.Background()
+
+	result, err := database.RDS.HGet(ctx, database.ReferrerCache, code).Result()
+	if err == nil {
+		id, err := strconv.ParseUint(result, 10, 64)
+		if err == nil {
+			return uint(id), nil
+		}
+	}
+
+	if err != nil && err != redis.Nil {
+		logrus.Error("Get referrer cache fail=", err)
+	}
+
+	var user models.User
+	dbResult := database.DB.Select("id").Where("referrer_code = ?", code).Limit(1).Find(&user)
+	if dbResult.Error != nil {
+		return 0, dbResult.Error
+	}
+
+	if dbResult.RowsAffected == 0 {
+		return 0, ErrNotExistReferrerCode
+	}
+
+	cacheReferrerCode(code, user.ID)
+	return user.ID, nil
+}
+
+func cacheReferrerCode(code string, userID uint) {
+	var ctx = context.Background()
+
+	err := database.RDS.HSet(ctx, database.ReferrerCache, code, userID).Err()
+	if err != nil {
+		logrus.Error("Set referrer cache fail=", err)
+	}
+}
+
+func GetReferees(userID uint) ([]Referee, error) {
+	referees := make([]Referee, 0)
+
+	result := database.DB.Model(&models.User{}).
+		Select("id, user_name, created_at").
+		Where("referrer_id = ?", userID).
+		Order("id").
+		Scan(&referees)
+
+	return referees, result.Error
+}
+
+// GetReferralTree 取得使用者往下的推薦關係樹，最多 maxReferralDepth 層。
+func GetReferralTree(userID uint) (ReferralNode, error) {
+	var node ReferralNode
+
+	user, err := GetUserById(userID)
+	if err != nil {
+		return node, err
+	}
+
+	node = ReferralNode{
+		Referee: Referee{ID: user.ID, UserName: user.UserName, CreatedAt: user.CreatedAt},
+		Email:   user.Email,
+	}
+
+	err = fillReferralTree(&node, 1)
+	return node, err
+}
+
+func fillReferralTree(node *ReferralNode, depth int) error {
+	node.Referees = make([]ReferralNode, 0)
+	if depth > maxReferralDepth {
+		return nil
+	}
+
+	var users []models.User
+	err := database.DB.Where("referrer_id = ?", node.ID).Order("id").Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		child := ReferralNode{
+			Referee: Referee{ID: user.ID, UserName: user.UserName, CreatedAt: user.CreatedAt},
+			Email:   user.Email,
+		}
+
+		err = fillReferralTree(&child, depth+1)
+		if err != nil {
+			return err
+		}
+		node.Referees = append(node.Referees, child)
+	}
+
+	return nil
+}
+
+func GetReferralCommissions(referrerID uint) ([]models.ReferralCommission, error) {
+	var commissions []models.ReferralCommission
+
+	result := database.DB.Where("referrer_id = ?", referrerID).Order("id desc").Find(&commissions)
+	if result.Error != nil {
+		return commissions, result.Error
+	}
+
+	return commissions, nil
+}
+
+func GetCommissionRules() ([]models.CommissionRule, error) {
+	var rules []models.CommissionRule
+
+	result := database.DB.Order("level, product_id").Find(&rules)
+	if result.Error != nil {
+		return rules, result.Error
+	}
+
+	return rules, nil
+}
+
+func GetCommissionRule(id uint) (models.CommissionRule, error) {
+	var rule models.CommissionRule
+
+	result := database.DB.Where("id = ?", id).First(&rule)
+	if result.Error != nil {
+		return rule, result.Error
+	}
+
+	return rule, nil
+}
+
+func AddCommissionRule(rule *models.CommissionRule) error {
+	return database.DB.Create(rule).Error
+}
+
+func UpdateCommissionRule(rule *models.CommissionRule) error {
+	return database.DB.Select("level", "product_id", "rate", "asset", "status").Updates(rule).Error
+}
+
+func DeleteCommissionRule(rule *models.CommissionRule) error {
+	return database.DB.Delete(rule).Error
+}
+
+// payReferralCommissions 依推薦獎勵規則發放訂單的推薦獎勵給各層推薦人，必須在完成訂單的交易內呼叫。
+func payReferralCommissions(tx *gorm.DB, order *models.Order) error {
+	var rules []models.CommissionRule
+	err := tx.Where("status = ?", true).Find(&rules).Error
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	var referee models.User
+	err = tx.Where("id = ?", order.UserID).First(&referee).Error
+	if err != nil {
+		return err
+	}
+
+	referrerID := referee.ReferrerID
+	for level := uint(1); referrerID != 0 && level <= maxReferralDepth; level++ {
+		amounts := make(map[string]decimal.Decimal)
+		ruleIDs := make(map[string]uint)
+		for _, item := range order.OrderItems {
+			rule, ok := matchCommissionRule(rules, level, item.ProductID)
+			if !ok {
+				continue
+			}
+
+			amount := item.Price.Mul(decimal.NewFromInt(int64(item.Quantity))).Mul(rule.Rate)
+			amounts[rule.Asset] = amounts[rule.Asset].Add(amount)
+			ruleIDs[rule.Asset] = rule.ID
+		}
+
+		for asset, amount := range amounts {
+			if !amount.IsPositive() {
+				continue
+			}
+
+			commission := models.ReferralCommission{
+				OrderID:    order.ID,
+				RefereeID:  referee.ID,
+				ReferrerID: referrerID,
+				Level:      level,
+				RuleID:     ruleIDs[asset],
+				Asset:      asset,
+				Amount:     amount,
+			}
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&commission)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			_, err = PostJournalEntry(tx, models.JournalReferralCommission, commission.ID, order.Serial, []LedgerLeg{
+				DebitUser(referrerID, asset, amount),
+				CreditSystem(models.LedgerReferral, asset, amount),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		var referrer models.User
+		err = tx.Select("id, referrer_id").Where("id = ?", referrerID).First(&referrer).Error
+		if err != nil {
+			return err
+		}
+		referrerID = referrer.ReferrerID
+	}
+
+	return nil
+}
+
+// revertReferralCommissions 訂單退款時收回已發放的推薦獎勵。
+func revertReferralCommissions(tx *gorm.DB, order *models.Order) error {
+	var commissions []models.ReferralCommission
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND reverted = ?", order.ID, false).
+		Find(&commissions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, commission := range commissions {
+		_, err = PostJournalEntry(tx, models.JournalReferralCommissionRevert, commission.ID, order.Serial, []LedgerLeg{
+			CreditUser(commission.ReferrerID, commission.Asset, commission.Amount),
+			DebitSystem(models.LedgerReferral, commission.Asset, commission.Amount),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&commission).UpdateColumn("reverted", true).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func matchCommissionRule(rules []models.CommissionRule, level uint, productID uint) (models.CommissionRule, bool) {
+	var matched models.CommissionRule
+	found := false
+
+	for _, rule := range rules {
+		if rule.Level != level {
+			continue
+		}
+
+		if rule.ProductID == productID {
+			return rule, true
+		}
+
+		if rule.ProductID == 0 && !found {
+			matched = rule
+			found = true
+		}
+	}
+
+	return matched, found
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"invar/database"
+	"invar/utils"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const schedulerLockTTL = 5 * time.Minute
+
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func StartSchedulers() {
+	go runScheduledJob("order_expiry", time.Minute, ExpireUnpaidOrders)
+	go runScheduledJob("payment_verify", time.Minute, VerifyPendingPayments)
+	go runScheduledJob("stack_profit", time.Hour, DistributeStackProfits)
+	go runScheduledJob("stack_expiry", time.Hour, ExpireStackRecords)
+	go runScheduledJob("orphan_file_sweep", time.Hour, SweepOrphanFiles)
+}
+
+// runScheduledJob 定期執行 job，多個實例之間以 Redis 鎖確保同時只有一個在執行。
+func runScheduledJob(name string, interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		runWithLock(name, job)
+	}
+}
+
+func runWithLock(name string, job func()) bool {
+	var ctx = context.Background()
+	key := database.SchedulerLock + ":" + name
+
+	token, err := utils.GenerateRefreshToken(16)
+	if err != nil {
+		logrus.Error("Generate scheduler lock token fail=", err)
+		return false
+	}
+
+	ok, err := database.RDS.SetNX(ctx, key, token, schedulerLockTTL).Result()
+	if err != nil {
+		logrus.Error("Acquire scheduler lock fail=", err)
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	defer func() {
+		err := releaseLockScript.Run(ctx, database.RDS, []string{key}, token).Err()
+		if err != nil {
+			logrus.Error("Release scheduler lock fail=", err)
+		}
+	}()
+
+	job()
+	return true
+}
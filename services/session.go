@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/utils"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const refreshTokenLifetime = 24 * time.Hour
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token was reused")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session was revoked")
+)
+
+// Session 為一個登入工作階段，ID 即為 refresh token 的 FamilyID。
+type Session struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedByIP string    `json:"created_by_ip"`
+	UserAgent   string    `json:"user_agent"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	LastUsedIP  string    `json:"last_used_ip"`
+	Expires     time.Time `json:"expires"`
+	Current     bool      `json:"current"`
+}
+
+// GenerateRefreshToken 登入時簽發新的 refresh token，並建立新的工作階段。
+func GenerateRefreshToken(roleType, roleID uint, ip, userAgent string) (models.RefreshToken, error) {
+	var refreshToken models.RefreshToken
+
+	token, err := utils.GenerateRefreshToken(64)
+	if err != nil {
+		return refreshToken, err
+	}
+
+	now := time.Now()
+	refreshToken = models.RefreshToken{
+		RoleType:         roleType,
+		RoleID:           roleID,
+		TokenHash:        utils.HashToken(token),
+		Expires:          now.Add(refreshTokenLifetime),
+		SessionCreatedAt: now,
+		CreatedByIP:      ip,
+		UserAgent:        userAgent,
+		LastUsedAt:       now,
+		LastUsedIP:       ip,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&refreshToken).Error
+		if err != nil {
+			return err
+		}
+
+		refreshToken.FamilyID = refreshToken.ID
+		return tx.Model(&refreshToken).UpdateColumn("family_id", refreshToken.FamilyID).Error
+	})
+	if err != nil {
+		return refreshToken, err
+	}
+
+	refreshToken.Token = token
+	return refreshToken, nil
+}
+
+// RotateRefreshToken 以舊的 refresh token 換發同一工作階段的新令牌。
+// 已被換發過的令牌再次出現代表令牌可能遭竊，整個工作階段都會被撤銷。
+func RotateRefreshToken(token, ip, userAgent string) (models.RefreshToken, error) {
+	var newRefreshToken models.RefreshToken
+	var reusedFamilyID uint
+
+	newToken, err := utils.GenerateRefreshToken(64)
+	if err != nil {
+		return newRefreshToken, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		oldRefreshToken, err := lockRefreshToken(tx, token)
+		if err != nil {
+			return err
+		}
+
+		if oldRefreshToken.IsRevoked() {
+			if oldRefreshToken.ReplacedByID == 0 {
+				return ErrRefreshTokenInvalid
+			}
+
+			reusedFamilyID = oldRefreshToken.FamilyID
+			return revokeSessions(tx, oldRefreshToken.RoleType, oldRefreshToken.RoleID, oldRefreshToken.FamilyID, ip)
+		}
+
+		if oldRefreshToken.Expires.Before(time.Now()) {
+			return ErrRefreshTokenInvalid
+		}
+
+		now := time.Now()
+		newRefreshToken = models.RefreshToken{
+			RoleType:         oldRefreshToken.RoleType,
+			RoleID:           oldRefreshToken.RoleID,
+			TokenHash:        utils.HashToken(newToken),
+			FamilyID:         oldRefreshToken.FamilyID,
+			Expires:          now.Add(refreshTokenLifetime),
+			SessionCreatedAt: oldRefreshToken.SessionCreatedAt,
+			CreatedByIP:      oldRefreshToken.CreatedByIP,
+			UserAgent:        userAgent,
+			LastUsedAt:       now,
+			LastUsedIP:       ip,
+		}
+
+		err = tx.Create(&newRefreshToken).Error
+		if err != nil {
+			return err
+		}
+
+		oldRefreshToken.SetToRevoked(ip)
+		oldRefreshToken.ReplacedByID = newRefreshToken.ID
+		return tx.Model(&oldRefreshToken).Updates(map[string]interface{}{
+			"revoked":        oldRefreshToken.Revoked,
+			"revoked_by_ip":  oldRefreshToken.RevokedByIP,
+			"replaced_by_id": oldRefreshToken.ReplacedByID,
+		}).Error
+	})
+	if err != nil {
+		return newRefreshToken, err
+	}
+
+	if reusedFamilyID != 0 {
+		logrus.Warnf("Refresh token reused, session %d revoked, ip=%s", reusedFamilyID, ip)
+		return newRefreshToken, ErrRefreshTokenReused
+	}
+
+	newRefreshToken.Token = newToken
+	return newRefreshToken, nil
+}
+
+// RevokeToken 登出目前的工作階段。
+func RevokeToken(token string, ip string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		refreshToken, err := lockRefreshToken(tx, token)
+		if err != nil {
+			return err
+		}
+
+		if refreshToken.IsRevoked() {
+			return ErrRefreshTokenInvalid
+		}
+
+		return revokeSessions(tx, refreshToken.RoleType, refreshToken.RoleID, refreshToken.FamilyID, ip)
+	})
+}
+
+// GetSessions 取得角色目前所有有效的工作階段。
+func GetSessions(roleType, roleID, currentSessionID uint) ([]Session, error) {
+	var refreshTokens []models.RefreshToken
+
+	err := activeRefreshTokens(database.DB, roleType, roleID).
+		Order("session_created_at desc").Find(&refreshTokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(refreshTokens))
+	for _, refreshToken := range refreshTokens {
+		sessions = append(sessions, Session{
+			ID:          refreshToken.FamilyID,
+			CreatedAt:   refreshToken.SessionCreatedAt,
+			CreatedByIP: refreshToken.CreatedByIP,
+			UserAgent:   refreshToken.UserAgent,
+			LastUsedAt:  refreshToken.LastUsedAt,
+			LastUsedIP:  refreshToken.LastUsedIP,
+			Expires:     refreshToken.Expires,
+			Current:     refreshToken.FamilyID == currentSessionID,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession 撤銷角色的單一工作階段。
+func RevokeSession(roleType, roleID, sessionID uint, ip string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := activeRefreshTokens(tx, roleType, roleID).
+			Where("family_id = ?", sessionID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return ErrSessionNotFound
+		}
+
+		return revokeSessions(tx, roleType, roleID, sessionID, ip)
+	})
+}
+
+// RevokeAllSessions 撤銷角色所有的工作階段，並讓已簽發的 access token 立即失效。
+func RevokeAllSessions(roleType, roleID uint, ip string) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := revokeSessions(tx, roleType, roleID, 0, ip)
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleType, roleID)
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleType, roleID)
+	return nil
+}
+
+func lockRefreshToken(tx *gorm.DB, token string) (models.RefreshToken, error) {
+	var refreshToken models.RefreshToken
+
+	if token == "" {
+		return refreshToken, ErrRefreshTokenInvalid
+	}
+
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", utils.HashToken(token)).Limit(1).Find(&refreshToken)
+	if result.Error != nil {
+		return refreshToken, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return refreshToken, ErrRefreshTokenInvalid
+	}
+
+	return refreshToken, nil
+}
+
+func activeRefreshTokens(tx *gorm.DB, roleType, roleID uint) *gorm.DB {
+	return tx.Model(&models.RefreshToken{}).
+		Where("role_type = ? AND role_id = ?", roleType, roleID).
+		Where("revoked = ? AND expires > ?", time.Time{}, time.Now())
+}
+
+// revokeSessions 撤銷 familyID 的工作階段，familyID 為 0 時撤銷角色所有的工作階段。
+func revokeSessions(tx *gorm.DB, roleType, roleID, familyID uint, ip string) error {
+	var familyIDs []uint
+
+	query := activeRefreshTokens(tx, roleType, roleID)
+	if familyID != 0 {
+		query = query.Where("family_id = ?", familyID)
+	}
+
+	err := query.Distinct().Pluck("family_id", &familyIDs).Error
+	if err != nil {
+		return err
+	}
+
+	if familyID != 0 && len(familyIDs) == 0 {
+		familyIDs = append(familyIDs, familyID)
+	}
+
+	if len(familyIDs) == 0 {
+		return nil
+	}
+
+	err = tx.Model(&models.RefreshToken{}).
+		Where("family_id IN ? AND revoked = ?", familyIDs, time.Time{}).
+		Updates(map[string]interface{}{
+			"revoked":       time.Now(),
+			"revoked_by_ip": ip,
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range familyIDs {
+		markSessionRevoked(id)
+	}
+
+	return nil
+}
+
+// markSessionRevoked 記錄已撤銷的工作階段，直到該工作階段簽發的 access token 全部過期。
+func markSessionRevoked(sessionID uint) {
+	var ctx = context.Background()
+
+	err := database.RDS.Set(ctx, revokedSessionKey(sessionID), 1, accessTokenLifetime).Err()
+	if err != nil {
+		logrus.Error("Set revoked session fail=", err)
+	}
+}
+
+func isSessionRevoked(sessionID uint) bool {
+	var ctx = context.Background()
+
+	if sessionID == 0 {
+		return false
+	}
+
+	count, err := database.RDS.Exists(ctx, revokedSessionKey(sessionID)).Result()
+	if err != nil {
+		logrus.Error("Get revoked session fail=", err)
+		return false
+	}
+
+	return count > 0
+}
+
+func revokedSessionKey(sessionID uint) string {
+	return database.RevokedSessionCache + ":" + strconv.Itoa(int(sessionID))
+}
@@ -1,14 +1,25 @@
 package services
 
 import (
+	"errors"
 	"invar/database"
 	"invar/models"
+	"invar/status"
 	"invar/utils"
 	"time"
 
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrInsufficientToken = errors.New(status.ErrorText(status.InsufficientToken))
+var ErrStackNotExpired = errors.New(status.ErrorText(status.StackNotExpired))
+var ErrStackRecordStatusInvalid = errors.New(status.ErrorText(status.StackRecordStatusInvalid))
+var ErrStackNotExchangeable = errors.New(status.ErrorText(status.StackNotExchangeable))
+var ErrStackAutoRenew = errors.New(status.ErrorText(status.StackAutoRenew))
+
 func GetStacks() ([]models.Stack, error) {
 	var stacks []models.Stack
 	result := database.DB.Find(&stacks)
@@ -22,7 +33,7 @@ func GetStacks() ([]models.Stack, error) {
 
 func GetStack(id uint) (models.Stack, error) {
 	var stack models.Stack
-	result := database.DB.Where("id = ?", id).Find(&stack)
+	result := database.DB.Where("id = ?", id).First(&stack)
 
 	if result.Error != nil {
 		return stack, result.Error
@@ -66,46 +77,318 @@ func GetStacksRecord(userID uint) ([]models.StackRecord, error) {
 func GetStackRecord(id uint) (models.StackRecord, error) {
 	var stackRecord models.StackRecord
 
-	result := database.DB.Preload("StackProfitRecords").Where("uid = ?", id).Find(&stackRecord)
+	result := database.DB.Preload("StackProfitRecords").Where("id = ?", id).First(&stackRecord)
 
 	if result.Error != nil {
-		return stackRecord, nil
+		return stackRecord, result.Error
 	}
 
 	return stackRecord, nil
 }
 
-func AddStackRecord(stack *models.Stack, quantity uint) error {
+// AddStackRecord 將使用者持有的可用代幣移入質押，扣除可用數量並建立質押中的 Token。
+func AddStackRecord(user *models.User, stack *models.Stack, quantity uint, autoRenew bool) (models.StackRecord, int) {
 	currentTime := time.Now()
 	var record = models.StackRecord{
 		Status:           models.Stacking,
+		UserID:           user.ID,
 		StackID:          stack.ID,
 		Quantity:         quantity,
-		EndGetProfitTime: currentTime.AddDate(0, int(stack.ContractTimeBound), 0),
+		AutoRenew:        autoRenew,
+		ProfitStartTime:  currentTime,
+		EndGetProfitTime: utils.AddMonths(currentTime, int(stack.ContractTimeBound)),
+	}
+
+	if !canStack(user, stack) {
+		return record, status.NotPermission
 	}
 
 	if stack.ProfitIntervalMonth != 0 {
-		record.NextGetProfitTime = currentTime.AddDate(0, int(stack.ProfitIntervalMonth), 0)
+		record.NextGetProfitTime = utils.AddMonths(currentTime, int(stack.ProfitIntervalMonth))
 	}
 
 	err := database.DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Create(&record).Error
+		bank, err := getOrCreateBank(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		amount := decimal.NewFromInt(int64(quantity))
+		token, err := lockUnlockToken(tx, bank.ID, stack.ProductID)
+		if err != nil {
+			return err
+		}
+
+		if token.ID == 0 || token.Quantity.LessThan(amount) {
+			return ErrInsufficientToken
+		}
+
+		err = tx.Model(&token).UpdateColumn("quantity", token.Quantity.Sub(amount)).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&record).Error
 		if err != nil {
 			return err
 		}
 
 		record.Serial = utils.GenerateStackRecordSerialCode(record.ID)
-		err = tx.Updates(&record).Error
+		err = tx.Model(&record).UpdateColumn("serial", record.Serial).Error
 		if err != nil {
 			return err
 		}
-		return nil
+
+		stackToken := models.Token{
+			BankID:          bank.ID,
+			Status:          models.Stacking,
+			ProductID:       token.ProductID,
+			ContractChain:   token.ContractChain,
+			ContractType:    token.ContractType,
+			ContractAddress: token.ContractAddress,
+			StackRecordID:   record.ID,
+			Quantity:        amount,
+		}
+		return tx.Create(&stackToken).Error
 	})
 
-	//檢查有沒有該TOKEN 沒有就取消
-	//call redis
+	return record, stackErrorCode(err)
+}
 
-	return err
+// UnlockStackRecord 質押到期後解除質押，代幣退回使用者的可用數量。
+func UnlockStackRecord(record *models.StackRecord) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		current, stackToken, err := lockExpiredStackRecord(tx, record.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := lockUnlockToken(tx, stackToken.BankID, stackToken.ProductID)
+		if err != nil {
+			return err
+		}
+
+		if token.ID == 0 {
+			err = tx.Model(&stackToken).Updates(map[string]interface{}{
+				"status":          models.Unlock,
+				"stack_record_id": 0,
+			}).Error
+		} else {
+			err = tx.Model(&token).UpdateColumn("quantity", token.Quantity.Add(stackToken.Quantity)).Error
+			if err == nil {
+				err = tx.Delete(&stackToken).Error
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		record.Status = models.Unlock
+		return tx.Model(&current).UpdateColumn("status", record.Status).Error
+	})
+
+	return stackErrorCode(err)
+}
+
+// SetStackRecordAutoRenew 設定質押期滿後是否自動續約，只影響這一筆質押。
+func SetStackRecordAutoRenew(record *models.StackRecord, autoRenew bool) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := lockStackRecord(tx, record.ID)
+		if err != nil {
+			return err
+		}
+
+		if current.Status != models.Stacking {
+			return ErrStackRecordStatusInvalid
+		}
+
+		record.AutoRenew = autoRenew
+		return tx.Model(&current).UpdateColumn("auto_renew", record.AutoRenew).Error
+	})
+
+	return stackErrorCode(err)
+}
+
+// ExchangeStackRecord 將到期的質押依 Stack.ExchangeRate 兌換為 IVT，兌換後代幣即銷毀。
+func ExchangeStackRecord(record *models.StackRecord) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		current, stackToken, err := lockExpiredStackRecord(tx, record.ID)
+		if err != nil {
+			return err
+		}
+
+		var stack models.Stack
+		err = tx.Where("id = ?", current.StackID).First(&stack).Error
+		if err != nil {
+			return err
+		}
+
+		if !stack.ExchangeRate.IsPositive() {
+			return ErrStackNotExchangeable
+		}
+
+		asset := models.TokenAsset(stackToken.ProductID)
+		ivt := stackToken.Quantity.Mul(stack.ExchangeRate)
+		_, err = PostJournalEntry(tx, models.JournalTokenExchange, current.ID, current.Serial, []LedgerLeg{
+			CreditUser(current.UserID, asset, stackToken.Quantity),
+			DebitSystem(models.LedgerTokenExchange, asset, stackToken.Quantity),
+			DebitUser(current.UserID, models.AssetIVT, ivt),
+			CreditSystem(models.LedgerTokenExchange, models.AssetIVT, ivt),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(&stackToken).Error
+		if err != nil {
+			return err
+		}
+
+		record.Status = models.Exchanged
+		return tx.Model(&current).UpdateColumn("status", record.Status).Error
+	})
+
+	return stackErrorCode(err)
+}
+
+// ExpireStackRecords 處理到期的質押，有設定自動續約的延長一個合約期，其餘設為質押到期。
+func ExpireStackRecords() {
+	var records []models.StackRecord
+
+	result := database.DB.Where("status = ? AND end_get_profit_time < ?", models.Stacking, time.Now()).
+		Limit(100).
+		Find(&records)
+	if result.Error != nil {
+		logrus.Error("Get expired stack records fail=", result.Error)
+		return
+	}
+
+	for _, record := range records {
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			current, err := lockStackRecord(tx, record.ID)
+			if err != nil {
+				return err
+			}
+
+			if current.Status != models.Stacking || current.EndGetProfitTime.After(time.Now()) {
+				return nil
+			}
+
+			var stack models.Stack
+			err = tx.Where("id = ?", current.StackID).First(&stack).Error
+			if err != nil {
+				return err
+			}
+
+			// 等最後一期配息發放後再處理到期
+			if utils.HasDueProfit(&current, &stack, time.Now()) {
+				return nil
+			}
+
+			if current.AutoRenew && stack.ContractTimeBound > 0 {
+				return tx.Model(&current).UpdateColumn("end_get_profit_time",
+					utils.AddPeriod(current.ProfitStartTime, current.EndGetProfitTime, int(stack.ContractTimeBound))).Error
+			}
+
+			err = tx.Model(&models.Token{}).
+				Where("stack_record_id = ? AND status = ?", current.ID, models.Stacking).
+				UpdateColumn("status", models.StackExpired).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Model(&current).UpdateColumn("status", models.StackExpired).Error
+		})
+		if err != nil {
+			logrus.Error("Expire stack record fail, id=", record.ID, ", err=", err)
+		}
+	}
+}
+
+func canStack(user *models.User, stack *models.Stack) bool {
+	if len(stack.StackPermissions) == 0 {
+		return true
+	}
+
+	for _, v := range stack.StackPermissions {
+		if uint(v) == user.Role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lockStackRecord(tx *gorm.DB, id uint) (models.StackRecord, error) {
+	var record models.StackRecord
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&record).Error
+	return record, err
+}
+
+// lockExpiredStackRecord 鎖定已到期的質押紀錄與其質押中的 Token，尚未處理到期狀態的紀錄也視為到期。
+// 排程尚未處理的最後一期配息會在同一個交易內先發放，設定自動續約的紀錄則交由排程續約，不能解除或兌換。
+func lockExpiredStackRecord(tx *gorm.DB, id uint) (models.StackRecord, models.Token, error) {
+	var token models.Token
+
+	record, err := lockStackRecord(tx, id)
+	if err != nil {
+		return record, token, err
+	}
+
+	switch record.Status {
+	case models.StackExpired:
+	case models.Stacking:
+		if time.Now().Before(record.EndGetProfitTime) {
+			return record, token, ErrStackNotExpired
+		}
+		if record.AutoRenew {
+			return record, token, ErrStackAutoRenew
+		}
+	default:
+		return record, token, ErrStackRecordStatusInvalid
+	}
+
+	err = distributeStackRecordProfit(tx, record.ID, time.Now())
+	if err != nil {
+		return record, token, err
+	}
+
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("stack_record_id = ? AND status IN ?", record.ID, []byte{models.Stacking, models.StackExpired}).
+		First(&token).Error
+
+	return record, token, err
+}
+
+func lockUnlockToken(tx *gorm.DB, bankID, productID uint) (models.Token, error) {
+	var token models.Token
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("bank_id = ? AND product_id = ? AND status = ?", bankID, productID, models.Unlock).
+		Limit(1).
+		Find(&token).Error
+	return token, err
+}
+
+func stackErrorCode(err error) int {
+	switch err {
+	case nil:
+		return status.Success
+	case ErrInsufficientToken:
+		return status.InsufficientToken
+	case ErrStackNotExpired:
+		return status.StackNotExpired
+	case ErrStackRecordStatusInvalid:
+		return status.StackRecordStatusInvalid
+	case ErrStackNotExchangeable:
+		return status.StackNotExchangeable
+	case ErrStackAutoRenew:
+		return status.StackAutoRenew
+	case ErrInsufficientBalance:
+		return status.InsufficientBalance
+	}
+
+	logrus.Error("Update stack record fail=", err)
+	return status.Unkonwn
 }
 
 func UpdateStackRecord(stackRecord *models.StackRecord) error {
@@ -144,8 +427,10 @@ func AddStackProfitRecord(bank *models.Bank, record *models.StackProfitRecord) e
 			return err
 		}
 
-		bank.InVarCoin = bank.InVarCoin.Add(record.Profit)
-		err = tx.Updates(&bank).Error
+		_, err = PostJournalEntry(tx, models.JournalStackProfit, record.ID, record.Comment, []LedgerLeg{
+			DebitUser(bank.UserID, models.AssetIVT, record.Profit),
+			CreditSystem(models.LedgerStackProfit, models.AssetIVT, record.Profit),
+		})
 		if err != nil {
 			return err
 		}
@@ -162,8 +447,10 @@ func DeleteStackProfitRecord(bank *models.Bank, record *models.StackProfitRecord
 			return err
 		}
 
-		bank.InVarCoin = bank.InVarCoin.Sub(record.Profit)
-		err = tx.Updates(&bank).Error
+		_, err = PostJournalEntry(tx, models.JournalStackProfitRevert, record.ID, record.Comment, []LedgerLeg{
+			CreditUser(bank.UserID, models.AssetIVT, record.Profit),
+			DebitSystem(models.LedgerStackProfit, models.AssetIVT, record.Profit),
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"invar/database"
+	"invar/models"
+	"invar/utils"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StackProfitPreview struct {
+	StackRecordID uint            `json:"stack_record_id"`
+	UserID        uint            `json:"user_id"`
+	StackID       uint            `json:"stack_id"`
+	Quantity      uint            `json:"quantity"`
+	Period        time.Time       `json:"period"`
+	Profit        decimal.Decimal `json:"profit"`
+}
+
+// DistributeStackProfits 發放所有已到期的配息，每一期以 (StackRecordID, Period) 唯一，重複執行不會重複發放。
+func DistributeStackProfits() {
+	now := time.Now()
+
+	records, err := getDueStackRecords(now)
+	if err != nil {
+		logrus.Error("Get due stack records fail=", err)
+		return
+	}
+
+	for _, record := range records {
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			return distributeStackRecordProfit(tx, record.ID, now)
+		})
+		if err != nil {
+			logrus.Error("Distribute stack profit fail, id=", record.ID, ", err=", err)
+		}
+	}
+}
+
+// PreviewStackProfits 試算到 until 為止會發放的配息，不會寫入任何資料。
+func PreviewStackProfits(until time.Time) ([]StackProfitPreview, error) {
+	previews := make([]StackProfitPreview, 0)
+
+	records, err := getDueStackRecords(until)
+	if err != nil {
+		return previews, err
+	}
+
+	stacks := make(map[uint]models.Stack)
+	for _, record := range records {
+		stack, ok := stacks[record.StackID]
+		if !ok {
+			stack, err = GetStack(record.StackID)
+			if err != nil {
+				return previews, err
+			}
+			stacks[record.StackID] = stack
+		}
+
+		for utils.HasDueProfit(&record, &stack, until) {
+			previews = append(previews, StackProfitPreview{
+				StackRecordID: record.ID,
+				UserID:        record.UserID,
+				StackID:       record.StackID,
+				Quantity:      record.Quantity,
+				Period:        record.NextGetProfitTime,
+				Profit:        utils.CalcStackProfit(&stack, record.Quantity),
+			})
+			utils.SetProfitTime(&record, &stack)
+		}
+	}
+
+	return previews, nil
+}
+
+func getDueStackRecords(until time.Time) ([]models.StackRecord, error) {
+	var records []models.StackRecord
+
+	result := database.DB.
+		Where("status IN ?", []byte{models.Stacking, models.StackExpired}).
+		Where("next_get_profit_time <= ? AND next_get_profit_time <= end_get_profit_time", until).
+		Order("id").
+		Find(&records)
+
+	return records, result.Error
+}
+
+func distributeStackRecordProfit(tx *gorm.DB, recordID uint, now time.Time) error {
+	var record models.StackRecord
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", recordID).First(&record).Error
+	if err != nil {
+		return err
+	}
+
+	if record.Status != models.Stacking && record.Status != models.StackExpired {
+		return nil
+	}
+
+	var stack models.Stack
+	err = tx.Where("id = ?", record.StackID).First(&stack).Error
+	if err != nil {
+		return err
+	}
+
+	for utils.HasDueProfit(&record, &stack, now) {
+		period := record.NextGetProfitTime
+		profitRecord := models.StackProfitRecord{
+			StackRecordID: record.ID,
+			Period:        &period,
+			Profit:        utils.CalcStackProfit(&stack, record.Quantity),
+			Comment:       "stack profit " + period.Format("2006-01-02"),
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&profitRecord)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// 已發放過的期數只推進時間
+		if result.RowsAffected > 0 && profitRecord.Profit.IsPositive() {
+			_, err = PostJournalEntry(tx, models.JournalStackProfit, profitRecord.ID, profitRecord.Comment, []LedgerLeg{
+				DebitUser(record.UserID, models.AssetIVT, profitRecord.Profit),
+				CreditSystem(models.LedgerStackProfit, models.AssetIVT, profitRecord.Profit),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		utils.SetProfitTime(&record, &stack)
+	}
+
+	return tx.Model(&record).UpdateColumn("next_get_profit_time", record.NextGetProfitTime).Error
+}
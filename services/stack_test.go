@@ -0,0 +1,131 @@
+package services
+
+import (
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"invar/utils"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 到期後排程尚未執行就解除質押，最後一期配息仍要在解除時發放
+func TestUnlockStackRecordPaysLastPeriod(t *testing.T) {
+	setupTestDB(t)
+
+	const userID = 990009
+
+	stack := models.Stack{
+		Profit:              decimal.RequireFromString("0.12"),
+		ContractTimeBound:   1,
+		ProfitIntervalMonth: 1,
+	}
+	err := database.DB.Create(&stack).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Truncate(time.Second).AddDate(0, -1, 0).Add(-time.Hour)
+	end := utils.AddPeriod(start, start, 1)
+	record := models.StackRecord{
+		Status:            models.Stacking,
+		UserID:            userID,
+		StackID:           stack.ID,
+		Quantity:          100,
+		ProfitStartTime:   start,
+		NextGetProfitTime: end,
+		EndGetProfitTime:  end,
+	}
+	err = database.DB.Create(&record).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bank := models.Bank{UserID: userID}
+	err = database.DB.Create(&bank).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := models.Token{
+		BankID:        bank.ID,
+		Status:        models.Stacking,
+		StackRecordID: record.ID,
+		Quantity:      decimal.NewFromInt(int64(record.Quantity)),
+	}
+	err = database.DB.Create(&token).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		var profitIDs []uint
+		database.DB.Model(&models.StackProfitRecord{}).Where("stack_record_id = ?", record.ID).Pluck("id", &profitIDs)
+		var entryIDs []uint
+		database.DB.Model(&models.JournalEntry{}).
+			Where("type = ? AND reference_id IN ?", models.JournalStackProfit, profitIDs).
+			Pluck("id", &entryIDs)
+		database.DB.Unscoped().Where("journal_entry_id IN ?", entryIDs).Delete(&models.Posting{})
+		database.DB.Unscoped().Where("id IN ?", entryIDs).Delete(&models.JournalEntry{})
+		database.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.LedgerAccount{})
+		database.DB.Unscoped().Where("stack_record_id = ?", record.ID).Delete(&models.StackProfitRecord{})
+		database.DB.Unscoped().Where("bank_id = ?", bank.ID).Delete(&models.Token{})
+		database.DB.Unscoped().Where("user_id = ?", userID).Delete(&models.Bank{})
+		database.DB.Unscoped().Delete(&record)
+		database.DB.Unscoped().Delete(&stack)
+	})
+
+	errCode := UnlockStackRecord(&record)
+	if errCode != status.Success {
+		t.Fatal("unlock stack record fail, code=", errCode)
+	}
+
+	var profits []models.StackProfitRecord
+	err = database.DB.Where("stack_record_id = ?", record.ID).Find(&profits).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(profits) != 1 {
+		t.Fatalf("expected 1 profit record, got %d", len(profits))
+	}
+	if !profits[0].Period.Equal(end) {
+		t.Errorf("expected period %v, got %v", end, profits[0].Period)
+	}
+
+	expected := utils.CalcStackProfit(&stack, record.Quantity)
+	if !profits[0].Profit.Equal(expected) {
+		t.Errorf("expected profit %s, got %s", expected, profits[0].Profit)
+	}
+
+	var entries int64
+	err = database.DB.Model(&models.JournalEntry{}).
+		Where("type = ? AND reference_id = ?", models.JournalStackProfit, profits[0].ID).
+		Count(&entries).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("expected 1 journal entry for the last period, got %d", entries)
+	}
+
+	var reloaded models.Bank
+	err = database.DB.First(&reloaded, bank.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.InVarCoin.Equal(expected) {
+		t.Errorf("expected IVT balance %s, got %s", expected, reloaded.InVarCoin)
+	}
+
+	var unlocked models.StackRecord
+	err = database.DB.First(&unlocked, record.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.Status != models.Unlock {
+		t.Errorf("expected status %d, got %d", models.Unlock, unlocked.Status)
+	}
+}
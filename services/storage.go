@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"invar/database"
+	"invar/models"
+	"invar/storage"
+	"invar/utils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultStorageLocalDir   = "./private"
+	defaultStorageURLExpires = time.Hour
+	// ProductImagePrefix 商品圖片在儲存後端的 key 前綴
+	ProductImagePrefix = "public/"
+	kycDocumentPrefix  = "kyc/"
+	// legacyStaticDir 舊版商品圖片存放在 static 底下，資料庫保存 /static/public/xxx.png
+	legacyStaticDir    = "./static"
+	legacyStaticPrefix = "/static/"
+)
+
+var storageURLExpires = defaultStorageURLExpires
+
+// InitStorage 依 STORAGE_DRIVER 建立上傳檔案的儲存後端，支援 local 與 s3。
+// local 的下載網址由 /api/v1/file 以 STORAGE_SIGNING_KEY 驗證簽章。
+func InitStorage() {
+	storageURLExpires = utils.EnvDuration("STORAGE_URL_EXPIRES", defaultStorageURLExpires)
+
+	switch strings.ToLower(os.Getenv("STORAGE_DRIVER")) {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = defaultStorageLocalDir
+		}
+
+		signingKey := []byte(os.Getenv("STORAGE_SIGNING_KEY"))
+		if len(signingKey) == 0 {
+			logrus.Warn("STORAGE_SIGNING_KEY is empty, signed file urls will be invalid after restart")
+			signingKey = make([]byte, 32)
+			_, err := rand.Read(signingKey)
+			if err != nil {
+				panic("Generate storage signing key fail: " + err.Error())
+			}
+		}
+
+		baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + "/api/v1/file"
+		local, err := storage.NewLocal(dir, baseURL, signingKey)
+		if err != nil {
+			panic("Init local storage fail: " + err.Error())
+		}
+		storage.SetDefault(local)
+	case "s3":
+		s3, err := storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: strings.EqualFold(os.Getenv("S3_PATH_STYLE"), "true"),
+		})
+		if err != nil {
+			panic("Init s3 storage fail: " + err.Error())
+		}
+		storage.SetDefault(s3)
+	default:
+		panic("Unsupported STORAGE_DRIVER=" + os.Getenv("STORAGE_DRIVER"))
+	}
+}
+
+// DeleteStoredFile 刪除儲存後端的物件，舊版 static 路徑會刪除本機檔案。
+func DeleteStoredFile(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(key, legacyStaticPrefix) {
+		err := os.Remove("." + key)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	backend := storage.Default()
+	if backend == nil {
+		return storage.ErrNotConfigured
+	}
+
+	return backend.Delete(context.Background(), key)
+}
+
+// StoredFileURL 產生物件的限時下載網址，尚未搬移的舊版路徑原樣回傳。
+func StoredFileURL(key string) string {
+	if key == "" || strings.HasPrefix(key, legacyStaticPrefix) {
+		return key
+	}
+
+	backend := storage.Default()
+	if backend == nil {
+		return ""
+	}
+
+	url, err := backend.SignedURL(context.Background(), key, storageURLExpires)
+	if err != nil {
+		logrus.Error("Sign file url fail, key=", key, ", err=", err)
+		return ""
+	}
+
+	return url
+}
+
+// OpenStoredFile 讀取儲存後端的物件，呼叫端需關閉回傳的 ReadCloser。
+func OpenStoredFile(key string) (io.ReadCloser, storage.ObjectInfo, error) {
+	backend := storage.Default()
+	if backend == nil {
+		return nil, storage.ObjectInfo{}, storage.ErrNotConfigured
+	}
+
+	return backend.Get(context.Background(), key)
+}
+
+// VerifyStoredFileURL 驗證本機儲存產生的下載網址，其他儲存後端由服務商直接提供檔案。
+// KYC 檔案只能經由記錄讀取紀錄的 API 取得，不接受下載網址。
+func VerifyStoredFileURL(key, expiresAt, signature string) error {
+	local, ok := storage.Default().(*storage.Local)
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	if strings.HasPrefix(strings.TrimPrefix(key, "/"), kycDocumentPrefix) {
+		return storage.ErrInvalidSignature
+	}
+
+	return local.Verify(key, expiresAt, signature)
+}
+
+// MigrateStorage 將舊版存放在本機的檔案搬移到目前設定的儲存後端，可重複執行。
+//   - static 底下的商品圖片搬移後改寫 Product.Image 與 PreviewImage
+//   - 舊版 UserKYC 明文照片加密後改為 KYCDocument
+//   - KYC_STORAGE_DIR 內已加密的 KYC 檔案搬移到 kyc/ 前綴下
+func MigrateStorage() error {
+	if storage.Default() == nil {
+		return storage.ErrNotConfigured
+	}
+
+	legacyKYCDir := os.Getenv("KYC_STORAGE_DIR")
+	if legacyKYCDir == "" {
+		legacyKYCDir = defaultLegacyKYCDir
+	}
+
+	err := migrateProductImages()
+	if err != nil {
+		return err
+	}
+
+	migrateLegacyKYCFiles()
+
+	return migrateKYCDocuments(legacyKYCDir)
+}
+
+func migrateProductImages() error {
+	var products []models.Product
+	err := database.DB.Where("image LIKE ? OR preview_image LIKE ?", legacyStaticPrefix+"%", legacyStaticPrefix+"%").
+		Find(&products).Error
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		image, err := migrateStaticFile(product.Image)
+		if err != nil {
+			logrus.Error("Migrate product image fail, product=", product.ID, ", err=", err)
+			continue
+		}
+
+		previewImage, err := migrateStaticFile(product.PreviewImage)
+		if err != nil {
+			logrus.Error("Migrate product preview image fail, product=", product.ID, ", err=", err)
+			continue
+		}
+
+		err = database.DB.Model(&product).UpdateColumns(map[string]interface{}{
+			"image":         image,
+			"preview_image": previewImage,
+		}).Error
+		if err != nil {
+			logrus.Error("Update migrated product fail, product=", product.ID, ", err=", err)
+			continue
+		}
+
+		removeLegacyFile(product.Image, image)
+		removeLegacyFile(product.PreviewImage, previewImage)
+	}
+
+	return nil
+}
+
+// migrateStaticFile 將 /static/ 底下的檔案寫入儲存後端，非舊版路徑原樣回傳。
+func migrateStaticFile(legacy string) (string, error) {
+	if !strings.HasPrefix(legacy, legacyStaticPrefix) {
+		return legacy, nil
+	}
+
+	key := ProductImagePrefix + path.Base(legacy)
+	err := copyFileToStorage(filepath.Join(legacyStaticDir, filepath.FromSlash(strings.TrimPrefix(legacy, legacyStaticPrefix))), key)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// migrateKYCDocuments 搬移舊版直接寫在 legacyKYCDir 的加密檔案。
+// StorageKey 同時是資料金鑰的驗證資料，因此只改變物件位置，不改寫 StorageKey。
+func migrateKYCDocuments(legacyKYCDir string) error {
+	var docs []models.KYCDocument
+	err := database.DB.Order("id").Find(&docs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		key := kycObjectKey(doc.StorageKey)
+		_, err := storage.Default().Stat(context.Background(), key)
+		if err == nil {
+			continue
+		}
+		if err != storage.ErrNotFound {
+			logrus.Error("Stat kyc document fail, document=", doc.ID, ", err=", err)
+			continue
+		}
+
+		legacyPath := filepath.Join(legacyKYCDir, doc.StorageKey)
+		err = copyFileToStorage(legacyPath, key)
+		if err != nil {
+			logrus.Error("Migrate kyc document fail, document=", doc.ID, ", err=", err)
+			continue
+		}
+
+		removeLegacyFile(legacyPath, key)
+	}
+
+	return nil
+}
+
+func copyFileToStorage(filePath, key string) error {
+	backend := storage.Default()
+
+	// 本機後端的物件與來源是同一個檔案時不需要搬移
+	if local, ok := backend.(*storage.Local); ok {
+		target, err := local.Path(key)
+		if err != nil {
+			return err
+		}
+		if sameFile(filePath, target) {
+			return nil
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return backend.Put(context.Background(), key, file, stat.Size(), storage.ContentTypeByKey(key))
+}
+
+// removeLegacyFile 搬移完成後刪除來源檔案，來源與物件是同一個檔案時保留。
+func removeLegacyFile(legacy, key string) {
+	if legacy == key {
+		return
+	}
+
+	filePath := legacy
+	if strings.HasPrefix(legacy, legacyStaticPrefix) {
+		filePath = filepath.Join(legacyStaticDir, filepath.FromSlash(strings.TrimPrefix(legacy, legacyStaticPrefix)))
+	}
+
+	if local, ok := storage.Default().(*storage.Local); ok {
+		target, err := local.Path(key)
+		if err == nil && sameFile(filePath, target) {
+			return
+		}
+	}
+
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Error("Remove legacy file fail=", err)
+	}
+}
+
+func sameFile(a, b string) bool {
+	statA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	statB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(statA, statB)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/utils"
+	"strings"
+	"time"
+
+	"github.com/sec51/twofactor"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// 驗證時接受前後各一個 30 秒的時間窗，使用過的驗證碼需保留到三個時間窗都過去
+	tfaReplayWindow = 90 * time.Second
+)
+
+var (
+	ErrTFAEnabled             = errors.New("tfa was enabled")
+	ErrTFANotEnabled          = errors.New("tfa was not enabled")
+	ErrIncorrectTFA           = errors.New("incorrect tfa code")
+	ErrTFACodeUsed            = errors.New("tfa code was used")
+	ErrIncorrectRecoveryCode  = errors.New("incorrect recovery code")
+	recoveryCodeEncoding      = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryCodeSeparatorTrim = strings.NewReplacer("-", "", " ", "")
+)
+
+// validateTOTP 驗證 TOTP 驗證碼，同一個驗證碼在有效時間內只能使用一次。
+func validateTOTP(roleType, roleID uint, tfaCode []byte, issuer, code string) error {
+	var ctx = context.Background()
+
+	otp, err := twofactor.TOTPFromBytes(tfaCode, issuer)
+	if err != nil {
+		return err
+	}
+
+	err = otp.Validate(code)
+	if err != nil {
+		return ErrIncorrectTFA
+	}
+
+	key := database.TFAUsedCache + ":" + tokenVersionField(roleType, roleID) + ":" + code
+	ok, err := database.RDS.SetNX(ctx, key, 1, tfaReplayWindow).Result()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrTFACodeUsed
+	}
+
+	return nil
+}
+
+// generateRecoveryCodes 產生新的救援碼並作廢舊的，明碼只在此時回傳一次。
+func generateRecoveryCodes(tx *gorm.DB, roleType, roleID uint) ([]string, error) {
+	err := deleteRecoveryCodes(tx, roleType, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	recoveryCodes := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, 5)
+		_, err := rand.Read(bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(bytes))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{
+			RoleType: roleType,
+			RoleID:   roleID,
+			CodeHash: utils.HashToken(code),
+		})
+	}
+
+	err = tx.Create(&recoveryCodes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func deleteRecoveryCodes(tx *gorm.DB, roleType, roleID uint) error {
+	return tx.Where("role_type = ? AND role_id = ?", roleType, roleID).Delete(&models.RecoveryCode{}).Error
+}
+
+// useRecoveryCode 使用一組救援碼，使用後即失效。
+func useRecoveryCode(roleType, roleID uint, code string) error {
+	code = strings.ToLower(recoveryCodeSeparatorTrim.Replace(code))
+	if code == "" {
+		return ErrIncorrectRecoveryCode
+	}
+
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("role_type = ? AND role_id = ? AND code_hash = ? AND used_at IS NULL", roleType, roleID, utils.HashToken(code)).
+		UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrIncorrectRecoveryCode
+	}
+
+	return nil
+}
+
+// GetRecoveryCodeCount 取得剩餘可用的救援碼數量。
+func GetRecoveryCodeCount(roleType, roleID uint) (int64, error) {
+	var count int64
+	err := database.DB.Model(&models.RecoveryCode{}).
+		Where("role_type = ? AND role_id = ? AND used_at IS NULL", roleType, roleID).
+		Count(&count).Error
+	return count, err
+}
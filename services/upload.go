@@ -0,0 +1,90 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"invar/database"
+	"invar/storage"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Upload 交易式上傳。檔案先寫入儲存後端，等資料庫寫入成功後才算完成；
+// 任一步驟失敗時刪除本次寫入的檔案，成功後才刪除被取代的舊檔案。
+// 程序在兩者之間中斷留下的檔案由 SweepOrphanFiles 清除。
+type Upload struct {
+	staged   []string
+	replaced []string
+}
+
+func NewUpload() *Upload {
+	return &Upload{}
+}
+
+// Put 寫入檔案並記錄，Commit 失敗或呼叫 Rollback 時會刪除
+func (u *Upload) Put(key string, r io.Reader, size int64, contentType string) error {
+	backend := storage.Default()
+	if backend == nil {
+		return storage.ErrNotConfigured
+	}
+
+	// 先記錄再寫入，寫到一半失敗時 Rollback 也會嘗試刪除
+	u.staged = append(u.staged, key)
+	return backend.Put(context.Background(), key, r, size, contentType)
+}
+
+// Save 以隨機檔名寫入資料，回傳物件的 key
+func (u *Upload) Save(prefix, ext, contentType string, data []byte) (string, error) {
+	key := prefix + uuid.New().String() + ext
+	err := u.Put(key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Replace 登記被新檔案取代的舊檔案，Commit 成功後才刪除
+func (u *Upload) Replace(keys ...string) {
+	for _, key := range keys {
+		if key != "" {
+			u.replaced = append(u.replaced, key)
+		}
+	}
+}
+
+// Commit 在交易中執行 fn，失敗時刪除本次寫入的檔案
+func (u *Upload) Commit(fn func(tx *gorm.DB) error) error {
+	err := database.DB.Transaction(fn)
+	if err != nil {
+		u.Rollback()
+		return err
+	}
+
+	for _, key := range u.replaced {
+		err := DeleteStoredFile(key)
+		if err != nil {
+			logrus.Error("Remove replaced file fail, key=", key, ", err=", err)
+		}
+	}
+
+	u.staged = nil
+	u.replaced = nil
+	return nil
+}
+
+// Rollback 刪除本次寫入的檔案，驗證失敗等未進入 Commit 的情況需自行呼叫
+func (u *Upload) Rollback() {
+	for _, key := range u.staged {
+		err := DeleteStoredFile(key)
+		if err != nil {
+			logrus.Error("Remove staged file fail, key=", key, ", err=", err)
+		}
+	}
+
+	u.staged = nil
+	u.replaced = nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"invar/models"
+	"invar/storage"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func setupTestStorage(t *testing.T) storage.Storage {
+	local, err := storage.NewLocal(t.TempDir(), "http://localhost/api/v1/file", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.SetDefault(local)
+	t.Cleanup(func() { storage.SetDefault(nil) })
+	return local
+}
+
+func TestUploadCommitFailRemovesStagedFiles(t *testing.T) {
+	setupTestDB(t)
+	backend := setupTestStorage(t)
+
+	upload := NewUpload()
+	keep, err := upload.Save(ProductImagePrefix, ".png", "image/png", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = upload.Commit(func(tx *gorm.DB) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload = NewUpload()
+	staged, err := upload.Save(ProductImagePrefix, ".png", "image/png", []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Replace(keep)
+
+	err = upload.Commit(func(tx *gorm.DB) error {
+		return tx.Create(&models.Product{Title: "upload test"}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backend.Stat(context.Background(), keep)
+	if err != storage.ErrNotFound {
+		t.Fatal("replaced file should be deleted after commit, err=", err)
+	}
+
+	upload = NewUpload()
+	failed, err := upload.Save(ProductImagePrefix, ".png", "image/png", []byte("fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Replace(staged)
+
+	err = upload.Commit(func(tx *gorm.DB) error { return errors.New("db write fail") })
+	if err == nil {
+		t.Fatal("commit should fail")
+	}
+
+	_, err = backend.Stat(context.Background(), failed)
+	if err != storage.ErrNotFound {
+		t.Fatal("staged file should be deleted after failed commit, err=", err)
+	}
+
+	_, err = backend.Stat(context.Background(), staged)
+	if err != nil {
+		t.Fatal("replaced file should be kept when commit fails, err=", err)
+	}
+}
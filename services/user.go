@@ -17,7 +17,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// RegisterUser 建立使用者、邀請碼與帳戶，新使用者的狀態為已註冊。
 func RegisterUser(user *models.User) error {
+	user.Status = models.Registed
+
 	err := database.DB.Transaction(func(tx *gorm.DB) error {
 		err := tx.Create(user).Error
 		if err != nil {
@@ -29,9 +32,19 @@ func RegisterUser(user *models.User) error {
 		if err != nil {
 			return err
 		}
+
+		_, err = getOrCreateBank(tx, user.ID)
+		if err != nil {
+			return err
+		}
 		return nil
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	cacheReferrerCode(user.ReferrerCode, user.ID)
+	return nil
 }
 
 func GetUserByEmail(email string) (models.User, error) {
@@ -58,6 +71,50 @@ func GetUserById(id uint) (models.User, error) {
 	return user, nil
 }
 
+// ChangeUserPassword 更新密碼並遞增令牌版本，已簽發的 access token 會立即失效。
+func ChangeUserPassword(user *models.User, password string) error {
+	user.SetPassword(password)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumn("password", user.Password).Error
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeUser, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleTypeUser, user.ID)
+	return nil
+}
+
+// SetUserStatus 更新使用者狀態，停用時一併讓已簽發的 access token 失效。
+func SetUserStatus(user *models.User, userStatus byte) error {
+	user.Status = userStatus
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumn("status", user.Status).Error
+		if err != nil {
+			return err
+		}
+
+		if userStatus != models.Disabled {
+			return nil
+		}
+
+		return BumpTokenVersion(tx, roleTypeUser, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	clearTokenVersionCache(roleTypeUser, user.ID)
+	return nil
+}
+
 func UpdateUser(user *models.User) error {
 	result := database.DB.Updates(&user)
 
@@ -175,8 +232,13 @@ func CheckRepeatUserEmail(email string) error {
 func GetUserTFA(user *models.User) ([]byte, error) {
 	var otp *twofactor.Totp
 	var err error
+
+	if user.TFAEnable {
+		return nil, ErrTFAEnabled
+	}
+
 	if user.TFACode == nil {
-		otp, err := twofactor.NewTOTP(user.Email, user.UserName, crypto.SHA1, 6)
+		otp, err = twofactor.NewTOTP(user.Email, user.UserName, crypto.SHA1, 6)
 		if err != nil {
 			return nil, err
 		}
@@ -187,7 +249,7 @@ func GetUserTFA(user *models.User) ([]byte, error) {
 		}
 
 		user.TFACode = data
-		err = UpdateUser(user)
+		err = database.DB.Model(user).UpdateColumn("tfa_code", user.TFACode).Error
 		if err != nil {
 			return nil, err
 		}
@@ -206,66 +268,106 @@ func GetUserTFA(user *models.User) ([]byte, error) {
 	return qrCode, nil
 }
 
-func EnableUserTFA(user *models.User, code string) error {
+// EnableUserTFA 驗證成功後啟用二階段驗證，並回傳新的救援碼。
+func EnableUserTFA(user *models.User, code string) ([]string, error) {
 	if user.TFAEnable {
-		return errors.New("Enabled")
+		return nil, ErrTFAEnabled
 	}
 
-	otp, err := twofactor.TOTPFromBytes(user.TFACode, user.UserName)
-	if err != nil {
-		return err
+	if user.TFACode == nil {
+		return nil, ErrIncorrectTFA
 	}
 
-	err = otp.Validate(code)
+	err := validateTOTP(roleTypeUser, user.ID, user.TFACode, user.UserName, code)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	user.TFAEnable = true
-	err = UpdateUser(user)
-	if err != nil {
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumn("tfa_enable", true).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = generateRecoveryCodes(tx, roleTypeUser, user.ID)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	user.TFAEnable = true
+	return codes, nil
 }
 
+// CheckUserTFA 未啟用二階段驗證時直接通過。
 func CheckUserTFA(user *models.User, code string) error {
-	otp, err := twofactor.TOTPFromBytes(user.TFACode, user.UserName)
-	if err != nil {
-		return err
+	if !user.TFAEnable {
+		return nil
 	}
 
+	return validateTOTP(roleTypeUser, user.ID, user.TFACode, user.UserName, code)
+}
+
+// CheckUserRecoveryCode 以救援碼取代 TOTP 驗證碼，救援碼使用後即失效。
+func CheckUserRecoveryCode(user *models.User, code string) error {
 	if !user.TFAEnable {
-		return nil
+		return ErrTFANotEnabled
+	}
+
+	return useRecoveryCode(roleTypeUser, user.ID, code)
+}
+
+// RegenerateUserRecoveryCodes 重新產生救援碼，舊的救援碼全部失效。
+func RegenerateUserRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TFAEnable {
+		return nil, ErrTFANotEnabled
 	}
 
-	err = otp.Validate(code)
+	err := CheckUserTFA(user, code)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	var codes []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		codes, err = generateRecoveryCodes(tx, roleTypeUser, user.ID)
+		return err
+	})
+
+	return codes, err
 }
 
+// DisableUserTFA 停用二階段驗證並清除金鑰與救援碼，管理者停用時不需驗證碼。
 func DisableUserTFA(user *models.User, code string, byAdmin bool) error {
-	var err error
 	if !user.TFAEnable {
-		return errors.New("Disabled")
+		return ErrTFANotEnabled
 	}
 
 	if !byAdmin {
-		err = CheckUserTFA(user, code)
+		err := CheckUserTFA(user, code)
 		if err != nil {
 			return err
 		}
 	}
 
-	user.TFAEnable = false
-	err = UpdateUser(user)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).UpdateColumns(map[string]interface{}{
+			"tfa_enable": false,
+			"tfa_code":   nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return deleteRecoveryCodes(tx, roleTypeUser, user.ID)
+	})
 	if err != nil {
 		return err
 	}
 
+	user.TFAEnable = false
+	user.TFACode = nil
 	return nil
 }
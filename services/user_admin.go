@@ -0,0 +1,256 @@
+package services
+
+import (
+	"errors"
+	"invar/database"
+	"invar/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+const (
+	KYCStateNone      = "none"
+	KYCStateSubmitted = "submitted"
+)
+
+var (
+	ErrUserStatusNotChanged = errors.New("user status not changed")
+	likeEscaper             = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+)
+
+// UserSearch 管理者搜尋使用者的條件，空值代表不篩選
+type UserSearch struct {
+	Email       string    `form:"email"`
+	UserName    string    `form:"username"`
+	Status      byte      `form:"status"`
+	KYCState    string    `form:"kyc_state"`
+	CreatedFrom time.Time `form:"created_from" time_format:"2006-01-02"`
+	CreatedTo   time.Time `form:"created_to" time_format:"2006-01-02"`
+	ReferrerID  uint      `form:"referrer_id"`
+	PageNumber  int       `form:"page_number"`
+	PerPage     int       `form:"per_page"`
+}
+
+type UserSearchResult struct {
+	Total      int64         `json:"total"`
+	PageNumber int           `json:"page_number"`
+	PerPage    int           `json:"per_page"`
+	Users      []models.User `json:"users"`
+}
+
+// UserDetail 管理者檢視使用者時彙整的資料
+type UserDetail struct {
+	User         models.User            `json:"user"`
+	Bank         models.Bank            `json:"bank"`
+	Orders       []models.Order         `json:"orders"`
+	StackRecords []models.StackRecord   `json:"stack_records"`
+	StatusLogs   []models.UserStatusLog `json:"status_logs"`
+	RefereeCount int64                  `json:"referee_count"`
+}
+
+// SearchUsers 依條件分頁搜尋使用者，信箱與名稱為部分比對。
+func SearchUsers(search UserSearch) (UserSearchResult, error) {
+	result := UserSearchResult{
+		PageNumber: search.PageNumber,
+		PerPage:    search.PerPage,
+		Users:      []models.User{},
+	}
+
+	if result.PageNumber <= 0 {
+		result.PageNumber = 1
+	}
+
+	if result.PerPage <= 0 {
+		result.PerPage = defaultUserPageSize
+	}
+
+	if result.PerPage > maxUserPageSize {
+		result.PerPage = maxUserPageSize
+	}
+
+	query := database.DB.Model(&models.User{})
+	if search.Email != "" {
+		query = query.Where("email ILIKE ?", "%"+escapeLike(search.Email)+"%")
+	}
+
+	if search.UserName != "" {
+		query = query.Where("user_name ILIKE ?", "%"+escapeLike(search.UserName)+"%")
+	}
+
+	if search.Status != 0 {
+		query = query.Where("status = ?", search.Status)
+	}
+
+	switch search.KYCState {
+	case KYCStateNone:
+		query = query.Where("NOT EXISTS (SELECT 1 FROM user_kycs WHERE user_kycs.user_id = users.id AND user_kycs.deleted_at IS NULL)")
+	case KYCStateSubmitted:
+		query = query.Where("EXISTS (SELECT 1 FROM user_kycs WHERE user_kycs.user_id = users.id AND user_kycs.deleted_at IS NULL)")
+	}
+
+	if !search.CreatedFrom.IsZero() {
+		query = query.Where("created_at >= ?", search.CreatedFrom)
+	}
+
+	if !search.CreatedTo.IsZero() {
+		query = query.Where("created_at < ?", search.CreatedTo.AddDate(0, 0, 1))
+	}
+
+	if search.ReferrerID != 0 {
+		query = query.Where("referrer_id = ?", search.ReferrerID)
+	}
+
+	err := query.Count(&result.Total).Error
+	if err != nil {
+		return result, err
+	}
+
+	err = query.Order("id desc").
+		Offset((result.PageNumber - 1) * result.PerPage).
+		Limit(result.PerPage).
+		Find(&result.Users).Error
+
+	return result, err
+}
+
+// GetUserDetail 彙整使用者的實名、錢包、訂單與質押紀錄。
+func GetUserDetail(userID uint) (UserDetail, error) {
+	var detail UserDetail
+
+	err := database.DB.Preload("UserKYC").First(&detail.User, userID).Error
+	if err != nil {
+		return detail, err
+	}
+
+	detail.Bank, err = GetBank(userID)
+	if err != nil {
+		return detail, err
+	}
+
+	detail.Orders, err = GetOrders(userID)
+	if err != nil {
+		return detail, err
+	}
+
+	detail.StackRecords, err = GetStacksRecord(userID)
+	if err != nil {
+		return detail, err
+	}
+
+	err = database.DB.Where("user_id = ?", userID).Order("id desc").Find(&detail.StatusLogs).Error
+	if err != nil {
+		return detail, err
+	}
+
+	err = database.DB.Model(&models.User{}).Where("referrer_id = ?", userID).Count(&detail.RefereeCount).Error
+	return detail, err
+}
+
+// DisableUser 停用使用者並登出所有裝置，記錄停用原因。
+func DisableUser(adminID, userID uint, reason, ip string) (models.User, error) {
+	var user models.User
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if err != nil {
+			return err
+		}
+
+		if user.Status == models.Disabled {
+			return ErrUserStatusNotChanged
+		}
+
+		err = changeUserStatus(tx, &user, adminID, models.Disabled, reason)
+		if err != nil {
+			return err
+		}
+
+		err = revokeSessions(tx, roleTypeUser, user.ID, 0, ip)
+		if err != nil {
+			return err
+		}
+
+		return BumpTokenVersion(tx, roleTypeUser, user.ID)
+	})
+	if err != nil {
+		return user, err
+	}
+
+	clearTokenVersionCache(roleTypeUser, user.ID)
+	return user, nil
+}
+
+// EnableUser 啟用使用者，還原為停用前的狀態。
+func EnableUser(adminID, userID uint, reason string) (models.User, error) {
+	var user models.User
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if err != nil {
+			return err
+		}
+
+		if user.Status != models.Disabled {
+			return ErrUserStatusNotChanged
+		}
+
+		var log models.UserStatusLog
+		result := tx.Where("user_id = ? AND to_status = ?", user.ID, models.Disabled).
+			Order("id desc").Limit(1).Find(&log)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		userStatus := byte(models.Registed)
+		if result.RowsAffected != 0 && log.FromStatus != models.Disabled {
+			userStatus = log.FromStatus
+		}
+
+		return changeUserStatus(tx, &user, adminID, userStatus, reason)
+	})
+
+	return user, err
+}
+
+func UpdateUserComment(userID uint, comment string) (models.User, error) {
+	var user models.User
+
+	err := database.DB.First(&user, userID).Error
+	if err != nil {
+		return user, err
+	}
+
+	user.Comment = comment
+	err = database.DB.Model(&user).UpdateColumn("comment", comment).Error
+	return user, err
+}
+
+func changeUserStatus(tx *gorm.DB, user *models.User, adminID uint, userStatus byte, reason string) error {
+	log := models.UserStatusLog{
+		UserID:     user.ID,
+		AdminID:    adminID,
+		FromStatus: user.Status,
+		ToStatus:   userStatus,
+		Reason:     reason,
+	}
+
+	err := tx.Create(&log).Error
+	if err != nil {
+		return err
+	}
+
+	user.Status = userStatus
+	return tx.Model(user).UpdateColumn("status", user.Status).Error
+}
+
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
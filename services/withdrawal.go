@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"invar/database"
+	"invar/models"
+	"invar/status"
+	"invar/utils"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrWithdrawalStatusInvalid = errors.New(status.ErrorText(status.WithdrawalStatusInvalid))
+var ErrWithdrawalCodeIncorrect = errors.New(status.ErrorText(status.ValidCodeIsIncorrect))
+var ErrWithdrawalCodeExpired = errors.New(status.ErrorText(status.ValidCodeIsExpired))
+
+const (
+	withdrawalCodeExpire   = 5 * time.Minute
+	withdrawalCodeInterval = 1 * time.Minute
+)
+
+var withdrawalTransitions = map[uint][]uint{
+	models.WithdrawalRequested: {models.WithdrawalHeld, models.WithdrawalRejected},
+	models.WithdrawalHeld:      {models.WithdrawalApproved, models.WithdrawalRejected},
+	models.WithdrawalApproved:  {models.WithdrawalBroadcast, models.WithdrawalRejected},
+	models.WithdrawalBroadcast: {models.WithdrawalCompleted},
+}
+
+func GetWithdrawals(userID uint, withdrawalStatus uint) ([]models.Withdrawal, error) {
+	var withdrawals []models.Withdrawal
+
+	result := database.DB.Order("id desc")
+	if userID != 0 {
+		result = result.Where("user_id = ?", userID)
+	}
+
+	if withdrawalStatus != 0 {
+		result = result.Where("status = ?", withdrawalStatus)
+	}
+
+	result = result.Find(&withdrawals)
+	if result.Error != nil {
+		return withdrawals, result.Error
+	}
+
+	return withdrawals, nil
+}
+
+func GetWithdrawal(id uint) (models.Withdrawal, error) {
+	var withdrawal models.Withdrawal
+
+	result := database.DB.Where("id = ?", id).First(&withdrawal)
+	if result.Error != nil {
+		return withdrawal, result.Error
+	}
+
+	return withdrawal, nil
+}
+
+// AddWithdrawal 建立提領申請並寄出綁定此申請的信箱驗證碼，需確認驗證碼後才會凍結資金。
+func AddWithdrawal(user *models.User, whitelist *models.WhiteList, asset string, quantity decimal.Decimal) (models.Withdrawal, int) {
+	var withdrawal models.Withdrawal
+
+	if asset != models.AssetIVT && asset != models.AssetUSDT {
+		return withdrawal, status.UnsupportedAsset
+	}
+
+	if !quantity.IsPositive() {
+		return withdrawal, status.BadRequest
+	}
+
+	fee := calcWithdrawalFee(quantity)
+
+	bank, err := GetBank(user.ID)
+	if err != nil {
+		return withdrawal, status.Unkonwn
+	}
+
+	balance := bank.InVarCoin
+	if asset == models.AssetUSDT {
+		balance = bank.USDTCoin
+	}
+
+	if balance.LessThan(quantity.Add(fee)) {
+		return withdrawal, status.InsufficientBalance
+	}
+
+	withdrawal = models.Withdrawal{
+		UserID:      user.ID,
+		WhiteListID: whitelist.ID,
+		Asset:       asset,
+		Quantity:    quantity,
+		Fee:         fee,
+		Status:      models.WithdrawalRequested,
+		ChainName:   whitelist.ChainName,
+		Address:     whitelist.Address,
+	}
+
+	var ctx = context.Background()
+	ok, err := database.RDS.SetNX(ctx, withdrawalCodeIntervalKey(user.ID), 1, withdrawalCodeInterval).Result()
+	if err != nil {
+		logrus.Error("Set withdrawal code interval fail=", err)
+		return withdrawal, status.Unkonwn
+	}
+	if !ok {
+		return withdrawal, status.RequestTooFrequently
+	}
+
+	code := generateMailCode()
+	err = database.DB.Create(&withdrawal).Error
+	if err != nil {
+		logrus.Error("Add withdrawal fail=", err)
+		return withdrawal, status.Unkonwn
+	}
+
+	err = database.RDS.Set(ctx, withdrawalCodeKey(withdrawal.ID), code, withdrawalCodeExpire).Err()
+	if err != nil {
+		logrus.Error("Set withdrawal code fail=", err)
+		removeUnverifiedWithdrawal(ctx, &withdrawal)
+		return withdrawal, status.Unkonwn
+	}
+
+	// 寄信不放在資料庫交易內，避免郵件伺服器緩慢時長時間占用連線，寄送失敗時移除無法確認的提領申請
+	err = utils.DeliverEmail(user.Email, "Invar提領驗證碼", "您提領 "+withdrawal.Quantity.String()+" "+asset+" 至 "+withdrawal.Address+" 的驗證碼為「"+code+"」")
+	if err != nil {
+		logrus.Error("Send withdrawal code fail=", err)
+		removeUnverifiedWithdrawal(ctx, &withdrawal)
+		return withdrawal, status.SendEmailFail
+	}
+
+	return withdrawal, status.Success
+}
+
+// removeUnverifiedWithdrawal 移除尚未寄出驗證碼的提領申請，此時尚未凍結資金
+func removeUnverifiedWithdrawal(ctx context.Context, withdrawal *models.Withdrawal) {
+	database.RDS.Del(ctx, withdrawalCodeKey(withdrawal.ID))
+
+	err := database.DB.Unscoped().Delete(withdrawal).Error
+	if err != nil {
+		logrus.Error("Remove unverified withdrawal fail, id=", withdrawal.ID, ", err=", err)
+	}
+}
+
+// VerifyWithdrawalCode 驗證提領申請的信箱驗證碼，驗證碼只能用於寄出時的提領申請且只能使用一次。
+func VerifyWithdrawalCode(withdrawal *models.Withdrawal, code string) error {
+	var ctx = context.Background()
+	key := withdrawalCodeKey(withdrawal.ID)
+
+	expected, err := database.RDS.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return ErrWithdrawalCodeExpired
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+		return ErrWithdrawalCodeIncorrect
+	}
+
+	// 同時送出相同驗證碼時只有成功刪除的請求可以使用
+	deleted, err := database.RDS.Del(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWithdrawalCodeExpired
+	}
+
+	return nil
+}
+
+func withdrawalCodeKey(withdrawalID uint) string {
+	return database.WithdrawalCodeCache + ":" + strconv.Itoa(int(withdrawalID))
+}
+
+func withdrawalCodeIntervalKey(userID uint) string {
+	return database.WithdrawalCodeCache + ":interval:" + strconv.Itoa(int(userID))
+}
+
+// ConfirmWithdrawal 使用者以信箱驗證碼確認後凍結提領金額與手續費。
+func ConfirmWithdrawal(withdrawal *models.Withdrawal) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := transitWithdrawal(tx, withdrawal, models.WithdrawalHeld)
+		if err != nil {
+			return err
+		}
+
+		total := withdrawal.Quantity.Add(withdrawal.Fee)
+		_, err = PostJournalEntry(tx, models.JournalWithdrawalHold, withdrawal.ID, "withdrawal hold", []LedgerLeg{
+			CreditUser(withdrawal.UserID, withdrawal.Asset, total),
+			DebitSystem(models.LedgerWithdrawalHold, withdrawal.Asset, total),
+		})
+		return err
+	})
+
+	return withdrawalErrorCode(err)
+}
+
+func ApproveWithdrawal(withdrawal *models.Withdrawal, adminID uint) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		withdrawal.ReviewerID = adminID
+		_, err := transitWithdrawal(tx, withdrawal, models.WithdrawalApproved)
+		return err
+	})
+
+	return withdrawalErrorCode(err)
+}
+
+// RejectWithdrawal 駁回提領申請，已凍結的資金會退回使用者。
+func RejectWithdrawal(withdrawal *models.Withdrawal, adminID uint, comment string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		withdrawal.ReviewerID = adminID
+		withdrawal.Comment = comment
+		previous, err := transitWithdrawal(tx, withdrawal, models.WithdrawalRejected)
+		if err != nil {
+			return err
+		}
+
+		if previous == models.WithdrawalRequested {
+			return nil
+		}
+
+		total := withdrawal.Quantity.Add(withdrawal.Fee)
+		_, err = PostJournalEntry(tx, models.JournalWithdrawalRelease, withdrawal.ID, comment, []LedgerLeg{
+			DebitUser(withdrawal.UserID, withdrawal.Asset, total),
+			CreditSystem(models.LedgerWithdrawalHold, withdrawal.Asset, total),
+		})
+		return err
+	})
+
+	return withdrawalErrorCode(err)
+}
+
+func BroadcastWithdrawal(withdrawal *models.Withdrawal, adminID uint, transactionID string) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		withdrawal.ReviewerID = adminID
+		withdrawal.TransactionID = transactionID
+		_, err := transitWithdrawal(tx, withdrawal, models.WithdrawalBroadcast)
+		return err
+	})
+
+	return withdrawalErrorCode(err)
+}
+
+// CompleteWithdrawal 鏈上交易確認後結算，凍結資金轉出並收取手續費。
+func CompleteWithdrawal(withdrawal *models.Withdrawal, adminID uint) int {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		withdrawal.ReviewerID = adminID
+		_, err := transitWithdrawal(tx, withdrawal, models.WithdrawalCompleted)
+		if err != nil {
+			return err
+		}
+
+		legs := []LedgerLeg{
+			CreditSystem(models.LedgerWithdrawalHold, withdrawal.Asset, withdrawal.Quantity.Add(withdrawal.Fee)),
+			DebitSystem(models.LedgerWithdrawal, withdrawal.Asset, withdrawal.Quantity),
+		}
+		if withdrawal.Fee.IsPositive() {
+			legs = append(legs, DebitSystem(models.LedgerWithdrawalFee, withdrawal.Asset, withdrawal.Fee))
+		}
+
+		_, err = PostJournalEntry(tx, models.JournalWithdrawalSettle, withdrawal.ID, withdrawal.TransactionID, legs)
+		return err
+	})
+
+	return withdrawalErrorCode(err)
+}
+
+// transitWithdrawal 鎖定提領申請並檢查狀態轉換，回傳轉換前的狀態。
+func transitWithdrawal(tx *gorm.DB, withdrawal *models.Withdrawal, to uint) (uint, error) {
+	var current models.Withdrawal
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", withdrawal.ID).First(&current).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if !canTransitWithdrawal(current.Status, to) {
+		return current.Status, ErrWithdrawalStatusInvalid
+	}
+
+	withdrawal.Status = to
+	err = tx.Model(&current).Updates(map[string]interface{}{
+		"status":         withdrawal.Status,
+		"reviewer_id":    withdrawal.ReviewerID,
+		"transaction_id": withdrawal.TransactionID,
+		"comment":        withdrawal.Comment,
+	}).Error
+
+	return current.Status, err
+}
+
+func canTransitWithdrawal(from, to uint) bool {
+	for _, v := range withdrawalTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withdrawalErrorCode(err error) int {
+	switch err {
+	case nil:
+		return status.Success
+	case ErrWithdrawalStatusInvalid:
+		return status.WithdrawalStatusInvalid
+	case ErrInsufficientBalance:
+		return status.InsufficientBalance
+	}
+
+	logrus.Error("Update withdrawal fail=", err)
+	return status.Unkonwn
+}
+
+func calcWithdrawalFee(quantity decimal.Decimal) decimal.Decimal {
+	rate, err := decimal.NewFromString(os.Getenv("WITHDRAWAL_FEE_RATE"))
+	if err != nil {
+		rate = decimal.Zero
+	}
+
+	minFee, err := decimal.NewFromString(os.Getenv("WITHDRAWAL_MIN_FEE"))
+	if err != nil {
+		minFee = decimal.Zero
+	}
+
+	fee := quantity.Mul(rate)
+	if fee.LessThan(minFee) {
+		fee = minFee
+	}
+
+	return fee
+}
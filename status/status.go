@@ -2,8 +2,6 @@ package status
 
 const RespStatus = "status"
 const RespData = "data"
-const SaveFileFolderPath = "./static"
-const SaveFilePublicFolderPath = "/public"
 
 const (
 	// Common 0~999
@@ -23,6 +21,8 @@ const (
 	UserHasKYC           = 12
 	TooLarge             = 13
 	UnsupportedMediaType = 14
+	ImageTooLarge        = 15
+	SendEmailFail        = 16
 	// Register
 	PasswordInvalid        = 1001
 	PasswordNotEqual       = 1002
@@ -30,20 +30,74 @@ const (
 	NotExistUser           = 1004
 	ExistUser              = 1005
 	// Login
-	IncorrectLoginInfo = 2001
-	IncorrectTFA       = 2002
+	IncorrectLoginInfo    = 2001
+	IncorrectTFA          = 2002
+	TFANotEnabled         = 2003
+	NotExistSession       = 2004
+	RefreshTokenReused    = 2005
+	TFAEnabled            = 2006
+	IncorrectRecoveryCode = 2007
+	AccountLocked         = 2008
+	InvalidUnlockToken    = 2009
+	PasswordResetRequired = 2010
 	// Admin
+	NotExistAdmin             = 3001
+	ExistAdmin                = 3002
+	InvalidPermission         = 3003
+	CannotRemoveOwnPermission = 3004
+	LastSuperAdmin            = 3005
+	CannotModifySelf          = 3006
+	RequireSuperAdmin         = 3007
+	AdminStatusNotChanged     = 3008
+	PermissionNotGrantable    = 3009
+	NotExistAdminRole         = 3010
+	ExistAdminRole            = 3011
+	BuiltInAdminRole          = 3012
 	// User
+	NotExistReferrerCode   = 4001
+	NotExistCommissionRule = 4002
+	UserStatusNotChanged   = 4003
 	// WhiteList
 	NotExistWhiteList = 5001
 	// Product
-	NotExistProduct = 6001
-	OutOfStock      = 6002
-	HasBeenRemoved  = 6003
+	NotExistProduct  = 6001
+	OutOfStock       = 6002
+	HasBeenRemoved   = 6003
+	NotBuyPermission = 6004
 	// Order
-	NotExistOrder = 7001
+	NotExistOrder        = 7001
+	OrderCannotCancel    = 7002
+	OrderCannotPay       = 7003
+	OrderCannotFail      = 7004
+	OrderCannotComplete  = 7005
+	DuplicateTransaction = 7006
+	OrderCannotRefund    = 7007
+	TokenNotRefundable   = 7008
 	// Stack
-	NotExistStack = 8001
+	NotExistStack            = 8001
+	NotExistStackRecord      = 8002
+	StackNotExpired          = 8003
+	StackRecordStatusInvalid = 8004
+	InsufficientToken        = 8005
+	StackNotExchangeable     = 8006
+	StackAutoRenew           = 8007
+	// Bank
+	InsufficientBalance = 9001
+	// Withdrawal
+	NotExistWithdrawal      = 10001
+	WithdrawalStatusInvalid = 10002
+	UnsupportedAsset        = 10003
+	// KYC
+	NotExistKYCSubmission = 11001
+	KYCNotPending         = 11002
+	KYCClaimed            = 11003
+	KYCNotClaimed         = 11004
+	InvalidKYCReviewer    = 11005
+	InvalidRejectReason   = 11006
+	NotExistKYCDocument   = 11007
+	// File
+	InvalidFileSignature = 12001
+	NotExistFile         = 12002
 )
 
 type Response struct {
@@ -74,6 +128,8 @@ var errorText = map[int]string{
 	UserHasKYC:           "使用者已通過實名認證",
 	TooLarge:             "請求文件過大",
 	UnsupportedMediaType: "不支援的檔案",
+	ImageTooLarge:        "圖片尺寸過大",
+	SendEmailFail:        "信件寄送失敗",
 	// Register
 	PasswordInvalid:        "密碼不合法",
 	PasswordNotEqual:       "密碼不一致",
@@ -81,18 +137,74 @@ var errorText = map[int]string{
 	NotExistUser:           "不存在的使用者",
 	ExistUser:              "已存在的使用者",
 	// Login
-	IncorrectLoginInfo: "帳號或密碼錯誤",
-	IncorrectTFA:       "二階段驗證碼錯誤",
+	IncorrectLoginInfo:    "帳號或密碼錯誤",
+	IncorrectTFA:          "二階段驗證碼錯誤",
+	TFANotEnabled:         "尚未啟用二階段驗證",
+	NotExistSession:       "工作階段不存在",
+	RefreshTokenReused:    "令牌已被使用，所有裝置已登出",
+	TFAEnabled:            "已啟用二階段驗證",
+	IncorrectRecoveryCode: "救援碼不正確或已使用",
+	AccountLocked:         "登入失敗次數過多，帳號暫時鎖定",
+	InvalidUnlockToken:    "解鎖連結無效或已過期",
+	PasswordResetRequired: "需要先修改密碼才能登入",
+	// Admin
+	NotExistAdmin:             "管理者不存在",
+	ExistAdmin:                "管理者帳號已被使用",
+	InvalidPermission:         "權限不合法",
+	CannotRemoveOwnPermission: "無法移除自己的管理者修改權限",
+	LastSuperAdmin:            "至少需要保留一位啟用中的超級管理者",
+	CannotModifySelf:          "無法對自己執行此操作",
+	RequireSuperAdmin:         "需要超級管理者權限",
+	AdminStatusNotChanged:     "管理者狀態未變更",
+	PermissionNotGrantable:    "無法授予自己沒有的權限",
+	NotExistAdminRole:         "角色不存在",
+	ExistAdminRole:            "角色名稱已被使用",
+	BuiltInAdminRole:          "預設角色無法刪除",
+	// User
+	NotExistReferrerCode:   "不存在的邀請碼",
+	UserStatusNotChanged:   "使用者狀態未變更",
+	NotExistCommissionRule: "不存在的推薦獎勵規則",
 	// WhiteList
 	NotExistWhiteList: "不存在的白名單",
 	// Product
-	NotExistProduct: "不存在的商品",
-	OutOfStock:      "商品庫存不足",
-	HasBeenRemoved:  "已下架",
+	NotExistProduct:  "不存在的商品",
+	OutOfStock:       "商品庫存不足",
+	HasBeenRemoved:   "已下架",
+	NotBuyPermission: "沒有購買此商品的權限",
 	// Order
-	NotExistOrder: "不存在的訂單",
+	NotExistOrder:        "不存在的訂單",
+	OrderCannotCancel:    "訂單目前的狀態無法取消",
+	OrderCannotPay:       "訂單目前的狀態無法付款",
+	OrderCannotFail:      "訂單目前的狀態無法設為付款失敗",
+	OrderCannotComplete:  "訂單目前的狀態無法完成",
+	DuplicateTransaction: "交易序號已被其他訂單使用",
+	OrderCannotRefund:    "訂單目前的狀態無法退款",
+	TokenNotRefundable:   "使用者持有的可用代幣不足，無法退款",
 	// Stack
-	NotExistStack: "不存在的質押項目",
+	NotExistStack:            "不存在的質押項目",
+	NotExistStackRecord:      "不存在的質押紀錄",
+	StackNotExpired:          "質押尚未到期",
+	StackRecordStatusInvalid: "質押紀錄的狀態不允許此操作",
+	InsufficientToken:        "可用的代幣數量不足",
+	StackNotExchangeable:     "此質押項目不支援兌換",
+	StackAutoRenew:           "質押已設定自動續約，請先取消自動續約",
+	// Bank
+	InsufficientBalance: "餘額不足",
+	// Withdrawal
+	NotExistWithdrawal:      "不存在的提領申請",
+	WithdrawalStatusInvalid: "提領申請的狀態不允許此操作",
+	UnsupportedAsset:        "不支援的資產",
+	// KYC
+	NotExistKYCSubmission: "不存在的實名送審紀錄",
+	KYCNotPending:         "實名送審紀錄不在待審核狀態",
+	KYCClaimed:            "實名送審紀錄已由其他審核者認領",
+	KYCNotClaimed:         "請先認領實名送審紀錄",
+	InvalidKYCReviewer:    "審核者不存在或沒有審核權限",
+	InvalidRejectReason:   "審核失敗原因不合法",
+	NotExistKYCDocument:   "不存在的實名照片",
+	// File
+	InvalidFileSignature: "檔案連結無效或已過期",
+	NotExistFile:         "不存在的檔案",
 }
 
 func ErrorText(code int) string {
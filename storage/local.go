@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+// Local 將物件存放在本機目錄，SignedURL 指向以 HMAC 簽章驗證的下載路由。
+type Local struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+	now        func() time.Time
+}
+
+// NewLocal baseURL 為下載路由的網址，物件的 key 會接在後面
+func NewLocal(root, baseURL string, signingKey []byte) (*Local, error) {
+	if len(signingKey) == 0 {
+		return nil, errors.New("local storage signing key is empty")
+	}
+
+	err := os.MkdirAll(root, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		root:       root,
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/",
+		signingKey: signingKey,
+		now:        time.Now,
+	}, nil
+}
+
+// Path 回傳物件在本機的路徑
+func (s *Local) Path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put 先寫入暫存檔再改名，讀取時不會看到寫到一半的檔案
+func (s *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0750)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	_, err = io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0640)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Local) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+
+	filePath, _ := s.Path(key)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, info, ErrNotFound
+	}
+	if err != nil {
+		return nil, info, err
+	}
+
+	return file, info, nil
+}
+
+func (s *Local) Delete(ctx context.Context, key string) error {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Local) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := strconv.FormatInt(s.now().Add(expires).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expiresAt)
+	query.Set("signature", s.sign(key, expiresAt))
+
+	return s.baseURL + escapeKey(key) + "?" + query.Encode(), nil
+}
+
+// Verify 驗證 SignedURL 產生的簽章與期限
+func (s *Local) Verify(key, expiresAt, signature string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	unix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || s.now().Unix() > unix {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.sign(key, expiresAt))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (s *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	stat, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if stat.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{
+		Key:         key,
+		Size:        stat.Size(),
+		ContentType: ContentTypeByKey(key),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+func (s *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		relative, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, ObjectInfo{
+			Key:         key,
+			Size:        info.Size(),
+			ContentType: ContentTypeByKey(key),
+			ModTime:     info.ModTime(),
+		})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return objects, nil
+	}
+
+	return objects, err
+}
+
+func (s *Local) sign(key, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(key + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	s, err := NewLocal(t.TempDir(), "http://localhost/api/v1/file", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = s.Put(ctx, "public/a.png", strings.NewReader("image"), -1, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, info, err := s.Get(ctx, "public/a.png")
+	if err != nil || info.Size != 5 || info.ContentType != "image/png" {
+		t.Fatalf("get fail, info=%+v, err=%v", info, err)
+	}
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(content) != "image" {
+		t.Fatalf("got %q", content)
+	}
+
+	_, err = s.Stat(ctx, "../a.png")
+	if err != ErrInvalidKey {
+		t.Fatalf("path traversal was accepted, err=%v", err)
+	}
+
+	signedURL, err := s.SignedURL(ctx, "public/a.png", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signedURL)
+	key := strings.TrimPrefix(u.Path, "/api/v1/file/")
+	err = s.Verify(key, u.Query().Get("expires"), u.Query().Get("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Verify("public/b.png", u.Query().Get("expires"), u.Query().Get("signature"))
+	if err != ErrInvalidSignature {
+		t.Fatal("signature was accepted for another key")
+	}
+
+	objects, err := s.List(ctx, "public/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "public/a.png" {
+		t.Fatalf("list fail, objects=%+v, err=%v", objects, err)
+	}
+
+	err = s.Delete(ctx, "public/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Stat(ctx, "public/a.png")
+	if err != ErrNotFound {
+		t.Fatalf("object still exists, err=%v", err)
+	}
+}
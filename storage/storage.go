@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+var ErrInvalidKey = errors.New("invalid object key")
+var ErrNotConfigured = errors.New("storage is not configured")
+
+// ObjectInfo 物件的基本資訊
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage 上傳檔案的儲存後端，key 為以「/」分隔的相對路徑，例如 public/xxx.png。
+type Storage interface {
+	// Put 寫入物件，size 未知時傳入 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// Delete 刪除物件，物件不存在時不回傳錯誤
+	Delete(ctx context.Context, key string) error
+	// SignedURL 產生在 expires 內有效、不需登入即可讀取物件的網址
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List 列出 key 以 prefix 開頭的所有物件
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+var defaultStorage Storage
+var defaultMu sync.RWMutex
+
+// SetDefault 設定上傳檔案使用的儲存後端
+func SetDefault(s Storage) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStorage = s
+}
+
+func Default() Storage {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStorage
+}
+
+// CleanKey 檢查並正規化 key，不允許跳出儲存根目錄
+func CleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+
+	return key, nil
+}
+
+// ContentTypeByKey 依副檔名判斷內容類型，無法判斷時視為二進位檔案
+func ContentTypeByKey(key string) string {
+	contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key)))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+
+	return contentType
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"invar/logs"
 	"os"
 	"strconv"
@@ -10,16 +11,24 @@ import (
 	mail "github.com/xhit/go-simple-mail"
 )
 
+// SendEmail 寄送信件，失敗時只記錄錯誤，適合在 goroutine 中寄送通知。
 func SendEmail(to string, subject string, text string) {
+	err := DeliverEmail(to, subject, text)
+	if err != nil {
+		logs.Logger().WithFields(logrus.Fields{
+			"name": "Smtp",
+		}).Error("Expected nil, got ", err)
+	}
+}
+
+// DeliverEmail 寄送信件並回傳錯誤，呼叫端需要確認信件已寄出時使用。
+func DeliverEmail(to string, subject string, text string) error {
 	from := os.Getenv("EMAIL_ACCOUNT")
 	pass := os.Getenv("EMAIL_PASSWORD")
 	server := os.Getenv("EMAIL_SERVER")
 	port, err := strconv.Atoi(os.Getenv("EMAIL_PORT"))
 	if err != nil {
-		logs.Logger().WithFields(logrus.Fields{
-			"name": "Smtp",
-		}).Error("Expected nil, got", err, "for port convert to int.")
-		return
+		return errors.New("convert port to int: " + err.Error())
 	}
 
 	client := mail.NewSMTPClient()
@@ -33,21 +42,13 @@ func SendEmail(to string, subject string, text string) {
 	client.KeepAlive = false
 
 	smtpClient, err := client.Connect()
-
 	if err != nil {
-		logs.Logger().WithFields(logrus.Fields{
-			"name": "Smtp",
-		}).Error("Expected nil, got", err, "generating email")
-		return
+		return errors.New("connect smtp: " + err.Error())
 	}
 
 	err = smtpClient.Noop()
-
 	if err != nil {
-		logs.Logger().WithFields(logrus.Fields{
-			"name": "Smtp",
-		}).Error("Expected nil, got", err, "noop to client")
-		return
+		return errors.New("noop to client: " + err.Error())
 	}
 
 	email := mail.NewMSG()
@@ -57,20 +58,13 @@ func SendEmail(to string, subject string, text string) {
 	email.SetBody(mail.TextPlain, text)
 
 	if email.Error != nil {
-		logs.Logger().WithFields(logrus.Fields{
-			"name": "Smtp",
-		}).Error("Expected nil, got", err, "generating email")
-		return
+		return errors.New("generate email: " + email.Error.Error())
 	}
 
 	err = email.Send(smtpClient)
-
-	email.GetError()
-
 	if err != nil {
-		logs.Logger().WithFields(logrus.Fields{
-			"name": "Smtp",
-		}).Error("Expected nil, got", err, "sending email")
-		return
+		return errors.New("send email: " + err.Error())
 	}
+
+	return nil
 }
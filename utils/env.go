@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration 讀取時間長度設定，例如 1h、30m，未設定或不合法時使用 fallback
+func EnvDuration(name string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+
+	return duration
+}
+
+// EnvInt 讀取正整數設定，未設定或不合法時使用 fallback
+func EnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	envelopeVersion   = 1
+	envelopeChunkSize = 64 * 1024
+	envelopePrefixLen = 7
+	envelopeKeyLen    = 32
+)
+
+var ErrEnvelopeInvalid = errors.New("envelope is invalid or was tampered")
+
+// GenerateDataKey 產生加密單一檔案用的資料金鑰
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, envelopeKeyLen)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// WrapKey 以主金鑰加密資料金鑰，associated 需在解開時提供相同的值，用來綁定金鑰所屬的檔案。
+func WrapKey(masterKey, dataKey, associated []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, associated), nil
+}
+
+func UnwrapKey(masterKey, wrappedKey, associated []byte) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, ErrEnvelopeInvalid
+	}
+
+	nonce, ciphertext := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, associated)
+	if err != nil {
+		return nil, ErrEnvelopeInvalid
+	}
+
+	return dataKey, nil
+}
+
+// EncryptStream 將內容切成固定大小的區塊分別以 AES-GCM 加密，解密時可逐塊驗證後輸出。
+// nonce 由隨機前綴、區塊序號與是否為最後一塊組成，區塊被重排或截斷都會驗證失敗。
+// 只有最後一塊會小於區塊大小，內容剛好為區塊大小的倍數時最後一塊為空。
+func EncryptStream(dst io.Writer, src io.Reader, dataKey []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 1+envelopePrefixLen)
+	header[0] = envelopeVersion
+	_, err = rand.Read(header[1:])
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(header)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, envelopeChunkSize)
+	sealed := make([]byte, 0, envelopeChunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		sealed = aead.Seal(sealed[:0], envelopeNonce(header[1:], counter, last), buf[:n], nil)
+		_, err = dst.Write(sealed)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+
+		if counter == ^uint32(0) {
+			return errors.New("envelope content is too large")
+		}
+	}
+}
+
+// DecryptStream 逐塊驗證並解密 EncryptStream 的輸出，驗證失敗時回傳 ErrEnvelopeInvalid。
+func DecryptStream(dst io.Writer, src io.Reader, dataKey []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 1+envelopePrefixLen)
+	_, err = io.ReadFull(src, header)
+	if err != nil || header[0] != envelopeVersion {
+		return ErrEnvelopeInvalid
+	}
+
+	buf := make([]byte, envelopeChunkSize+aead.Overhead())
+	plain := make([]byte, 0, envelopeChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		if err == io.EOF {
+			// 缺少最後一塊，內容被截斷
+			return ErrEnvelopeInvalid
+		}
+
+		last := err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		plain, err = aead.Open(plain[:0], envelopeNonce(header[1:], counter, last), buf[:n], nil)
+		if err != nil {
+			return ErrEnvelopeInvalid
+		}
+
+		_, err = dst.Write(plain)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+func envelopeNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, envelopePrefixLen+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[envelopePrefixLen:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
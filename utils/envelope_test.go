@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEnvelopeStream(t *testing.T) {
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, envelopeChunkSize, envelopeChunkSize*2 + 10} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		var encrypted bytes.Buffer
+		err = EncryptStream(&encrypted, bytes.NewReader(content), dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decrypted bytes.Buffer
+		err = DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), dataKey)
+		if err != nil || !bytes.Equal(decrypted.Bytes(), content) {
+			t.Fatalf("size %d: decrypt fail, err=%v", size, err)
+		}
+
+		// 截掉最後一塊時不能被當成完整內容
+		if size >= envelopeChunkSize {
+			truncated := encrypted.Bytes()[:1+envelopePrefixLen+envelopeChunkSize+16]
+			err = DecryptStream(&bytes.Buffer{}, bytes.NewReader(truncated), dataKey)
+			if err != ErrEnvelopeInvalid {
+				t.Fatalf("size %d: truncated content was accepted", size)
+			}
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	masterKey, _ := GenerateDataKey()
+	dataKey, _ := GenerateDataKey()
+
+	wrapped, err := WrapKey(masterKey, dataKey, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := UnwrapKey(masterKey, wrapped, []byte("a"))
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrap fail, err=%v", err)
+	}
+
+	_, err = UnwrapKey(masterKey, wrapped, []byte("b"))
+	if err != ErrEnvelopeInvalid {
+		t.Fatal("key was unwrapped with different associated data")
+	}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"invar/status"
 	"os"
 	"path"
 	"strings"
@@ -9,21 +8,6 @@ import (
 	"github.com/google/uuid"
 )
 
-func GenerateNewFilePathAndRemoveOld(fileName, savePath, oldFilePath string, fileSupportTypes []int) (string, int) {
-	if !CheckMediaType(fileName, fileSupportTypes) {
-		return "", status.UnsupportedMediaType
-	}
-
-	fileSavePath := FilePathGenerator(fileName, savePath)
-
-	err := RemoveFile(oldFilePath)
-	if err != nil {
-		return "", status.Unkonwn
-	}
-
-	return fileSavePath, status.Success
-}
-
 func FilePathGenerator(file string, savePath string) string {
 	fileExt := strings.ToLower(path.Ext(file))
 	fileName := fileNameGenerator() + fileExt
@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const jpegQuality = 90
+
+var ErrUnsupportedImage = errors.New("unsupported or corrupted image")
+var ErrImageTooLarge = errors.New("image dimensions exceed the limit")
+
+var sniffMediaTypes = map[string]int{
+	"image/png":  PNG,
+	"image/jpeg": JPEG,
+	"image/gif":  GIF,
+	"video/mp4":  MP4,
+}
+
+// ProcessedImage 重新編碼後的圖片，原始檔案的 EXIF、GPS 等中繼資料不會保留。
+type ProcessedImage struct {
+	Data        []byte
+	Width       int
+	Height      int
+	ContentType string
+	Ext         string
+	image       *image.NRGBA
+}
+
+// DetectMediaType 以檔案開頭的 magic bytes 判斷類型，不參考副檔名。
+func DetectMediaType(data []byte) (int, bool) {
+	mediaType, ok := sniffMediaTypes[http.DetectContentType(data)]
+	return mediaType, ok
+}
+
+// CheckContentType 判斷檔案內容是否為支援的類型，JPG 與 JPEG 視為相同。
+func CheckContentType(data []byte, supportTypes []int) bool {
+	mediaType, ok := DetectMediaType(data)
+	if !ok {
+		return false
+	}
+
+	for _, v := range supportTypes {
+		if v == mediaType || (v == JPG && mediaType == JPEG) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProcessImage 驗證並重新編碼圖片。解碼前先檢查尺寸，避免解壓縮炸彈佔用大量記憶體；
+// JPEG 會依 EXIF 方向轉正後再移除中繼資料，GIF 只保留第一格並輸出為 PNG。
+func ProcessImage(data []byte, supportTypes []int, maxDimension, maxPixels int) (ProcessedImage, error) {
+	if !CheckContentType(data, supportTypes) {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	if config.Width > maxDimension || config.Height > maxDimension || config.Width*config.Height > maxPixels {
+		return ProcessedImage{}, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	img := toNRGBA(src)
+	if format == "jpeg" {
+		img = orientImage(img, jpegOrientation(data))
+	}
+
+	return encodeImage(img, format == "jpeg")
+}
+
+// Thumbnail 等比例縮小到長寬都不超過 maxSize，圖片已經夠小時只重新編碼。
+func Thumbnail(img ProcessedImage, maxSize int) (ProcessedImage, error) {
+	if img.image == nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	width, height := img.Width, img.Height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = atLeast(height*maxSize/width, 1)
+			width = maxSize
+		} else {
+			width = atLeast(width*maxSize/height, 1)
+			height = maxSize
+		}
+	}
+
+	return encodeImage(resizeImage(img.image, width, height), img.ContentType == "image/jpeg")
+}
+
+func encodeImage(img *image.NRGBA, asJPEG bool) (ProcessedImage, error) {
+	var buf bytes.Buffer
+	processed := ProcessedImage{
+		Width:  img.Rect.Dx(),
+		Height: img.Rect.Dy(),
+		image:  img,
+	}
+
+	if asJPEG {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		processed.ContentType = "image/jpeg"
+		processed.Ext = ".jpg"
+	} else {
+		err := png.Encode(&buf, img)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		processed.ContentType = "image/png"
+		processed.Ext = ".png"
+	}
+
+	processed.Data = buf.Bytes()
+	return processed, nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	bounds := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Rect, src, bounds.Min, draw.Src)
+	return img
+}
+
+// resizeImage 以區域平均縮小圖片，透明度作為權重避免邊緣出現黑邊
+func resizeImage(src *image.NRGBA, width, height int) *image.NRGBA {
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	if width == srcWidth && height == srcHeight {
+		return src
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := atLeast((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := atLeast((x+1)*srcWidth/width, x0+1)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					pixel := src.Pix[offset : offset+4]
+					alpha := uint64(pixel[3])
+					r += uint64(pixel[0]) * alpha
+					g += uint64(pixel[1]) * alpha
+					b += uint64(pixel[2]) * alpha
+					a += alpha
+					count++
+					offset += 4
+				}
+			}
+
+			pixel := dst.Pix[dst.PixOffset(x, y) : dst.PixOffset(x, y)+4]
+			if a > 0 {
+				pixel[0] = uint8(r / a)
+				pixel[1] = uint8(g / a)
+				pixel[2] = uint8(b / a)
+			}
+			pixel[3] = uint8(a / count)
+		}
+	}
+
+	return dst
+}
+
+// orientImage 依 EXIF Orientation 1~8 將圖片轉為正常方向
+func orientImage(src *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	width, height := srcWidth, srcHeight
+	if orientation >= 5 {
+		width, height = srcHeight, srcWidth
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = srcWidth-1-x, y
+			case 3:
+				sx, sy = srcWidth-1-x, srcHeight-1-y
+			case 4:
+				sx, sy = x, srcHeight-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, srcHeight-1-x
+			case 7:
+				sx, sy = srcWidth-1-y, srcHeight-1-x
+			case 8:
+				sx, sy = srcWidth-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation 從 APP1 的 EXIF 讀取 Orientation，找不到時回傳 1
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		// SOS 之後是影像資料，EXIF 只會出現在前面
+		if marker == 0xDA || length < 2 || offset+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		offset += 2 + length
+	}
+
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		// 0x0112 Orientation，型別 SHORT
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
+
+func atLeast(value, min int) int {
+	if value < min {
+		return min
+	}
+
+	return value
+}
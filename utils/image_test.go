@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+// withEXIF 在 SOI 之後插入只有 Orientation 與 GPS 指標的 EXIF 區段
+func withEXIF(data []byte, orientation uint16) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 2,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation >> 8), byte(orientation), 0, 0,
+		0x88, 0x25, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, 0xFF, 0xE1, byte(length>>8), byte(length))
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func TestProcessImage(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, testImage(40, 20), nil)
+	data := withEXIF(buf.Bytes(), 6)
+
+	img, err := ProcessImage(data, []int{PNG, JPG, JPEG}, 100, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Width != 20 || img.Height != 40 || img.ContentType != "image/jpeg" {
+		t.Fatalf("unexpected image %dx%d %s", img.Width, img.Height, img.ContentType)
+	}
+
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Fatal("exif metadata was not stripped")
+	}
+
+	thumbnail, err := Thumbnail(img, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbnail.Width != 5 || thumbnail.Height != 10 {
+		t.Fatalf("unexpected thumbnail %dx%d", thumbnail.Width, thumbnail.Height)
+	}
+
+	_, err = ProcessImage(data, []int{PNG, JPEG}, 30, 10000)
+	if err != ErrImageTooLarge {
+		t.Fatal("expected dimension limit, got", err)
+	}
+
+	_, err = ProcessImage(data, []int{PNG}, 100, 10000)
+	if err != ErrUnsupportedImage {
+		t.Fatal("expected unsupported type, got", err)
+	}
+
+	_, err = ProcessImage(append([]byte("\x89PNG\r\n\x1a\n"), []byte("not an image")...), []int{PNG}, 100, 10000)
+	if err != ErrUnsupportedImage {
+		t.Fatal("expected decode failure, got", err)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	src := testImage(3, 2)
+	for orientation := 1; orientation <= 8; orientation++ {
+		dst := orientImage(src, orientation)
+		if dst.Rect.Dx()*dst.Rect.Dy() != 6 {
+			t.Fatalf("orientation %d: unexpected size %v", orientation, dst.Rect)
+		}
+	}
+
+	dst := orientImage(src, 6)
+	if dst.NRGBAAt(1, 0) != src.NRGBAAt(0, 0) {
+		t.Fatal("orientation 6 should rotate clockwise")
+	}
+
+	dst = orientImage(src, 8)
+	if dst.NRGBAAt(0, 2) != src.NRGBAAt(0, 0) {
+		t.Fatal("orientation 8 should rotate counterclockwise")
+	}
+}
+
+func TestThumbnailKeepsPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(30, 30))
+
+	img, err := ProcessImage(buf.Bytes(), []int{PNG}, 100, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnail, err := Thumbnail(img, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbnail.ContentType != "image/png" || thumbnail.Width != 10 || thumbnail.Height != 10 {
+		t.Fatalf("unexpected thumbnail %dx%d %s", thumbnail.Width, thumbnail.Height, thumbnail.ContentType)
+	}
+}
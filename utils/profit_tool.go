@@ -1 +1,20 @@
 package utils
+
+import (
+	"invar/models"
+
+	"github.com/shopspring/decimal"
+)
+
+const ProfitPrecision = 8
+
+// CalcStackProfit 計算一期的配息，Stack.Profit 為年利率，依配息週期的月數比例發放，小數位數無條件捨去。
+func CalcStackProfit(stack *models.Stack, quantity uint) decimal.Decimal {
+	months := decimal.NewFromInt(int64(stack.ProfitIntervalMonth))
+	profit := decimal.NewFromInt(int64(quantity)).
+		Mul(stack.Profit).
+		Mul(months).
+		Div(decimal.NewFromInt(12))
+
+	return profit.Truncate(ProfitPrecision)
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"invar/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCalcStackProfit(t *testing.T) {
+	stack := models.Stack{Profit: decimal.RequireFromString("0.1"), ProfitIntervalMonth: 1}
+
+	got := CalcStackProfit(&stack, 100)
+	want := decimal.RequireFromString("0.83333333")
+	if !got.Equal(want) {
+		t.Errorf("CalcStackProfit() = %v, want %v", got, want)
+	}
+}
+
+func TestProfitPeriods(t *testing.T) {
+	start := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	stack := models.Stack{ProfitIntervalMonth: 3, ContractTimeBound: 12}
+	record := models.StackRecord{
+		ProfitStartTime:   start,
+		NextGetProfitTime: AddMonths(start, 3),
+		EndGetProfitTime:  AddMonths(start, 12),
+	}
+
+	periods := 0
+	for HasDueProfit(&record, &stack, start.AddDate(5, 0, 0)) {
+		periods++
+		SetProfitTime(&record, &stack)
+	}
+
+	if periods != 4 {
+		t.Errorf("expected 4 profit periods, got %d", periods)
+	}
+}
+
+func TestProfitPeriodsAtMonthEnd(t *testing.T) {
+	start := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+	stack := models.Stack{ProfitIntervalMonth: 1, ContractTimeBound: 4}
+	record := models.StackRecord{
+		ProfitStartTime:   start,
+		NextGetProfitTime: AddMonths(start, 1),
+		EndGetProfitTime:  AddMonths(start, 4),
+	}
+
+	want := []time.Time{
+		time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC),
+		time.Date(2022, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2022, 4, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2022, 5, 31, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, period := range want {
+		if !record.NextGetProfitTime.Equal(period) {
+			t.Fatalf("expected profit period %v, got %v", period, record.NextGetProfitTime)
+		}
+		SetProfitTime(&record, &stack)
+	}
+}
@@ -1,7 +1,50 @@
 package utils
 
-import "invar/models"
+import (
+	"invar/models"
+	"time"
+)
 
-func SetProfitTime(stackRecord *models.StackRecord) {
+// SetProfitTime 將下次配息時間推進一個配息週期。
+func SetProfitTime(stackRecord *models.StackRecord, stack *models.Stack) {
+	if stack.ProfitIntervalMonth == 0 {
+		return
+	}
 
+	stackRecord.NextGetProfitTime = AddPeriod(stackRecord.ProfitStartTime, stackRecord.NextGetProfitTime, int(stack.ProfitIntervalMonth))
+}
+
+// AddPeriod 回傳 current 再加 months 個月的時間，以 start 起算第 n 個月，1/31 起算的各期為 2/28、3/31、4/30。
+// 逐期以 AddMonths 推進時月底的日期會被縮短且無法恢復。start 為零值時由 current 推進。
+func AddPeriod(start, current time.Time, months int) time.Time {
+	if start.IsZero() {
+		return AddMonths(current, months)
+	}
+
+	elapsed := (current.Year()-start.Year())*12 + int(current.Month()) - int(start.Month())
+	return AddMonths(start, elapsed+months)
+}
+
+// AddMonths 增加月份，日期超過目標月份的天數時取該月最後一天，避免 1/31 加一個月變成 3/3。
+func AddMonths(t time.Time, months int) time.Time {
+	firstDay := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	target := firstDay.AddDate(0, months, 0)
+	lastDay := target.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return target.AddDate(0, 0, day-1)
+}
+
+// HasDueProfit 檢查質押紀錄在 now 時是否有尚未發放的配息，超過 EndGetProfitTime 的期數不配息。
+func HasDueProfit(stackRecord *models.StackRecord, stack *models.Stack, now time.Time) bool {
+	if stack.ProfitIntervalMonth == 0 || stackRecord.NextGetProfitTime.IsZero() {
+		return false
+	}
+
+	next := stackRecord.NextGetProfitTime
+	return !next.After(now) && !next.After(stackRecord.EndGetProfitTime)
 }
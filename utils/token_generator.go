@@ -1,21 +1,25 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"math/rand"
-	"time"
+	"encoding/hex"
 )
 
 func GenerateRefreshToken(createLen int) (string, error) {
-	rand.Seed(time.Now().UnixNano())
+	bytes := make([]byte, createLen)
 
-	bytes := []byte{}
-	bytes = make([]byte, createLen)
-
-	_, err := rand.Read(bytes[:])
+	_, err := rand.Read(bytes)
 	if err != nil {
 		return "", err
 	}
 
-	return base64.URLEncoding.EncodeToString(bytes[:]), nil
+	return base64.URLEncoding.EncodeToString(bytes), nil
+}
+
+// HashToken 令牌本身為高熵亂數，以 SHA-256 雜湊後儲存即可。
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }